@@ -7,6 +7,8 @@ type AuxLib interface {
 	/* Error-report functions */
 	Error2(fmt string, a ...interface{}) int
 	ArgError(arg int, extraMsg string) int
+	Where(level int)
+	Traceback(L1 LuaState, msg string, level int)
 	/* Argument check functions */
 	CheckStack2(sz int, msg string)
 	ArgCheck(cond bool, arg int, extraMsg string)
@@ -15,6 +17,7 @@ type AuxLib interface {
 	CheckInteger(arg int) int64
 	CheckNumber(arg int) float64
 	CheckString(arg int) string
+	CheckUdata(arg int, tname string) interface{}
 	OptInteger(arg int, d int64) int64
 	OptNumber(arg int, d float64) float64
 	OptString(arg int, d string) string
@@ -31,6 +34,9 @@ type AuxLib interface {
 	GetSubTable(idx int, fname string) bool
 	GetMetafield(obj int, e string) LuaType
 	CallMeta(obj int, e string) bool
+	NewMetatable(tname string) bool
+	SetMetatableByName(tname string)
+	TestUdata(arg int, tname string) interface{}
 	OpenLibs()
 	RequireF(modname string, openf GoFunction, glb bool)
 	NewLib(l FuncReg)
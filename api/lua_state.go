@@ -1,6 +1,10 @@
 package api
 
-import . "goluar/common"
+import (
+	"fmt"
+	. "goluar/common"
+	"io"
+)
 
 type LuaType = int
 type ArithOp = int
@@ -8,10 +12,32 @@ type CompareOp = int
 
 type GoFunction func(LuaState) int
 
+/*
+	The continuation of a go function which calls CallK, PCallK or YieldK. The go function
+	can not be resumed after a yield, so k is called instead when the coroutine goes on, with
+	the stack of the go function, the ctx given to the call and the status:
+		LUA_YIELD	the call returned (or the coroutine is resumed after YieldK)
+		LUA_ERRRUN	PCallK caught an error, the error object is on the top
+	k returns the number of results of the go function, as a GoFunction does.
+*/
+type KFunction func(ls LuaState, status, ctx int) int
+
 func LuaUpvalueIndex(i int) int {
 	return LUA_REGISTRYINDEX - i
 }
 
+/*
+	The error raised by os.exit. It is not caught by PCall or coroutines, the panic
+	unwinds to the host, which recovers it and decides what the exit code means.
+*/
+type ExitError struct {
+	Code int
+}
+
+func (self *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", self.Code)
+}
+
 type LuaState interface {
 	BasicAPI
 	AuxLib
@@ -45,6 +71,8 @@ type BasicAPI interface {
 	IsThread(idx int) bool
 	IsFunction(idx int) bool
 	IsGoFunction(idx int) bool
+	IsUserData(idx int) bool
+	IsLightUserData(idx int) bool
 	ToBoolean(idx int) bool
 	ToInteger(idx int) int64
 	ToIntegerX(idx int) (int64, bool)
@@ -54,6 +82,7 @@ type BasicAPI interface {
 	ToStringX(idx int) (string, bool)
 	ToGoFunction(idx int) GoFunction
 	ToThread(idx int) LuaState
+	ToUserData(idx int) interface{} // the go value of the full or light userdata
 	ToPointer(idx int) interface{}
 	RawLen(idx int) uint
 	/* push functions (Go -> stack) */
@@ -67,6 +96,9 @@ type BasicAPI interface {
 	PushGoClosure(f GoFunction, n int) //pop n args from the stack, make f and args to a closure, push to the stack.
 	PushGlobalTable()
 	PushThread() bool
+	NewUserData(value interface{}) // push a new userdata holding the go value
+	PushLightUserData(p interface{})
+	PushGoValue(value interface{}) // push the go value, binding structs, pointers, maps, slices and funcs as userdata
 	/* Comparison and arithmetic functions */
 	Arith(op ArithOp)
 	Compare(idx1, idx2 int, op CompareOp) bool
@@ -81,6 +113,7 @@ type BasicAPI interface {
 	RawGetI(idx int, i int64) LuaType
 	GetMetatable(idx int) bool
 	GetGlobal(name string) LuaType //Get global variable by the varaible name from the global table. Gloabl table is stored in registry.get(LUA_RIDX_GLOBALS).
+	GetFenv(idx int)               //Push the environment table of the function or the thread at idx.
 	/* set functions (stack -> Lua) */
 	SetTable(idx int)
 	SetField(idx int, k string)
@@ -90,21 +123,32 @@ type BasicAPI interface {
 	SetMetatable(idx int)
 	SetGlobal(name string)              //Set value in the top of the stack to a global variable pointed by the varaible in from the global table.
 	Register(name string, f GoFunction) // push the go function to the top of the stack. And set the go function as value and name as key in the global table.
+	SetFenv(idx int) bool               //Pop a table and set it as the environment of the function or the thread at idx.
 	/* 'load' and 'call' functions (load and run Lua code) */
 	Load(chunk []byte, chunkName, mode string) int
+	Dump(w io.Writer) int //Write the lua function on the top of the stack as a binary chunk.
 	Call(nArgs, nResults int)
+	CallK(nArgs, nResults, ctx int, k KFunction) // Call which the callee can yield across, see KFunction
 	PCall(nArgs, nResults, msgh int) int
+	PCallK(nArgs, nResults, msgh, ctx int, k KFunction) int // PCall which the callee can yield across
+	XPCall(nArgs, nResults int, msgh GoFunction) int        // PCall with the go function as the message handler
 	/* miscellaneous functions */
 	Len(idx int)
 	Concat(n int)
 	Next(idx int) bool
 	Error() int
 	StringToNumber(s string) bool
+	SeedRandom(seed int64)                  // seed the random generator shared by the threads of the state
+	Random() float64                        // the next number of the random generator, in [0, 1)
+	Marshal(value interface{}) error        // push the go value, its structs, maps, slices and arrays as nested tables
+	Unmarshal(idx int, v interface{}) error // decode the value at idx into the go value pointed by v
 	/* coroutine functions */
 	NewThread() LuaState
 	Resume(from LuaState, nArgs int) int
 	Yield(nResults int) int
+	YieldK(nResults, ctx int, k KFunction) int // Yield with k to go on when the coroutine is resumed
 	Status() int
 	IsYieldable() bool
-	GetStack() bool // debug
+	GetStack() bool                  // debug
+	GetStackFunction(level int) bool // debug, push the function running at the level of the call stack
 }
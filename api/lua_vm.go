@@ -11,4 +11,6 @@ type LuaVM interface {
 	LoadVararg(n int)
 	LoadProto(idx int)
 	CloseUpvalues(a int)
+	PreCall(nArgs, nResults int) bool         // call a go function, or enter a lua function in the interpreter loop
+	RunError(format string, a ...interface{}) // raise an error with the current line
 }
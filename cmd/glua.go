@@ -1,10 +1,301 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"goluar/vm"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	progName  = "glua"
+	version   = "Goluar 5.1"
+	copyright = "Copyright (C) 2021 Guangyuan Wang"
+	chunkMode = "btv" // scripts could be text or binary, binary chunks are verified
+)
 
 /*
 	The entrance of executing lua file. File name is provided in the arguments.
+	The command line is compatible with the reference 'lua' command:
+		glua [options] [script [args]]
+	Available options are:
+		-e stat  execute string 'stat'
+		-l name  require library 'name'
+		-i       enter interactive mode after executing 'script'
+		-v       show version information
+		--       stop handling options
+		-        execute stdin and stop handling options
 */
 func main() {
-	fmt.Println("Goluar")
+	ls := vm.New()
+	ls.OpenLibs()
+	os.Exit(run(ls, os.Args))
+}
+
+/*
+	@description
+		Run the command line, return the exit status of the process.
+	@param
+		ls		LuaState	"the state which runs the chunks"
+		argv	[]string	"the command line, argv[0] is the name of the interpreter"
+	@return
+		status	int		"0 on success, 1 on failure, or the code passed to os.exit"
+*/
+func run(ls LuaState, argv []string) (status int) {
+	defer func() {
+		if err := recover(); err != nil {
+			e, ok := err.(*ExitError)
+			if !ok {
+				panic(err)
+			}
+			status = e.Code
+		}
+	}()
+
+	script, hasI, hasV, hasE, ok := collectArgs(argv)
+	if !ok {
+		printUsage()
+		return 1
+	}
+	if hasV {
+		printVersion()
+	}
+	if !runArgs(ls, argv, script) {
+		return 1
+	}
+	if script > 0 {
+		if handleScript(ls, argv, script) != LUA_OK {
+			return 1
+		}
+	}
+	if hasI {
+		doREPL(ls)
+	} else if script == 0 && !hasE && !hasV {
+		if isTerminal(os.Stdin) {
+			printVersion()
+			doREPL(ls)
+		} else if doStdin(ls) != LUA_OK {
+			return 1
+		}
+	}
+	return 0
+}
+
+/*
+	@description
+		Check the options in the command line.
+	@return
+		script	int		"index of the script in argv, 0 if there is no script"
+		hasI	bool	"whether -i is given"
+		hasV	bool	"whether -v is given"
+		hasE	bool	"whether -e is given"
+		ok		bool	"false if the command line is malformed"
+*/
+func collectArgs(argv []string) (script int, hasI, hasV, hasE, ok bool) {
+	for i := 1; i < len(argv); i++ {
+		arg := argv[i]
+		if len(arg) == 0 || arg[0] != '-' { /* not an option? */
+			return i, hasI, hasV, hasE, true
+		}
+		switch arg {
+		case "-":
+			return i, hasI, hasV, hasE, true
+		case "--":
+			if i+1 < len(argv) {
+				return i + 1, hasI, hasV, hasE, true
+			}
+			return 0, hasI, hasV, hasE, true
+		case "-i":
+			hasI = true
+			hasV = true
+		case "-v":
+			hasV = true
+		case "-e", "-l":
+			if arg == "-e" {
+				hasE = true
+			}
+			if i+1 >= len(argv) {
+				return 0, hasI, hasV, hasE, false
+			}
+			i++ /* skip the argument of the option */
+		default:
+			if strings.HasPrefix(arg, "-e") {
+				hasE = true
+			} else if !strings.HasPrefix(arg, "-l") {
+				return 0, hasI, hasV, hasE, false
+			}
+		}
+	}
+	return 0, hasI, hasV, hasE, true
+}
+
+/*
+	@description
+		Execute the -e and -l options in order, stop at the script.
+	@return
+		ok	bool	"false if one of the options failed"
+*/
+func runArgs(ls LuaState, argv []string, script int) bool {
+	n := len(argv)
+	if script > 0 {
+		n = script
+	}
+	for i := 1; i < n; i++ {
+		arg := argv[i]
+		if len(arg) < 2 || (arg[1] != 'e' && arg[1] != 'l') {
+			continue
+		}
+		value := arg[2:]
+		if value == "" {
+			i++
+			value = argv[i]
+		}
+		var status int
+		if arg[1] == 'e' {
+			status = doString(ls, value, "=(command line)")
+		} else {
+			status = doLibrary(ls, value)
+		}
+		if status != LUA_OK {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+	@description
+		Build the global 'arg' table, load the script and call it with the script arguments.
+		arg[0] is the script name, arguments before the script have negative indices.
+*/
+func handleScript(ls LuaState, argv []string, script int) int {
+	ls.CreateTable(len(argv)-script-1, script)
+	for i, arg := range argv {
+		ls.PushString(arg)
+		ls.RawSetI(-2, int64(i-script))
+	}
+	ls.SetGlobal("arg")
+
+	var status int
+	fname := argv[script]
+	if fname == "-" && (script == 1 || argv[script-1] != "--") {
+		status = loadStdin(ls)
+	} else {
+		status = ls.LoadFileX(fname, chunkMode)
+	}
+	if status == LUA_OK {
+		nArgs := len(argv) - script - 1
+		ls.CheckStack2(nArgs, "too many arguments to script")
+		for _, arg := range argv[script+1:] {
+			ls.PushString(arg)
+		}
+		status = docall(ls, nArgs, LUA_MULTRET)
+	}
+	return report(ls, status)
+}
+
+// Load and run the chunk stored in a string.
+func doString(ls LuaState, chunk, chunkName string) int {
+	status := ls.Load([]byte(chunk), chunkName, "bt")
+	if status == LUA_OK {
+		status = docall(ls, 0, 0)
+	}
+	return report(ls, status)
+}
+
+// Call require(name) and store the result in the global variable 'name'.
+func doLibrary(ls LuaState, name string) int {
+	ls.GetGlobal("require")
+	ls.PushString(name)
+	status := docall(ls, 1, 1)
+	if status == LUA_OK {
+		ls.SetGlobal(name)
+	}
+	return report(ls, status)
+}
+
+// Load and run the chunk read from the standard input.
+func doStdin(ls LuaState) int {
+	status := loadStdin(ls)
+	if status == LUA_OK {
+		status = docall(ls, 0, 0)
+	}
+	return report(ls, status)
+}
+
+func loadStdin(ls LuaState) int {
+	data, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		ls.PushString(fmt.Sprintf("cannot read stdin: %v", err))
+		return LUA_ERRFILE
+	}
+	if len(data) > 0 && data[0] == '#' { /* skip the shebang line */
+		if i := strings.IndexByte(string(data), '\n'); i >= 0 {
+			data = data[i:]
+		} else {
+			data = nil
+		}
+	}
+	return ls.Load(data, "=stdin", chunkMode)
+}
+
+/*
+	@description
+		Print the error message at the top of the stack if status is not LUA_OK.
+	@return
+		status	int		"the status passed in"
+*/
+// Call the function in protected mode, the error message gets a traceback.
+func docall(ls LuaState, nArgs, nResults int) int {
+	return ls.XPCall(nArgs, nResults, traceback)
+}
+
+// The message handler which appends a traceback to the error message.
+func traceback(ls LuaState) int {
+	if !ls.IsString(1) { /* 'message' not a string? */
+		return 1 /* keep it intact */
+	}
+	ls.Traceback(ls, ls.ToString(1), 1)
+	return 1
+}
+
+func report(ls LuaState, status int) int {
+	if status != LUA_OK && !ls.IsNil(-1) {
+		msg, ok := ls.ToStringX(-1)
+		if !ok {
+			msg = "(error object is not a string)"
+		}
+		printMessage(msg)
+		ls.Pop(1)
+	}
+	return status
+}
+
+func printMessage(msg string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progName, msg)
+}
+
+func printVersion() {
+	fmt.Printf("%s  %s\n", version, copyright)
+}
+
+func printUsage() {
+	fmt.Fprintf(os.Stderr, `usage: %s [options] [script [args]].
+Available options are:
+  -e stat  execute string 'stat'
+  -l name  require library 'name'
+  -i       enter interactive mode after executing 'script'
+  -v       show version information
+  --       stop handling options
+  -        execute stdin and stop handling options
+`, progName)
+}
+
+// Whether the file is a terminal, instead of a pipe or a regular file.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
 }
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	. "goluar/common"
+	"goluar/compiler"
+	"goluar/vm"
+	"io/ioutil"
+	"os"
+)
+
+const (
+	progName  = "gluac"
+	version   = "Goluar 5.1"
+	copyright = "Copyright (C) 2021 Guangyuan Wang"
+	output    = "luac.out" // default output file
+)
+
+/*
+	The compiler command, compatible with the reference 'luac' command:
+		gluac [options] [filenames]
+	Available options are:
+		-        process stdin
+		-l       list
+		-o name  output to file 'name' (default is "luac.out")
+		-p       parse only
+		-s       strip debug information
+		-v       show version information
+		--       stop handling options
+	'-l -l' also lists the constants, local variables and upvalues.
+	If more than one file is given, the chunks are combined into one main function
+	which runs them in order.
+*/
+func main() {
+	os.Exit(run(os.Args))
+}
+
+// Options of the command line.
+type options struct {
+	output    string   // the output file
+	listing   int      // -l, twice for the full listing
+	parseOnly bool     // -p
+	strip     bool     // -s
+	files     []string // the input files, "-" is stdin
+}
+
+/*
+	@description
+		Run the command line, return the exit status of the process.
+	@return
+		status	int		"0 on success, 1 on failure"
+*/
+func run(argv []string) (status int) {
+	defer func() {
+		if err := recover(); err != nil {
+			printMessage(fmt.Sprint(err))
+			status = 1
+		}
+	}()
+
+	opts, ok := doArgs(argv)
+	if !ok {
+		return 1
+	}
+	if len(opts.files) == 0 {
+		return 0 // only -v
+	}
+
+	protos := make([]*FuncProto, len(opts.files))
+	for i, file := range opts.files {
+		protos[i] = compileFile(file)
+	}
+	main := combine(protos)
+	if opts.listing > 0 {
+		vm.PrintFunction(os.Stdout, main, opts.listing > 1)
+	}
+	if opts.parseOnly {
+		return 0
+	}
+
+	chunk := DumpBinaryChunk(main, opts.strip)
+	if err := ioutil.WriteFile(opts.output, chunk, 0644); err != nil {
+		panic(fmt.Sprintf("cannot write %s", opts.output))
+	}
+	return 0
+}
+
+/*
+	@description
+		Parse the options in the command line.
+	@return
+		opts	*options	"the options and the input files"
+		ok		bool		"false if the command line is malformed, the usage is printed"
+*/
+func doArgs(argv []string) (opts *options, ok bool) {
+	opts = &options{output: output}
+	hasV := false
+	i := 1
+	for ; i < len(argv); i++ {
+		arg := argv[i]
+		if len(arg) == 0 || arg[0] != '-' { /* end of options; keep it */
+			break
+		} else if arg == "--" { /* end of options; skip it */
+			i++
+			break
+		} else if arg == "-" { /* end of options; use stdin */
+			break
+		} else if arg == "-l" { /* list */
+			opts.listing++
+		} else if arg == "-o" { /* output file */
+			i++
+			if i >= len(argv) || argv[i] == "--" || (len(argv[i]) > 0 && argv[i][0] == '-' && argv[i] != "-") {
+				printUsage("'-o' needs argument")
+				return nil, false
+			}
+			opts.output = argv[i]
+		} else if arg == "-p" { /* parse only */
+			opts.parseOnly = true
+		} else if arg == "-s" { /* strip debug information */
+			opts.strip = true
+		} else if arg == "-v" { /* show version */
+			hasV = true
+		} else { /* unknown option */
+			printUsage(fmt.Sprintf("unrecognized option '%s'", arg))
+			return nil, false
+		}
+	}
+	opts.files = argv[i:]
+	if hasV {
+		fmt.Printf("%s  %s\n", version, copyright)
+	} else if len(opts.files) == 0 {
+		printUsage("no input files given")
+		return nil, false
+	}
+	return opts, true
+}
+
+/*
+	@description
+		Compile the source file, or the standard input if the name is "-".
+		The chunk name is "@file" for files and "=stdin" for the standard input.
+		Errors are raised by panic with the message.
+*/
+func compileFile(file string) *FuncProto {
+	var data []byte
+	var err error
+	chunkName := "@" + file
+	if file == "-" {
+		chunkName = "=stdin"
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		panic(fmt.Sprintf("cannot open %s", file))
+	}
+	if IsBinaryChunk(data) {
+		proto, err := LoadBinaryChunk(data)
+		if err == nil {
+			err = vm.Verify(proto)
+		}
+		if err != nil {
+			panic(fmt.Sprintf("%s: %s", file, err))
+		}
+		return proto
+	}
+	if len(data) > 0 && data[0] == '#' { /* skip the shebang line, keep the line number */
+		for len(data) > 0 && data[0] != '\n' {
+			data = data[1:]
+		}
+	}
+	return compiler.Compile(string(data), chunkName)
+}
+
+/*
+	@description
+		Combine several main functions into one, which calls them in order:
+			CLOSURE 0 i
+			GETUPVAL 0 j	(one for each upvalue of the chunk, passed through)
+			CALL 0 1 1
+			...
+			RETURN 0 1
+		A single main function is returned as it is.
+*/
+func combine(protos []*FuncProto) *FuncProto {
+	if len(protos) == 1 {
+		return protos[0]
+	}
+	code := make([]uint32, 0, 2*len(protos)+1)
+	nUpvals := byte(0)
+	for i, p := range protos {
+		code = append(code, uint32(i<<14|OP_CLOSURE))
+		p.Upvalues = make([]Upvalue, p.UpvalueCount)
+		for j := range p.Upvalues {
+			p.Upvalues[j] = Upvalue{Instack: 0, Idx: byte(j)}
+			code = append(code, uint32(j<<23|OP_GETUPVAL))
+		}
+		if p.UpvalueCount > nUpvals {
+			nUpvals = p.UpvalueCount
+		}
+		code = append(code, uint32(1<<23|1<<14|OP_CALL))
+	}
+	code = append(code, uint32(1<<23|OP_RETURN))
+	return &FuncProto{
+		Source:       "=(" + progName + ")",
+		UpvalueCount: nUpvals,
+		MaxStackSize: 1,
+		Instructions: code,
+		Constants:    []interface{}{},
+		Protos:       protos,
+	}
+}
+
+func printMessage(msg string) {
+	fmt.Fprintf(os.Stderr, "%s: %s\n", progName, msg)
+}
+
+func printUsage(msg string) {
+	printMessage(msg)
+	fmt.Fprintf(os.Stderr, `usage: %s [options] [filenames].
+Available options are:
+  -        process stdin
+  -l       list
+  -o name  output to file 'name' (default is "%s")
+  -p       parse only
+  -s       strip debug information
+  -v       show version information
+  --       stop handling options
+`, progName, output)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Returned by ReadLine when the user cancels the line with Ctrl-C.
+var errInterrupt = errors.New("interrupted")
+
+/*
+	Reader of the lines typed in the interactive mode.
+*/
+type lineReader interface {
+	ReadLine(prompt string) (string, error)
+	AddHistory(line string)
+	Close()
+}
+
+/*
+	@description
+		Create a line editor with history if both stdin and stdout are terminals
+		which can be switched to raw mode. Otherwise lines are read as they are.
+*/
+func newLineReader() lineReader {
+	in := bufio.NewReader(os.Stdin)
+	if isTerminal(os.Stdin) && isTerminal(os.Stdout) {
+		fd := int(os.Stdin.Fd())
+		if restore, err := makeRaw(fd); err == nil {
+			restore()
+			return &termReader{in: in, out: os.Stdout, fd: fd}
+		}
+	}
+	return &plainReader{in: in}
+}
+
+/*
+	History of the lines entered in the session.
+	Lines of a multi-line chunk are stored one by one.
+*/
+type history struct {
+	lines []string
+}
+
+func (self *history) AddHistory(chunk string) {
+	for _, line := range strings.Split(chunk, "\n") {
+		n := len(self.lines)
+		if line == "" || n > 0 && self.lines[n-1] == line {
+			continue
+		}
+		self.lines = append(self.lines, line)
+	}
+}
+
+/*
+	Read lines from a pipe or a dumb terminal.
+*/
+type plainReader struct {
+	history
+	in *bufio.Reader
+}
+
+func (self *plainReader) ReadLine(prompt string) (string, error) {
+	fmt.Print(prompt)
+	line, err := self.in.ReadString('\n')
+	if line == "" && err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (self *plainReader) Close() {}
+
+/*
+	A small line editor working in the raw mode of the terminal.
+	Supported keys:
+		left/right, Ctrl-B/Ctrl-F		move the cursor
+		home/end, Ctrl-A/Ctrl-E			move to the begin/end of the line
+		up/down, Ctrl-P/Ctrl-N			walk through the history
+		backspace, delete, Ctrl-D		delete a character, Ctrl-D on an empty line is EOF
+		Ctrl-K/Ctrl-U					delete to the end/begin of the line
+		Ctrl-C							cancel the line
+*/
+type termReader struct {
+	history
+	in  *bufio.Reader
+	out *os.File
+	fd  int
+}
+
+func (self *termReader) ReadLine(prompt string) (string, error) {
+	restore, err := makeRaw(self.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	var buf []rune
+	pos := 0
+	hIdx := len(self.lines)
+	var edited []rune // the line being edited before walking through the history
+	setLine := func(s []rune) {
+		buf = append([]rune{}, s...)
+		pos = len(buf)
+	}
+
+	self.refresh(prompt, buf, pos)
+	for {
+		r, _, err := self.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			self.out.WriteString("\r\n")
+			return string(buf), nil
+		case 3: // Ctrl-C
+			self.out.WriteString("^C\r\n")
+			return "", errInterrupt
+		case 4: // Ctrl-D
+			if len(buf) == 0 {
+				self.out.WriteString("\r\n")
+				return "", io.EOF
+			}
+			if pos < len(buf) {
+				buf = append(buf[:pos], buf[pos+1:]...)
+			}
+		case 127, 8: // backspace
+			if pos > 0 {
+				buf = append(buf[:pos-1], buf[pos:]...)
+				pos--
+			}
+		case 1: // Ctrl-A
+			pos = 0
+		case 5: // Ctrl-E
+			pos = len(buf)
+		case 2: // Ctrl-B
+			if pos > 0 {
+				pos--
+			}
+		case 6: // Ctrl-F
+			if pos < len(buf) {
+				pos++
+			}
+		case 11: // Ctrl-K
+			buf = buf[:pos]
+		case 21: // Ctrl-U
+			buf = append([]rune{}, buf[pos:]...)
+			pos = 0
+		case 16: // Ctrl-P
+			r = 'A'
+			fallthrough
+		case 14: // Ctrl-N
+			if r == 14 {
+				r = 'B'
+			}
+			hIdx, edited = self.walkHistory(r, hIdx, buf, edited, setLine)
+		case 27: // escape sequence
+			key := self.readEscape()
+			switch key {
+			case 'A', 'B':
+				hIdx, edited = self.walkHistory(key, hIdx, buf, edited, setLine)
+			case 'C':
+				if pos < len(buf) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			case 'H':
+				pos = 0
+			case 'F':
+				pos = len(buf)
+			case '~': // delete
+				if pos < len(buf) {
+					buf = append(buf[:pos], buf[pos+1:]...)
+				}
+			}
+		default:
+			if r >= ' ' {
+				buf = append(buf, 0)
+				copy(buf[pos+1:], buf[pos:])
+				buf[pos] = r
+				pos++
+			}
+		}
+		self.refresh(prompt, buf, pos)
+	}
+}
+
+/*
+	@description
+		Move to the previous ('A') or the next ('B') line in the history.
+	@return
+		hIdx	int		"the new position in the history"
+		edited	[]rune	"the line being edited before walking through the history"
+*/
+func (self *termReader) walkHistory(key rune, hIdx int, buf, edited []rune,
+	setLine func([]rune)) (int, []rune) {
+	if key == 'A' && hIdx > 0 {
+		if hIdx == len(self.lines) {
+			edited = append([]rune{}, buf...)
+		}
+		hIdx--
+		setLine([]rune(self.lines[hIdx]))
+	} else if key == 'B' && hIdx < len(self.lines) {
+		hIdx++
+		if hIdx == len(self.lines) {
+			setLine(edited)
+		} else {
+			setLine([]rune(self.lines[hIdx]))
+		}
+	}
+	return hIdx, edited
+}
+
+/*
+	@description
+		Decode the escape sequence after ESC.
+		Arrow keys are returned as 'A' 'B' 'C' 'D', home and end as 'H' 'F', delete as '~'.
+*/
+func (self *termReader) readEscape() rune {
+	r, _, err := self.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	r, _, err = self.in.ReadRune()
+	if err != nil {
+		return 0
+	}
+	if r < '0' || r > '9' {
+		return r
+	}
+	code := r
+	for r != '~' { // ESC [ n ~
+		if r, _, err = self.in.ReadRune(); err != nil {
+			return 0
+		}
+	}
+	switch code {
+	case '1', '7':
+		return 'H'
+	case '4', '8':
+		return 'F'
+	case '3':
+		return '~'
+	}
+	return 0
+}
+
+// Redraw the line and put the cursor at pos.
+func (self *termReader) refresh(prompt string, buf []rune, pos int) {
+	s := "\r" + prompt + string(buf) + "\x1b[K"
+	if n := len(buf) - pos; n > 0 {
+		s += fmt.Sprintf("\x1b[%dD", n)
+	}
+	self.out.WriteString(s)
+}
+
+func (self *termReader) Close() {}
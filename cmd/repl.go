@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"strings"
+)
+
+const (
+	defaultPrompt  = "> "
+	defaultPrompt2 = ">> "
+)
+
+/*
+	@description
+		The interactive mode, like 'lua -i'.
+		1. Read a line, try to compile 'return <line>' so that expressions are echoed.
+		2. Otherwise compile the line as statements. If the chunk is incomplete,
+		   keep reading lines with the '>>' prompt until it compiles or fails.
+		3. Run the chunk, and print the results by calling the global 'print'.
+		The lines entered are kept in the history of the session.
+*/
+func doREPL(ls LuaState) {
+	lr := newLineReader()
+	defer lr.Close()
+	for {
+		ls.SetTop(0)
+		status, ok := loadLine(ls, lr)
+		if !ok {
+			break
+		}
+		if status == LUA_OK {
+			status = docall(ls, 0, LUA_MULTRET)
+		}
+		if status == LUA_OK && ls.GetTop() > 0 {
+			printResults(ls)
+		}
+		report(ls, status)
+	}
+	ls.SetTop(0)
+	fmt.Println()
+}
+
+/*
+	@description
+		Read a complete chunk and load it.
+	@return
+		status	int		"status of Load, the function or the error message is on the stack"
+		ok		bool	"false if there is no more input"
+*/
+func loadLine(ls LuaState, lr lineReader) (status int, ok bool) {
+	line, err := lr.ReadLine(getPrompt(ls, true))
+	for err == errInterrupt { /* discard the line and start over */
+		line, err = lr.ReadLine(getPrompt(ls, true))
+	}
+	if err != nil {
+		return 0, false
+	}
+	if strings.HasPrefix(line, "=") { /* 'lua 5.1' way of printing expressions */
+		line = "return " + line[1:]
+	} else if ls.Load([]byte("return "+line), "=stdin", "bt") == LUA_OK {
+		lr.AddHistory(line)
+		return LUA_OK, true
+	} else {
+		ls.Pop(1) /* not an expression, remove the error message */
+	}
+
+	for {
+		status = ls.Load([]byte(line), "=stdin", "bt")
+		if !incomplete(ls, status) {
+			break
+		}
+		more, err := lr.ReadLine(getPrompt(ls, false))
+		if err == errInterrupt {
+			ls.Pop(1) /* remove the error message */
+			ls.PushString("interrupted!")
+			lr.AddHistory(line)
+			return LUA_ERRSYNTAX, true
+		}
+		if err != nil { /* no more input, the error message is reported */
+			break
+		}
+		ls.Pop(1) /* remove the error message */
+		line += "\n" + more
+	}
+	lr.AddHistory(line)
+	return status, true
+}
+
+/*
+	@description
+		Check whether the error at the top of the stack is caused by an incomplete chunk.
+		The parser reports the end of the chunk as '<eof>', so an incomplete chunk fails
+		with a message ending with "near '<eof>'".
+*/
+func incomplete(ls LuaState, status int) bool {
+	if status != LUA_ERRSYNTAX {
+		return false
+	}
+	msg, _ := ls.ToStringX(-1)
+	return strings.HasSuffix(msg, "'<eof>'")
+}
+
+// Use the global _PROMPT and _PROMPT2 if they are set.
+func getPrompt(ls LuaState, first bool) string {
+	name, prompt := "_PROMPT", defaultPrompt
+	if !first {
+		name, prompt = "_PROMPT2", defaultPrompt2
+	}
+	ls.GetGlobal(name)
+	if s, ok := ls.ToStringX(-1); ok {
+		prompt = s
+	}
+	ls.Pop(1)
+	return prompt
+}
+
+/*
+	@description
+		Print all values on the stack with the global 'print' function.
+		If 'print' is not available, the values are converted by ToString2 and
+		separated by tabs.
+*/
+func printResults(ls LuaState) {
+	n := ls.GetTop()
+	ls.GetGlobal("print")
+	if ls.IsFunction(-1) {
+		ls.Insert(1)
+		if ls.PCall(n, 0, 0) != LUA_OK {
+			msg, _ := ls.ToStringX(-1)
+			printMessage(fmt.Sprintf("error calling 'print' (%s)", msg))
+		}
+		return
+	}
+	ls.Pop(1)
+	strs := make([]string, n)
+	for i := 1; i <= n; i++ {
+		strs[i-1] = ls.ToString2(i)
+		ls.Pop(1)
+	}
+	fmt.Println(strings.Join(strs, "\t"))
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package main
+
+import "errors"
+
+// The raw mode is only supported on linux, other systems fall back to plain line reading.
+func makeRaw(fd int) (restore func(), err error) {
+	return nil, errors.New("raw mode is not supported")
+}
@@ -45,6 +45,12 @@ import (
 	|	Constants			|  |
 	-------------------------  |
 	|	Protos				|  |
+	-------------------------  |
+	|	LineInfo			|  |
+	-------------------------  |
+	|	LocVars				|  |
+	-------------------------  |
+	|	UpvalueNames		|  |
 	+-----------------------+ ---
 
 	Header
@@ -54,11 +60,14 @@ import (
 					The value combined by major version and minor version only. For exmaple, "0x51" is the value of the 5.1.1 version.
 	format:			The format number of chunk. The Lua offical format number is 0.
 	endian:			Big endian is 0. Little endian is 1.
-	int size:		The size of int type is 4 bytes.
-	sizet size:		The size of size_t type is 8 bytes.
+	int size:		The size of int type, 4 or 8 bytes. It is the size of line numbers and counts.
+	sizet size:		The size of size_t type, 4 or 8 bytes. It is the size of string lengths.
 	instruction size:	The size of lua virtual machine instuction. The Value is 4 bytes.
-	lua number size:	The size of lua number is 8 bytes.
+	lua number size:	The size of lua number, 4 or 8 bytes for float, 1, 2, 4 or 8 bytes for integer.
 	flat:			Float is 0. Integer is 1. Default value 0.
+	The chunks written by DumpBinaryChunk use the native values: little endian, 4, 8, 4, 8, 0.
+	The loader accepts the chunks of other platforms produced by the official luac 5.1,
+	the sizes and the byte order are taken from the header.
 
 	Function proto
 	Source:			The name of source code file. The value is combined by length+1 and string which is the file name.
@@ -72,16 +81,24 @@ import (
 	MaxStackSize:	The number of registers. This value is generated during compiling by Lua Virtual Machine.
 					The registers is implemented by stack, so this field is also called 'max stack size'.
 	Instructions:	The table of instructions. Each instruction occupy 4 bytes.The virtural machine instruction, which are compiled from the source instructions in function.
-	Constants:		Store literals in lua, support types: nil, boolean, integer, number, short string, long string. The first byte of the constant
+	Constants:		Store literals in lua, support types: nil, boolean, number, string. The first byte of the constant
 					is the tag of those type.
 					tag				literal type
 					0x00			nil
 					0x01			boolean
 					0x03			number
-					0x13			integer
-					0x04			short string
-					0x14			long string
+					0x04			string
 	Protos:			The array of sub function protos.
+	LineInfo:		The line number of each instruction.
+	LocVars:		The local variables, name and the range of pc where the variable is active.
+	UpvalueNames:	The names of upvalues.
+	LineInfo, LocVars and UpvalueNames are debug information, they are empty in a stripped chunk.
+
+	There is no list of upvalue descriptors in the chunk. Each CLOSURE instruction is followed by one
+	pseudo instruction for each upvalue of the new closure, which tells where the upvalue comes from:
+		MOVE 0 B		the local variable in the register B of the enclosing function
+		GETUPVAL 0 B	the upvalue B of the enclosing function
+	The loader restores the Upvalues of the sub function protos from them.
 */
 
 /*
@@ -100,9 +117,17 @@ type FuncProto struct {
 	LineInfo     []uint32
 	LocVars      []LocVar
 	UpvalueNames []string
+	Upvalues     []Upvalue // where the upvalues come from, empty for the main function
 	Protos       []*FuncProto
 }
 
+/* masks for IsVararg */
+const (
+	VARARG_HASARG   = 1 // the function has the 'arg' parameter of lua 5.0
+	VARARG_ISVARARG = 2 // the function is declared with '...'
+	VARARG_NEEDSARG = 4 // the 'arg' table should be created when the function is called
+)
+
 /*
 	Check whether is binary chunk by verifying the signature.
 */
@@ -112,14 +137,54 @@ func IsBinaryChunk(data []byte) bool {
 }
 
 /*
-	Load binary chunk, check header first and load the function proto.
+	@description
+		Load binary chunk, check header first and load the function proto.
+		A malformed or truncated chunk is reported by an error of type ChunkError.
+	@param
+		data	[]byte		"the binary chunk"
+	@return
+		proto	*FuncProto	"the proto of the main function"
+		err		error		"nil, or one of the ChunkError values"
 */
-func LoadBinaryChunk(data []byte) *FuncProto {
-	loader := &loader{data}
+func LoadBinaryChunk(data []byte) (proto *FuncProto, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(ChunkError)
+			if !ok {
+				panic(r)
+			}
+			proto, err = nil, e
+		}
+	}()
+	loader := &loader{data: data}
 	loader.checkHeader()
-	return loader.readProto("")
+	return loader.readProto(""), nil
 }
 
+/*
+	Errors of loading binary chunk. They can be compared with the values below.
+*/
+type ChunkError string
+
+func (self ChunkError) Error() string {
+	return string(self)
+}
+
+const (
+	ErrTruncated       ChunkError = "truncated precompiled chunk"
+	ErrSignature       ChunkError = "not a precompiled chunk"
+	ErrVersion         ChunkError = "version mismatch in precompiled chunk"
+	ErrFormat          ChunkError = "format mismatch in precompiled chunk"
+	ErrEndianness      ChunkError = "endianness mismatch in precompiled chunk"
+	ErrIntSize         ChunkError = "int size mismatch in precompiled chunk"
+	ErrSizetSize       ChunkError = "size_t size mismatch in precompiled chunk"
+	ErrInstructionSize ChunkError = "instruction size mismatch in precompiled chunk"
+	ErrNumberSize      ChunkError = "lua_Number size mismatch in precompiled chunk"
+	ErrIntegralFlag    ChunkError = "integral flag mismatch in precompiled chunk"
+	ErrConstantTag     ChunkError = "bad constant in precompiled chunk"
+	ErrUpvalues        ChunkError = "bad upvalues in precompiled chunk"
+)
+
 /*
 	The structure of binary chunk.
 */
@@ -156,12 +221,10 @@ const (
 )
 
 const (
-	TAG_NIL       = 0x00
-	TAG_BOOLEAN   = 0x01
-	TAG_NUMBER    = 0x03
-	TAG_INTEGER   = 0x13
-	TAG_SHORT_STR = 0x04
-	TAG_LONG_STR  = 0x14
+	TAG_NIL     = 0x00
+	TAG_BOOLEAN = 0x01
+	TAG_NUMBER  = 0x03
+	TAG_STRING  = 0x04
 )
 
 /*
@@ -170,7 +233,7 @@ const (
 	There are three kinds of variables in lua, such as local variable, upvalue, global variable.
 	Local variable is defined in a function.
 	Upvalue is defined in a enclosing function, and used by a nesting function.
-	Global variable is defined in _ENV.
+	Global variable is a field of the environment table of the function, see getfenv and setfenv.
 */
 type Upvalue struct {
 	Instack byte
@@ -181,7 +244,12 @@ type Upvalue struct {
 	Loader of binary chunk which is stored in a byte array.
 */
 type loader struct {
-	data []byte // store binary chunk
+	data       []byte           // store binary chunk
+	order      binary.ByteOrder // byte order of the chunk
+	intSize    byte             // size of int
+	sizetSize  byte             // size of size_t
+	numberSize byte             // size of lua Number
+	integral   bool             // whether lua Number is an integer type
 }
 
 type LocVar struct {
@@ -215,23 +283,40 @@ type LocVar struct {
 */
 func (self *loader) checkHeader() {
 	if string(self.readBytes(4)) != SIGNATURE {
-		panic("not a precompiled chunk!")
+		panic(ErrSignature)
 	} else if self.readByte() != VERSION {
-		panic("version mismatch!")
+		panic(ErrVersion)
 	} else if self.readByte() != FORMAT {
-		panic("format mismatch!")
-	} else if self.readByte() != ENDIAN {
-		panic("endianness mismatch!")
-	} else if self.readByte() != INT_SIZE {
-		panic("int size mismatch!")
-	} else if self.readByte() != SIZET_SIZE {
-		panic("size_t size mismatch!")
+		panic(ErrFormat)
+	}
+	switch self.readByte() {
+	case 0:
+		self.order = binary.BigEndian
+	case 1:
+		self.order = binary.LittleEndian
+	default:
+		panic(ErrEndianness)
+	}
+	if self.intSize = self.readByte(); self.intSize != 4 && self.intSize != 8 {
+		panic(ErrIntSize)
+	} else if self.sizetSize = self.readByte(); self.sizetSize != 4 && self.sizetSize != 8 {
+		panic(ErrSizetSize)
 	} else if self.readByte() != INSTRUCTION_SIZE {
-		panic("instruction size mismatch!")
-	} else if self.readByte() != LUA_NUMBER_SIZE {
-		panic("lua_Number size mismatch!")
-	} else if self.readByte() != FLAT {
-		panic("endianness mismatch!")
+		panic(ErrInstructionSize)
+	}
+	self.numberSize = self.readByte()
+	switch self.readByte() {
+	case 0:
+		if self.numberSize != 4 && self.numberSize != 8 {
+			panic(ErrNumberSize)
+		}
+	case 1:
+		self.integral = true
+		if self.numberSize != 1 && self.numberSize != 2 && self.numberSize != 4 && self.numberSize != 8 {
+			panic(ErrNumberSize)
+		}
+	default:
+		panic(ErrIntegralFlag)
 	}
 }
 
@@ -256,6 +341,8 @@ func (self *loader) checkHeader() {
 	|	Constants			|  |
 	-------------------------  |
 	|	Protos				|  |
+	-------------------------  |
+	|	Debug info			|  |
 	Return function proto from binary chunk.
 */
 func (self *loader) readProto(parentSource string) *FuncProto {
@@ -263,8 +350,8 @@ func (self *loader) readProto(parentSource string) *FuncProto {
 	if source == "" {
 		source = parentSource
 	}
-	tmpStartLine := self.readUint32()
-	tmpEndLine := self.readUint32()
+	tmpStartLine := uint32(self.readInt())
+	tmpEndLine := uint32(self.readInt())
 	tmpUpvalueCount := self.readByte()
 	tmpNumParams := self.readByte()
 	tmpIsVararg := self.readByte()
@@ -275,6 +362,7 @@ func (self *loader) readProto(parentSource string) *FuncProto {
 	tmpLineInfo := self.readLineInfo()
 	tmpLocVars := self.readLocVars()
 	tmpUpvalueNames := self.readUpvalueNames()
+	setUpvalues(tmpInstructions, tmpProtos)
 	return &FuncProto{
 		Source:       source,
 		StartLine:    tmpStartLine,
@@ -296,6 +384,9 @@ func (self *loader) readProto(parentSource string) *FuncProto {
 	Return 1 byte from data.
 */
 func (self *loader) readByte() byte {
+	if len(self.data) < 1 {
+		panic(ErrTruncated)
+	}
 	b := self.data[0]
 	self.data = self.data[1:]
 	return b
@@ -305,48 +396,84 @@ func (self *loader) readByte() byte {
 	Return n bytes from data.
 */
 func (self *loader) readBytes(n uint) []byte {
+	if uint(len(self.data)) < n {
+		panic(ErrTruncated)
+	}
 	bytes := self.data[:n]
 	self.data = self.data[n:]
 	return bytes
 }
 
 /*
-	Return a uint32 value from data.
+	Return an unsigned integer of 1, 2, 4 or 8 bytes from data, in the byte order of the chunk.
+*/
+func (self *loader) readUint(size byte) uint64 {
+	b := self.readBytes(uint(size))
+	switch size {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(self.order.Uint16(b))
+	case 4:
+		return uint64(self.order.Uint32(b))
+	default:
+		return self.order.Uint64(b)
+	}
+}
+
+/*
+	Return a signed integer of 1, 2, 4 or 8 bytes from data.
+*/
+func (self *loader) readSigned(size byte) int64 {
+	i := self.readUint(size)
+	shift := 64 - 8*uint(size)
+	return int64(i<<shift) >> shift // sign extension
+}
+
+/*
+	Return a C int value from data. Its size is given by the header.
 */
-func (self *loader) readUint32() uint32 {
-	i := binary.LittleEndian.Uint32(self.data)
-	self.data = self.data[4:]
-	return i
+func (self *loader) readInt() int {
+	return int(self.readSigned(self.intSize))
 }
 
 /*
-	Return a uint64 value from data.
+	Return the count of the following elements. Each element takes at least 1 byte,
+	so a count larger than the rest of data means the chunk is truncated or corrupted.
 */
-func (self *loader) readUint64() uint64 {
-	i := binary.LittleEndian.Uint64(self.data)
-	self.data = self.data[8:]
-	return i
+func (self *loader) readCount() int {
+	n := self.readInt()
+	if n < 0 || n > len(self.data) {
+		panic(ErrTruncated)
+	}
+	return n
 }
 
 /*
-	Return a uint32 value from data.
+	Return a size_t value from data. Its size is given by the header.
 */
-func (self *loader) readLuaInteger() int64 {
-	return int64(self.readUint32())
+func (self *loader) readSizeT() uint64 {
+	return self.readUint(self.sizetSize)
 }
 
 /*
-	Return a float64 value from data.
+	Return a lua Number from data. It is a float or an integer of the size given by the header.
 */
 func (self *loader) readLuaNumber() float64 {
-	return math.Float64frombits(self.readUint64())
+	if self.integral {
+		return float64(self.readSigned(self.numberSize))
+	}
+	if self.numberSize == 4 {
+		return float64(math.Float32frombits(uint32(self.readUint(4))))
+	}
+	return math.Float64frombits(self.readUint(8))
 }
 
 /*
 	Return string from data.
 */
 func (self *loader) readString() string {
-	size := uint(self.readUint64())
+	size := uint(self.readSizeT())
 	if size == 0 {
 		return ""
 	}
@@ -359,9 +486,9 @@ func (self *loader) readString() string {
 	Return insturctions from data.
 */
 func (self *loader) readInstructions() []uint32 {
-	code := make([]uint32, self.readUint32())
+	code := make([]uint32, self.readCount())
 	for i := range code {
-		code[i] = self.readUint32()
+		code[i] = uint32(self.readUint(INSTRUCTION_SIZE))
 	}
 	return code
 }
@@ -370,7 +497,7 @@ func (self *loader) readInstructions() []uint32 {
 	Return constants from data.
 */
 func (self *loader) readConstants() []interface{} {
-	constants := make([]interface{}, self.readUint32())
+	constants := make([]interface{}, self.readCount())
 	for i := range constants {
 		constants[i] = self.readConstant()
 	}
@@ -386,14 +513,12 @@ func (self *loader) readConstant() interface{} {
 		return nil
 	case TAG_BOOLEAN:
 		return self.readByte() != 0
-	case TAG_INTEGER:
-		return self.readLuaInteger()
 	case TAG_NUMBER:
 		return self.readLuaNumber()
-	case TAG_SHORT_STR, TAG_LONG_STR:
+	case TAG_STRING:
 		return self.readString()
 	default:
-		panic("corrupted!") // todo
+		panic(ErrConstantTag)
 	}
 }
 
@@ -401,20 +526,52 @@ func (self *loader) readConstant() interface{} {
 	Return funtion protos from data.
 */
 func (self *loader) readProtos(parentSource string) []*FuncProto {
-	protos := make([]*FuncProto, self.readUint32())
+	protos := make([]*FuncProto, self.readCount())
 	for i := range protos {
 		protos[i] = self.readProto(parentSource)
 	}
 	return protos
 }
 
+/*
+	Restore the Upvalues of the sub functions from the pseudo instructions after CLOSURE.
+	Panic with ErrUpvalues if they are missing.
+*/
+func setUpvalues(code []uint32, protos []*FuncProto) {
+	for pc := 0; pc < len(code); pc++ {
+		if code[pc]&0x3F != OP_CLOSURE {
+			continue
+		}
+		bx := int(code[pc] >> 14)
+		if bx >= len(protos) {
+			continue // the verifier reports it
+		}
+		proto := protos[bx]
+		proto.Upvalues = make([]Upvalue, proto.UpvalueCount)
+		for i := range proto.Upvalues {
+			pc++
+			if pc >= len(code) {
+				panic(ErrUpvalues)
+			}
+			switch idx := byte(code[pc] >> 23); code[pc] & 0x3F {
+			case OP_MOVE:
+				proto.Upvalues[i] = Upvalue{Instack: 1, Idx: idx}
+			case OP_GETUPVAL:
+				proto.Upvalues[i] = Upvalue{Instack: 0, Idx: idx}
+			default:
+				panic(ErrUpvalues)
+			}
+		}
+	}
+}
+
 /*
 	Return n byte from data.
 */
 func (self *loader) readLineInfo() []uint32 {
-	lineInfo := make([]uint32, self.readUint32())
+	lineInfo := make([]uint32, self.readCount())
 	for i := range lineInfo {
-		lineInfo[i] = self.readUint32()
+		lineInfo[i] = uint32(self.readInt())
 	}
 	return lineInfo
 }
@@ -423,12 +580,12 @@ func (self *loader) readLineInfo() []uint32 {
 	Return n byte from data.
 */
 func (self *loader) readLocVars() []LocVar {
-	locVars := make([]LocVar, self.readUint32())
+	locVars := make([]LocVar, self.readCount())
 	for i := range locVars {
 		locVars[i] = LocVar{
 			VarName: self.readString(),
-			StartPC: self.readUint32(),
-			EndPC:   self.readUint32(),
+			StartPC: uint32(self.readInt()),
+			EndPC:   uint32(self.readInt()),
 		}
 	}
 	return locVars
@@ -438,7 +595,7 @@ func (self *loader) readLocVars() []LocVar {
 	Return n byte from data.
 */
 func (self *loader) readUpvalueNames() []string {
-	names := make([]string, self.readUint32())
+	names := make([]string, self.readCount())
 	for i := range names {
 		names[i] = self.readString()
 	}
@@ -0,0 +1,167 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+)
+
+/*
+	@description
+		Serialize the function proto to a binary chunk, the reverse of LoadBinaryChunk.
+		The layout is described at the top of binary_chunk.go.
+	@param
+		proto	*FuncProto	"the proto of the main function"
+		strip	bool		"whether to drop the debug information: source, line info, local variables and upvalue names"
+	@return
+		chunk	[]byte		"the binary chunk"
+*/
+func DumpBinaryChunk(proto *FuncProto, strip bool) []byte {
+	dumper := &dumper{strip: strip}
+	dumper.writeHeader()
+	dumper.writeProto(proto, "")
+	return dumper.buf.Bytes()
+}
+
+/*
+	Dumper of binary chunk which writes to a byte buffer.
+*/
+type dumper struct {
+	buf   bytes.Buffer // store binary chunk
+	strip bool         // whether to drop the debug information
+}
+
+/*
+	Write the header of binary chunk, the same as the one checked by the loader.
+*/
+func (self *dumper) writeHeader() {
+	self.buf.WriteString(SIGNATURE)
+	self.writeByte(VERSION)
+	self.writeByte(FORMAT)
+	self.writeByte(ENDIAN)
+	self.writeByte(INT_SIZE)
+	self.writeByte(SIZET_SIZE)
+	self.writeByte(INSTRUCTION_SIZE)
+	self.writeByte(LUA_NUMBER_SIZE)
+	self.writeByte(FLAT)
+}
+
+/*
+	Write the function proto. The source of a sub function is omitted (size 0) if it is
+	the same as the source of its parent, the loader will take the parent's source.
+*/
+func (self *dumper) writeProto(proto *FuncProto, parentSource string) {
+	if self.strip || proto.Source == parentSource {
+		self.writeUint64(0) // no source
+	} else {
+		self.writeString(proto.Source)
+	}
+	self.writeUint32(proto.StartLine)
+	self.writeUint32(proto.EndLine)
+	self.writeByte(proto.UpvalueCount)
+	self.writeByte(proto.NumParams)
+	self.writeByte(proto.IsVararg)
+	self.writeByte(proto.MaxStackSize)
+	self.writeInstructions(proto.Instructions)
+	self.writeConstants(proto.Constants)
+	self.writeUint32(uint32(len(proto.Protos)))
+	for _, p := range proto.Protos {
+		self.writeProto(p, proto.Source)
+	}
+	if self.strip {
+		self.writeUint32(0) // line info
+		self.writeUint32(0) // local variables
+		self.writeUint32(0) // upvalue names
+		return
+	}
+	self.writeInstructions(proto.LineInfo)
+	self.writeLocVars(proto.LocVars)
+	self.writeUint32(uint32(len(proto.UpvalueNames)))
+	for _, name := range proto.UpvalueNames {
+		self.writeString(name)
+	}
+}
+
+func (self *dumper) writeByte(b byte) {
+	self.buf.WriteByte(b)
+}
+
+func (self *dumper) writeUint32(i uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], i)
+	self.buf.Write(b[:])
+}
+
+func (self *dumper) writeUint64(i uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], i)
+	self.buf.Write(b[:])
+}
+
+func (self *dumper) writeLuaNumber(n float64) {
+	self.writeUint64(math.Float64bits(n))
+}
+
+/*
+	Write the size of the string including the trailing '\0', and then the string.
+*/
+func (self *dumper) writeString(s string) {
+	self.writeUint64(uint64(len(s) + 1))
+	self.buf.WriteString(s)
+	self.writeByte(0)
+}
+
+/*
+	Write the count and the elements, used by instructions and line info.
+*/
+func (self *dumper) writeInstructions(code []uint32) {
+	self.writeUint32(uint32(len(code)))
+	for _, c := range code {
+		self.writeUint32(c)
+	}
+}
+
+func (self *dumper) writeConstants(constants []interface{}) {
+	self.writeUint32(uint32(len(constants)))
+	for _, k := range constants {
+		self.writeConstant(k)
+	}
+}
+
+/*
+	Write a constant with its tag.
+	Lua 5.1 has only one number type, so integers are written as numbers.
+*/
+func (self *dumper) writeConstant(k interface{}) {
+	switch x := k.(type) {
+	case nil:
+		self.writeByte(TAG_NIL)
+	case bool:
+		self.writeByte(TAG_BOOLEAN)
+		if x {
+			self.writeByte(1)
+		} else {
+			self.writeByte(0)
+		}
+	case int64:
+		self.writeByte(TAG_NUMBER)
+		self.writeLuaNumber(float64(x))
+	case float64:
+		self.writeByte(TAG_NUMBER)
+		self.writeLuaNumber(x)
+	case string:
+		self.writeByte(TAG_STRING)
+		self.writeString(x)
+	default:
+		panic("unsupported constant type!")
+	}
+}
+
+func (self *dumper) writeLocVars(locVars []LocVar) {
+	self.writeUint32(uint32(len(locVars)))
+	for _, locVar := range locVars {
+		self.writeString(locVar.VarName)
+		self.writeUint32(locVar.StartPC)
+		self.writeUint32(locVar.EndPC)
+	}
+}
@@ -0,0 +1,38 @@
+package common
+
+import "strings"
+
+/*
+	@description
+		The chunk name used in messages, like luaO_chunkid of lua:
+			"=stdin"		stdin
+			"@hello.lua"	hello.lua, or ...end of a long name
+			"x = 1"			[string "x = 1"]
+*/
+func ChunkID(source string) string {
+	if strings.HasPrefix(source, "=") {
+		if len(source) > LUA_IDSIZE {
+			return source[1:LUA_IDSIZE]
+		}
+		return source[1:]
+	}
+	if strings.HasPrefix(source, "@") {
+		source = source[1:]
+		if max := LUA_IDSIZE - len(" '...' ") - 1; len(source) > max {
+			return "..." + source[len(source)-max:]
+		}
+		return source
+	}
+	max := LUA_IDSIZE - len(" [string \"...\"] ") - 1
+	line := source
+	if i := strings.IndexAny(source, "\n\r"); i >= 0 {
+		line = source[:i]
+	}
+	if len(line) > max {
+		line = line[:max]
+	}
+	if len(line) < len(source) {
+		return "[string \"" + line + "...\"]"
+	}
+	return "[string \"" + line + "\"]"
+}
@@ -8,10 +8,13 @@ upvalue fake index
 */
 const LUA_MINSTACK = 20          // mini size of a new stack
 const LUAI_MAXSTACK = 1000000    // max size of a stack
+const LUAI_MAXCALLS = 20000      // max depth of nested calls of a thread
 const LUA_RIDX_GLOBALS int64 = 2 //the index of the global variable table in the registry table
 const LUA_REGISTRYINDEX = -LUAI_MAXSTACK - 1000
+const LUA_ENVIRONINDEX = LUA_REGISTRYINDEX + 1 // the environment table of the running function
 const LUA_RIDX_MAINTHREAD int64 = 1
 const LUA_MULTRET = -1
+const LUA_IDSIZE = 60 // the size of the chunk id in messages
 
 const (
 	LUA_MAXINTEGER = 1<<63 - 1
@@ -1,6 +1,7 @@
 package common
 
 import (
+	"fmt"
 	"math"
 	"regexp"
 	"strconv"
@@ -62,6 +63,22 @@ func ParseFloat(str string) (float64, bool) {
 	return f, err == nil
 }
 
+// Format the number as "%.14g" does in the reference lua, such as 1e+15, inf, -nan.
+func FormatNumber(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	case math.IsNaN(f):
+		if math.Signbit(f) {
+			return "-nan"
+		}
+		return "nan"
+	}
+	return fmt.Sprintf("%.14g", f)
+}
+
 // (0x)ABC.DEFp10
 func parseHexFloat(str string) (float64, bool) {
 	var i16, f16, p10 float64 = 0, 0, 0
@@ -17,14 +17,16 @@ const (
 	OpArgK        // argument is a constant or register/constant
 )
 
-/* OpCode */
+/* OpCode, in the order of Lua 5.1 */
 const (
 	OP_MOVE = iota
 	OP_LOADK
 	OP_LOADBOOL
 	OP_LOADNIL
 	OP_GETUPVAL
+	OP_GETGLOBAL
 	OP_GETTABLE
+	OP_SETGLOBAL
 	OP_SETUPVAL
 	OP_SETTABLE
 	OP_NEWTABLE
@@ -32,9 +34,9 @@ const (
 	OP_ADD
 	OP_SUB
 	OP_MUL
+	OP_DIV
 	OP_MOD
 	OP_POW
-	OP_DIV
 	OP_UNM
 	OP_NOT
 	OP_LEN
@@ -52,6 +54,7 @@ const (
 	OP_FORPREP
 	OP_TFORLOOP
 	OP_SETLIST
+	OP_CLOSE
 	OP_CLOSURE
 	OP_VARARG
 )
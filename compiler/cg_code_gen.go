@@ -16,7 +16,6 @@ func GenProto(chunk *Block) *FuncProto {
 	}
 
 	fi := newFuncInfo(nil, fd)
-	fi.addLocVar("_ENV", 0)
 	cgFuncDefExp(fi, fd, 0)
 	return toProto(fi.subFuncs[0])
 }
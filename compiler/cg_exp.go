@@ -188,28 +188,19 @@ func cgNameExp(fi *funcInfo, node *NameExp, a int) {
 		fi.emitMove(node.Line, a, r)
 	} else if idx := fi.indexOfUpval(node.Name); idx >= 0 {
 		fi.emitGetUpval(node.Line, a, idx)
-	} else { // x => _ENV['x']
-		taExp := &TableAccessExp{
-			LastLine:  node.Line,
-			PrefixExp: &NameExp{node.Line, "_ENV"},
-			KeyExp:    &StringExp{node.Line, node.Name},
-		}
-		cgTableAccessExp(fi, taExp, a)
+	} else { // global var
+		fi.emitGetGlobal(node.Line, a, fi.indexOfConstant(node.Name))
 	}
 }
 
 // r[a] := prefix[key]
 func cgTableAccessExp(fi *funcInfo, node *TableAccessExp, a int) {
 	oldRegs := fi.usedRegs
-	b, kindB := expToOpArg(fi, node.PrefixExp, ARG_RU)
+	b, _ := expToOpArg(fi, node.PrefixExp, ARG_REG)
 	c, _ := expToOpArg(fi, node.KeyExp, ARG_RK)
 	fi.usedRegs = oldRegs
 
-	if kindB == ARG_UPVAL {
-		//fi.emitGetTabUp(node.LastLine, a, b, c)
-	} else {
-		fi.emitGetTable(node.LastLine, a, b, c)
-	}
+	fi.emitGetTable(node.LastLine, a, b, c)
 }
 
 // r[a] := f(args)
@@ -16,6 +16,10 @@ func toProto(fi *funcInfo) *FuncProto {
 		Instructions: fi.insts,
 		Constants:    getConstants(fi),
 		Protos:       toProtos(fi.subFuncs),
+		LineInfo:     fi.lineNums,
+		LocVars:      getLocVars(fi),
+		UpvalueNames: getUpvalueNames(fi),
+		Upvalues:     getUpvalues(fi),
 	}
 
 	if fi.line == 0 {
@@ -25,7 +29,7 @@ func toProto(fi *funcInfo) *FuncProto {
 		proto.MaxStackSize = 2 // todo
 	}
 	if fi.isVararg {
-		proto.IsVararg = 1 // todo
+		proto.IsVararg = VARARG_ISVARARG
 	}
 
 	return proto
@@ -66,3 +70,15 @@ func getUpvalueNames(fi *funcInfo) []string {
 	}
 	return names
 }
+
+func getUpvalues(fi *funcInfo) []Upvalue {
+	upvals := make([]Upvalue, len(fi.upvalues))
+	for _, uv := range fi.upvalues {
+		if uv.locVarSlot >= 0 { // local variable of the enclosing function
+			upvals[uv.index] = Upvalue{Instack: 1, Idx: byte(uv.locVarSlot)}
+		} else {
+			upvals[uv.index] = Upvalue{Instack: 0, Idx: byte(uv.upvalIndex)}
+		}
+	}
+	return upvals
+}
@@ -189,11 +189,8 @@ func (self *funcInfo) enterScope(breakable bool) {
 func (self *funcInfo) exitScope(endPC int) {
 	pendingBreakJmps := self.breaks[len(self.breaks)-1]
 	self.breaks = self.breaks[:len(self.breaks)-1]
-	a := self.getJmpArgA()
 	for _, pc := range pendingBreakJmps {
-		sBx := self.pc() - pc
-		i := (sBx+MAXARG_sBx)<<14 | a<<6 | OP_JMP
-		self.insts[pc] = uint32(i)
+		self.fixSbx(pc, self.pc()-pc)
 	}
 	self.scopeLv--
 	for _, locVar := range self.locNames {
@@ -269,10 +266,15 @@ func (self *funcInfo) slotOfLocVar(name string) int {
 		pc	int		""
 */
 func (self *funcInfo) addBreakJmp(pc int) {
+	lv := self.loopScopeLv()
+	self.breaks[lv] = append(self.breaks[lv], pc)
+}
+
+// Return the scope level of the nearest loop block, which 'break' jumps out of.
+func (self *funcInfo) loopScopeLv() int {
 	for i := self.scopeLv; i >= 0; i-- {
 		if self.breaks[i] != nil { // breakable
-			self.breaks[i] = append(self.breaks[i], pc)
-			return
+			return i
 		}
 	}
 
@@ -308,34 +310,39 @@ func (self *funcInfo) indexOfUpval(name string) int {
 	return -1
 }
 
-// Get 'a' from jmp command, and then generate byte code fo JMP.
-// 'a' is the register index of the first local variable need to be handle.
+// Generate CLOSE if any local variable of the current scope is captured by a closure,
+// so that the closures keep the values after the registers are reused.
 func (self *funcInfo) closeOpenUpvals(line int) {
-	a := self.getJmpArgA()
-	if a > 0 {
-		self.emitJmp(line, a, 0)
+	if a := self.closeLevel(self.scopeLv); a >= 0 {
+		self.emitClose(line, a)
 	}
 }
 
-func (self *funcInfo) getJmpArgA() int {
+/*
+	@desciption
+		Find the first register to close when leaving the scopes from level scopeLv.
+	@param
+		scopeLv	int		"the outermost scope to leave"
+	@return
+		a		int		"the lowest register of the local variables, -1 if none of them is captured"
+*/
+func (self *funcInfo) closeLevel(scopeLv int) int {
 	hasCapturedLocVars := false
 	minSlotOfLocVars := self.maxRegs
 	for _, locVar := range self.locNames {
-		if locVar.scopeLv == self.scopeLv {
-			for v := locVar; v != nil && v.scopeLv == self.scopeLv; v = v.prev {
-				if v.captured {
-					hasCapturedLocVars = true
-				}
-				if v.slot < minSlotOfLocVars && v.name[0] != '(' {
-					minSlotOfLocVars = v.slot
-				}
+		for v := locVar; v != nil && v.scopeLv >= scopeLv; v = v.prev {
+			if v.captured {
+				hasCapturedLocVars = true
+			}
+			if v.slot < minSlotOfLocVars && v.name[0] != '(' {
+				minSlotOfLocVars = v.slot
 			}
 		}
 	}
 	if hasCapturedLocVars {
-		return minSlotOfLocVars + 1
+		return minSlotOfLocVars
 	} else {
-		return 0
+		return -1
 	}
 }
 
@@ -445,11 +452,11 @@ func (self *funcInfo) emitMove(line, a, b int) {
 
 /*
 	@description
-		Assign nil to register a, a+1...a+b
-		r[a], r[a+1], ..., r[a+b] = nil
+		Assign nil to n registers from register a.
+		r[a] := ... := r[b] := nil, b = a+n-1
 */
 func (self *funcInfo) emitLoadNil(line, a, n int) {
-	self.emitABC(line, OP_LOADNIL, a, n-1, 0)
+	self.emitABC(line, OP_LOADNIL, a, a+n-1, 0)
 }
 
 /*
@@ -472,9 +479,17 @@ func (self *funcInfo) emitVararg(line, a, n int) {
 	self.emitABC(line, OP_VARARG, a, n+1, 0)
 }
 
-// r[a] = emitClosure(proto[bx])
+// r[a] = emitClosure(proto[bx]), followed by one pseudo instruction for each upvalue:
+// MOVE 0 b for the local variable r[b], GETUPVAL 0 b for the upvalue b.
 func (self *funcInfo) emitClosure(line, a, bx int) {
 	self.emitABx(line, OP_CLOSURE, a, bx)
+	for _, uv := range getUpvalues(self.subFuncs[bx]) {
+		if uv.Instack == 1 {
+			self.emitMove(line, 0, int(uv.Idx))
+		} else {
+			self.emitGetUpval(line, 0, int(uv.Idx))
+		}
+	}
 }
 
 // r[a] = {}
@@ -508,6 +523,16 @@ func (self *funcInfo) emitSetUpval(line, a, b int) {
 	self.emitABC(line, OP_SETUPVAL, a, b, 0)
 }
 
+// r[a] = env[kst[bx]]
+func (self *funcInfo) emitGetGlobal(line, a, bx int) {
+	self.emitABx(line, OP_GETGLOBAL, a, bx)
+}
+
+// env[kst[bx]] = r[a]
+func (self *funcInfo) emitSetGlobal(line, a, bx int) {
+	self.emitABx(line, OP_SETGLOBAL, a, bx)
+}
+
 // r[a], ..., r[a+c-2] = r[a](r[a+1], ..., r[a+b-1])
 func (self *funcInfo) emitCall(line, a, nArgs, nRet int) {
 	self.emitABC(line, OP_CALL, a, nArgs+1, nRet+1)
@@ -528,12 +553,17 @@ func (self *funcInfo) emitSelf(line, a, b, c int) {
 	self.emitABC(line, OP_SELF, a, b, c)
 }
 
-// pc+=sBx; if (a) close all upvalues >= r[a - 1]
+// pc+=sBx
 func (self *funcInfo) emitJmp(line, a, sBx int) int {
 	self.emitAsBx(line, OP_JMP, a, sBx)
 	return len(self.insts) - 1
 }
 
+// close all upvalues >= r[a]
+func (self *funcInfo) emitClose(line, a int) {
+	self.emitABC(line, OP_CLOSE, a, 0, 0)
+}
+
 // if not (r[a] <=> c) then pc++
 func (self *funcInfo) emitTest(line, a, c int) {
 	self.emitABC(line, OP_TEST, a, 0, c)
@@ -554,8 +584,9 @@ func (self *funcInfo) emitForLoop(line, a, sBx int) int {
 	return len(self.insts) - 1
 }
 
-func (self *funcInfo) emitTForLoop(line, a, sBx int) {
-	self.emitAsBx(line, OP_TFORLOOP, a, sBx)
+// r[a+3], ..., r[a+2+c] := r[a](r[a+1], r[a+2]); if r[a+3] ~= nil then r[a+2] := r[a+3] else pc++
+func (self *funcInfo) emitTForLoop(line, a, c int) {
+	self.emitABC(line, OP_TFORLOOP, a, 0, c)
 }
 
 // r[a] = op r[b]
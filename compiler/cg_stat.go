@@ -32,9 +32,11 @@ func cgStat(fi *funcInfo, node Stat) {
 }
 
 // local f; f = function() end
+// The variable is active after CLOSURE and its pseudo instructions.
 func cgLocalFuncDefStat(fi *funcInfo, node *LocalFuncDefStat) {
-	r := fi.addLocVar(node.Name, fi.pc()+2)
+	r := fi.addLocVar(node.Name, 0)
 	cgFuncDefExp(fi, node.Exp, r)
+	fi.locNames[node.Name].startPC = fi.pc() + 1
 }
 
 // functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
@@ -45,7 +47,11 @@ func cgFuncCallStat(fi *funcInfo, node *FuncCallStat) {
 }
 
 // generate a jmp command, and put the pc in break table.
+// The captured local variables in the loop are closed before the jump.
 func cgBreakStat(fi *funcInfo, node *BreakStat) {
+	if a := fi.closeLevel(fi.loopScopeLv()); a >= 0 {
+		fi.emitClose(node.Line, a)
+	}
 	pc := fi.emitJmp(node.Line, 0, 0)
 	fi.addBreakJmp(pc)
 }
@@ -107,9 +113,16 @@ func cgRepeatStat(fi *funcInfo, node *RepeatStat) {
 	fi.usedRegs = oldRegs
 
 	line := lastLineOf(node.Exp)
-	fi.emitTest(line, a, 0)
-	fi.emitJmp(line, fi.getJmpArgA(), pcBeforeBlock-fi.pc()-1)
-	fi.closeOpenUpvals(line)
+	if lv := fi.closeLevel(fi.scopeLv); lv < 0 {
+		fi.emitTest(line, a, 0)
+		fi.emitJmp(line, 0, pcBeforeBlock-fi.pc()-1)
+	} else { // close the upvalues both before looping and after exiting
+		fi.emitTest(line, a, 1)
+		fi.emitJmp(line, 0, 2)
+		fi.emitClose(line, lv)
+		fi.emitJmp(line, 0, pcBeforeBlock-fi.pc()-1)
+		fi.emitClose(line, lv)
+	}
 
 	fi.exitScope(fi.pc() + 1)
 }
@@ -201,15 +214,15 @@ func cgForInStat(fi *funcInfo, node *ForInStat) {
 		fi.addLocVar(name, fi.pc()+2)
 	}
 
-	pcJmpToTFC := fi.emitJmp(node.LineOfDo, 0, 0)
+	pcJmpToTFL := fi.emitJmp(node.LineOfDo, 0, 0)
 	cgBlock(fi, node.Block)
 	fi.closeOpenUpvals(node.Block.LastLine)
-	fi.fixSbx(pcJmpToTFC, fi.pc()-pcJmpToTFC)
+	fi.fixSbx(pcJmpToTFL, fi.pc()-pcJmpToTFL)
 
 	line := lineOf(node.ExpList[0])
 	rGenerator := fi.slotOfLocVar(forGeneratorVar)
-	//fi.emitTForCall(line, rGenerator, len(node.NameList))
-	fi.emitTForLoop(line, rGenerator+2, pcJmpToTFC-fi.pc()-1)
+	fi.emitTForLoop(line, rGenerator, len(node.NameList))
+	fi.emitJmp(line, 0, pcJmpToTFL-fi.pc()-1) // back to the loop body
 
 	fi.exitScope(fi.pc() - 1)
 	fi.fixEndPC(forGeneratorVar, 2)
@@ -280,15 +293,6 @@ func cgAssignStat(fi *funcInfo, node *AssignStat) {
 			cgExp(fi, taExp.PrefixExp, tRegs[i], 1)
 			kRegs[i] = fi.allocReg()
 			cgExp(fi, taExp.KeyExp, kRegs[i], 1)
-		} else {
-			name := exp.(*NameExp).Name
-			if fi.slotOfLocVar(name) < 0 && fi.indexOfUpval(name) < 0 {
-				// global var
-				kRegs[i] = -1
-				if fi.indexOfConstant(name) > 0xFF {
-					kRegs[i] = fi.allocReg()
-				}
-			}
 		}
 	}
 	for i := 0; i < nVars; i++ {
@@ -332,21 +336,8 @@ func cgAssignStat(fi *funcInfo, node *AssignStat) {
 				fi.emitMove(lastLine, a, vRegs[i])
 			} else if b := fi.indexOfUpval(varName); b >= 0 {
 				fi.emitSetUpval(lastLine, vRegs[i], b)
-			} else if a := fi.slotOfLocVar("_ENV"); a >= 0 {
-				if kRegs[i] < 0 {
-					b := 0x100 + fi.indexOfConstant(varName)
-					fi.emitSetTable(lastLine, a, b, vRegs[i])
-				} else {
-					fi.emitSetTable(lastLine, a, kRegs[i], vRegs[i])
-				}
 			} else { // global var
-				//a := fi.indexOfUpval("_ENV")
-				// if kRegs[i] < 0 {
-				// 	b := 0x100 + fi.indexOfConstant(varName)
-				// 	fi.emitSetTabUp(lastLine, a, b, vRegs[i])
-				// } else {
-				// 	fi.emitSetTabUp(lastLine, a, kRegs[i], vRegs[i])
-				// }
+				fi.emitSetGlobal(lastLine, vRegs[i], fi.indexOfConstant(varName))
 			}
 		} else {
 			fi.emitSetTable(lastLine, tRegs[i], kRegs[i], vRegs[i])
@@ -6,6 +6,7 @@ package compiler
 import (
 	"bytes"
 	"fmt"
+	. "goluar/common"
 	"regexp"
 	"strconv"
 	"strings"
@@ -128,7 +129,7 @@ func (self *Lexer) NextToken() (line, kind int, token string) {
 
 	self.skipWhiteSpaces()
 	if len(self.codes) == 0 {
-		return self.line, LEX_EOF, "EOF"
+		return self.line, LEX_EOF, "<eof>"
 	}
 
 	switch self.codes[0] {
@@ -259,7 +260,7 @@ func (self *Lexer) test(s string) bool {
 //Print out error information.
 func (self *Lexer) error(f string, a ...interface{}) {
 	err := fmt.Sprintf(f, a...)
-	err = fmt.Sprintf("%s:%d: %s", self.srcFileName, self.line, err)
+	err = fmt.Sprintf("%s:%d: %s", ChunkID(self.srcFileName), self.line, err)
 	panic(err)
 }
 
@@ -340,7 +341,7 @@ func (self *Lexer) scanLongString() string {
 	closingLongBracket := strings.Replace(openingLongBracket, "[", "]", -1)
 	closingLongBracketIdx := strings.Index(self.codes, closingLongBracket)
 	if closingLongBracketIdx < 0 {
-		self.error("unfinished long string or comment")
+		self.error("unfinished long string or comment near '<eof>'")
 	}
 
 	str := self.codes[len(openingLongBracket):closingLongBracketIdx]
@@ -371,6 +372,9 @@ func (self *Lexer) scanShortString() string {
 		}
 		return str
 	}
+	if !strings.ContainsAny(self.codes, "\r\n") { // the string runs to the end of the chunk
+		self.error("unfinished string near '<eof>'")
+	}
 	self.error("unfinished string")
 	return ""
 }
@@ -29,11 +29,12 @@ func parseExpList(lexer *Lexer) []Exp {
 		Describe syntax in EBNF:
 		exp ::= nil | false | true | Numeral | LiteralString | ‘...’ | functiondef |
 				prefixexp | tableconstructor | exp binop exp | unop exp
-		exp ::= ExpCompare {(or|and) ExpCompare}
-		ExpCompare ::= expBitOr {(‘<’ | ‘>’ | ‘<=’ | ‘>=’ | ‘~=’ | ‘==’) expBitOr}
-		expBitOp ::= expConcat {('|' |  '~' | '&' | 'shift') expConcat}
+		exp ::= expAnd {or expAnd}
+		expAnd ::= ExpCompare {and ExpCompare}
+		ExpCompare ::= expConcat {(‘<’ | ‘>’ | ‘<=’ | ‘>=’ | ‘~=’ | ‘==’) expConcat}
 		expConcat ::= expOpMath {‘..’ expOpMath}
-		expOpMath ::= expUniOp {(‘+’ | ‘-’ | ‘*’ | ‘/’ | ‘//’ | ‘%’) expUniOp}
+		expOpMath ::= expMul {(‘+’ | ‘-’) expMul}
+		expMul ::= expUniOp {(‘*’ | ‘/’ | ‘%’) expUniOp}
 		expUniOp ::= {(‘not’ | ‘#’ | ‘-’ | ‘~’)} expPow
 		expPow ::= expOther {‘^’ expUniOp}
 		expOther ::= nil | false | true | Numeral | LiteralString | ‘...’ | functiondef |
@@ -45,15 +46,22 @@ func parseExpList(lexer *Lexer) []Exp {
 
 */
 func parseExp(lexer *Lexer) Exp {
+	exp := parseExpAnd(lexer)
+	for lexer.LookAhead() == LEX_OP_OR {
+		line, op, _ := lexer.NextToken()
+		lor := &BinopExp{line, op, exp, parseExpAnd(lexer)}
+		exp = optimizeLogicalAndOr(lor)
+	}
+	return exp
+}
+
+// 'and' binds tighter than 'or'
+func parseExpAnd(lexer *Lexer) Exp {
 	exp := parseExpCompare(lexer)
-	for {
-		if lexer.LookAhead() == LEX_OP_AND || lexer.LookAhead() == LEX_OP_OR {
-			line, op, _ := lexer.NextToken()
-			landor := &BinopExp{line, op, exp, parseExpCompare(lexer)}
-			exp = optimizeLogicalAndOr(landor)
-		} else {
-			return exp
-		}
+	for lexer.LookAhead() == LEX_OP_AND {
+		line, op, _ := lexer.NextToken()
+		land := &BinopExp{line, op, exp, parseExpCompare(lexer)}
+		exp = optimizeLogicalAndOr(land)
 	}
 	return exp
 }
@@ -123,7 +131,6 @@ func parseExpCompare(lexer *Lexer) Exp {
 			return exp
 		}
 	}
-	return exp
 }
 
 // '..'
@@ -142,13 +149,27 @@ func parseExpConcat(lexer *Lexer) Exp {
 	return &ConcatExp{line, exps}
 }
 
-// '+' | '-' | '*' | '%' | '/'
+// '+' | '-'
 func parseExpOpMath(lexer *Lexer) Exp {
+	exp := parseExpMul(lexer)
+	for {
+		switch lexer.LookAhead() {
+		case LEX_OP_ADD, LEX_OP_SUB:
+			line, op, _ := lexer.NextToken()
+			arith := &BinopExp{line, op, exp, parseExpMul(lexer)}
+			exp = optimizeArithBinaryOp(arith)
+		default:
+			return exp
+		}
+	}
+}
+
+// '*' | '%' | '/'
+func parseExpMul(lexer *Lexer) Exp {
 	exp := parseExpUniOp(lexer)
 	for {
 		switch lexer.LookAhead() {
-		case LEX_OP_MUL, LEX_OP_MOD, LEX_OP_DIV,
-			LEX_OP_ADD, LEX_OP_SUB:
+		case LEX_OP_MUL, LEX_OP_MOD, LEX_OP_DIV:
 			line, op, _ := lexer.NextToken()
 			arith := &BinopExp{line, op, exp, parseExpUniOp(lexer)}
 			exp = optimizeArithBinaryOp(arith)
@@ -156,7 +177,6 @@ func parseExpOpMath(lexer *Lexer) Exp {
 			return exp
 		}
 	}
-	return exp
 }
 
 // '-' | '#' | 'not'
@@ -343,7 +363,6 @@ func finishPrefixExp(lexer *Lexer, exp Exp) Exp {
 			return exp
 		}
 	}
-	return exp
 }
 
 // functioncall ::=  prefixexp args | prefixexp ‘:’ Name args
@@ -19,9 +19,9 @@ import (
 func optimizeLogicalAndOr(exp *BinopExp) Exp {
 	switch exp.Op {
 	case LEX_OP_AND:
-		return optimizeLogicalOr(exp)
-	case LEX_OP_OR:
 		return optimizeLogicalAnd(exp)
+	case LEX_OP_OR:
+		return optimizeLogicalOr(exp)
 	default:
 		return exp
 	}
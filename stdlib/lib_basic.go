@@ -0,0 +1,554 @@
+package stdlib
+
+import (
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+const (
+	GC_PAUSE   = "_GC_pause"   // the registry key of the pause of collectgarbage("setpause")
+	GC_STEPMUL = "_GC_stepmul" // the registry key of the step multiplier of collectgarbage("setstepmul")
+)
+
+var baseFuncs = map[string]GoFunction{
+	"assert":         baseAssert,
+	"collectgarbage": baseCollectGarbage,
+	"dofile":         baseDoFile,
+	"error":          baseError,
+	"gcinfo":         baseGCInfo,
+	"getfenv":        baseGetFenv,
+	"getmetatable":   baseGetMetatable,
+	"ipairs":         baseIPairs,
+	"loadfile":       baseLoadFile,
+	"load":           baseLoad,
+	"loadstring":     baseLoadString,
+	"next":           baseNext,
+	"pairs":          basePairs,
+	"pcall":          basePCall,
+	"print":          basePrint,
+	"rawequal":       baseRawEqual,
+	"rawget":         baseRawGet,
+	"rawset":         baseRawSet,
+	"select":         baseSelect,
+	"setfenv":        baseSetFenv,
+	"setmetatable":   baseSetMetatable,
+	"tonumber":       baseToNumber,
+	"tostring":       baseToString,
+	"type":           baseType,
+	"unpack":         baseUnpack,
+	"xpcall":         baseXPCall,
+}
+
+// lua-5.1.5/src/lbaselib.c#luaopen_base()
+func OpenBaseLib(ls LuaState) int {
+	/* open lib into global table */
+	ls.PushGlobalTable()
+	ls.SetFuncs(baseFuncs, 0)
+	/* set global _G */
+	ls.PushValue(-1)
+	ls.SetField(-2, "_G")
+	/* set global _VERSION */
+	ls.PushString("Lua 5.1")
+	ls.SetField(-2, "_VERSION")
+	return 1
+}
+
+/*
+	@description
+		Push the function given by the first argument: a function, or a level of the call stack.
+		Level 1 is the function calling getfenv or setfenv.
+	@param
+		opt		bool	"whether the level is optional, the default level is 1"
+*/
+func getFunc(ls LuaState, opt bool) {
+	if ls.Type(1) == LUA_TFUNCTION {
+		ls.PushValue(1)
+		return
+	}
+	var level int64
+	if opt {
+		level = ls.OptInteger(1, 1)
+	} else {
+		level = ls.CheckInteger(1)
+	}
+	ls.ArgCheck(level >= 0, 1, "level must be non-negative")
+	if !ls.GetStackFunction(int(level)) {
+		ls.ArgError(1, "invalid level")
+	}
+}
+
+// getfenv ([f])
+// http://www.lua.org/manual/5.1/manual.html#pdf-getfenv
+// lua-5.1.5/src/lbaselib.c#luaB_getfenv()
+func baseGetFenv(ls LuaState) int {
+	getFunc(ls, true)
+	if ls.IsGoFunction(-1) { /* is a Go function? */
+		ls.PushGlobalTable() /* return the thread's global env. */
+	} else {
+		ls.GetFenv(-1)
+	}
+	return 1
+}
+
+// setfenv (f, table)
+// http://www.lua.org/manual/5.1/manual.html#pdf-setfenv
+// lua-5.1.5/src/lbaselib.c#luaB_setfenv()
+func baseSetFenv(ls LuaState) int {
+	ls.CheckType(2, LUA_TTABLE)
+	getFunc(ls, false)
+	ls.PushValue(2)
+	if ls.IsNumber(1) && ls.ToNumber(1) == 0 {
+		/* change environment of current thread */
+		ls.PushThread()
+		ls.Insert(-2)
+		ls.SetFenv(-2)
+		return 0
+	} else if ls.IsGoFunction(-2) || !ls.SetFenv(-2) {
+		ls.Error2("'setfenv' cannot change environment of given object")
+	}
+	return 1
+}
+
+// xpcall (f, err)
+// http://www.lua.org/manual/5.1/manual.html#pdf-xpcall
+// lua-5.1.5/src/lbaselib.c#luaB_xpcall()
+func baseXPCall(ls LuaState) int {
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.PushBoolean(true) /* first result */
+	ls.PushValue(1)      /* function */
+	status := ls.PCallK(0, LUA_MULTRET, 2, 2, finishPCall)
+	return finishPCall(ls, status, 2)
+}
+
+/*
+	@description
+		Continuation of pcall and xpcall, the status result is under the results of the call
+		or the error message. It finishes the function when the call is done, or when the
+		coroutine goes on after a yield in the call.
+		lua-5.3.4/src/lbaselib.c#finishpcall()
+	@param
+		extra	int	"the number of values under the status result"
+*/
+func finishPCall(ls LuaState, status, extra int) int {
+	if status != LUA_OK && status != LUA_YIELD { /* error? */
+		ls.PushBoolean(false) /* first result (false) */
+		ls.PushValue(-2)      /* error message */
+		return 2              /* return false, msg */
+	}
+	return ls.GetTop() - extra /* return all results */
+}
+
+// print (···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-print
+// lua-5.1.5/src/lbaselib.c#luaB_print()
+func basePrint(ls LuaState) int {
+	n := ls.GetTop() /* number of arguments */
+	ls.GetGlobal("tostring")
+	var buf strings.Builder
+	for i := 1; i <= n; i++ {
+		ls.PushValue(-1) /* function to be called */
+		ls.PushValue(i)  /* value to print */
+		ls.Call(1, 1)
+		s, ok := ls.ToStringX(-1) /* get result */
+		if !ok || !ls.IsString(-1) {
+			return ls.Error2("'tostring' must return a string to 'print'")
+		}
+		if i > 1 {
+			buf.WriteByte('\t')
+		}
+		buf.WriteString(s)
+		ls.Pop(1) /* pop result */
+	}
+	buf.WriteByte('\n')
+	if w := Stdout(ls); w != nil {
+		io.WriteString(w, buf.String())
+	}
+	return 0
+}
+
+// tonumber (e [, base])
+// http://www.lua.org/manual/5.1/manual.html#pdf-tonumber
+// lua-5.1.5/src/lbaselib.c#luaB_tonumber()
+func baseToNumber(ls LuaState) int {
+	base := ls.OptInteger(2, 10)
+	if base == 10 { /* standard conversion */
+		ls.CheckAny(1)
+		if ls.Type(1) == LUA_TNUMBER {
+			ls.SetTop(1)
+			return 1
+		} else if s, ok := ls.ToStringX(1); ok && ls.StringToNumber(strings.TrimSpace(s)) {
+			return 1
+		}
+	} else {
+		s := strings.TrimSpace(ls.CheckString(1))
+		ls.ArgCheck(2 <= base && base <= 36, 2, "base out of range")
+		neg := false
+		if strings.HasPrefix(s, "-") {
+			s, neg = s[1:], true
+		} else if strings.HasPrefix(s, "+") {
+			s = s[1:]
+		}
+		if n, err := strconv.ParseUint(s, int(base), 64); err == nil && s != "" {
+			if neg {
+				ls.PushNumber(-float64(n))
+			} else if n <= 1<<63-1 {
+				ls.PushInteger(int64(n))
+			} else {
+				ls.PushNumber(float64(n))
+			}
+			return 1
+		}
+	}
+	ls.PushNil() /* else not a number */
+	return 1
+}
+
+// tostring (e)
+// http://www.lua.org/manual/5.1/manual.html#pdf-tostring
+// lua-5.1.5/src/lbaselib.c#luaB_tostring()
+func baseToString(ls LuaState) int {
+	ls.CheckAny(1)
+	ls.ToString2(1)
+	return 1
+}
+
+// type (v)
+// http://www.lua.org/manual/5.1/manual.html#pdf-type
+// lua-5.1.5/src/lbaselib.c#luaB_type()
+func baseType(ls LuaState) int {
+	ls.CheckAny(1)
+	ls.PushString(ls.TypeName2(1))
+	return 1
+}
+
+// error (message [, level])
+// http://www.lua.org/manual/5.1/manual.html#pdf-error
+// lua-5.1.5/src/lbaselib.c#luaB_error()
+func baseError(ls LuaState) int {
+	level := int(ls.OptInteger(2, 1))
+	ls.SetTop(1)
+	if ls.IsString(1) && level > 0 { /* add extra information? */
+		ls.Where(level)
+		ls.PushValue(1)
+		ls.Concat(2)
+	}
+	return ls.Error()
+}
+
+// assert (v [, message])
+// http://www.lua.org/manual/5.1/manual.html#pdf-assert
+// lua-5.1.5/src/lbaselib.c#luaB_assert()
+func baseAssert(ls LuaState) int {
+	ls.CheckAny(1)
+	if !ls.ToBoolean(1) {
+		return ls.Error2("%s", ls.OptString(2, "assertion failed!"))
+	}
+	return ls.GetTop()
+}
+
+// pcall (f, arg1, ···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-pcall
+// lua-5.1.5/src/lbaselib.c#luaB_pcall()
+func basePCall(ls LuaState) int {
+	ls.CheckAny(1)
+	ls.PushBoolean(true) /* first result if no errors */
+	ls.Insert(1)         /* put it in place */
+	status := ls.PCallK(ls.GetTop()-2, LUA_MULTRET, 0, 0, finishPCall)
+	return finishPCall(ls, status, 0)
+}
+
+// getmetatable (object)
+// http://www.lua.org/manual/5.1/manual.html#pdf-getmetatable
+// lua-5.1.5/src/lbaselib.c#luaB_getmetatable()
+func baseGetMetatable(ls LuaState) int {
+	ls.CheckAny(1)
+	if !ls.GetMetatable(1) {
+		ls.PushNil()
+		return 1 /* no metatable */
+	}
+	ls.GetMetafield(1, "__metatable")
+	return 1 /* returns either __metatable field (if present) or metatable */
+}
+
+// setmetatable (table, metatable)
+// http://www.lua.org/manual/5.1/manual.html#pdf-setmetatable
+// lua-5.1.5/src/lbaselib.c#luaB_setmetatable()
+func baseSetMetatable(ls LuaState) int {
+	t := ls.Type(2)
+	ls.CheckType(1, LUA_TTABLE)
+	ls.ArgCheck(t == LUA_TNIL || t == LUA_TTABLE, 2, "nil or table expected")
+	if ls.GetMetafield(1, "__metatable") != LUA_TNIL {
+		return ls.Error2("cannot change a protected metatable")
+	}
+	ls.SetTop(2)
+	ls.SetMetatable(1)
+	return 1
+}
+
+// rawequal (v1, v2)
+// http://www.lua.org/manual/5.1/manual.html#pdf-rawequal
+// lua-5.1.5/src/lbaselib.c#luaB_rawequal()
+func baseRawEqual(ls LuaState) int {
+	ls.CheckAny(1)
+	ls.CheckAny(2)
+	ls.PushBoolean(ls.RawEqual(1, 2))
+	return 1
+}
+
+// rawget (table, index)
+// http://www.lua.org/manual/5.1/manual.html#pdf-rawget
+// lua-5.1.5/src/lbaselib.c#luaB_rawget()
+func baseRawGet(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.CheckAny(2)
+	ls.SetTop(2)
+	ls.RawGet(1)
+	return 1
+}
+
+// rawset (table, index, value)
+// http://www.lua.org/manual/5.1/manual.html#pdf-rawset
+// lua-5.1.5/src/lbaselib.c#luaB_rawset()
+func baseRawSet(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.CheckAny(2)
+	ls.CheckAny(3)
+	ls.SetTop(3)
+	ls.RawSet(1)
+	return 1
+}
+
+// next (table [, index])
+// http://www.lua.org/manual/5.1/manual.html#pdf-next
+// lua-5.1.5/src/lbaselib.c#luaB_next()
+func baseNext(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.SetTop(2) /* create a 2nd argument if there isn't one */
+	if ls.Next(1) {
+		return 2
+	}
+	ls.PushNil()
+	return 1
+}
+
+// pairs (t)
+// http://www.lua.org/manual/5.1/manual.html#pdf-pairs
+// lua-5.1.5/src/lbaselib.c#luaB_pairs()
+func basePairs(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.PushGoFunction(baseNext) /* return generator, */
+	ls.PushValue(1)             /* state, */
+	ls.PushNil()                /* and initial value */
+	return 3
+}
+
+// ipairs (t)
+// http://www.lua.org/manual/5.1/manual.html#pdf-ipairs
+// lua-5.1.5/src/lbaselib.c#luaB_ipairs()
+func baseIPairs(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.PushGoFunction(ipairsAux) /* return generator, */
+	ls.PushValue(1)              /* state, */
+	ls.PushInteger(0)            /* and initial value */
+	return 3
+}
+
+func ipairsAux(ls LuaState) int {
+	i := ls.CheckInteger(2) + 1
+	ls.CheckType(1, LUA_TTABLE)
+	ls.PushInteger(i) /* next value */
+	if ls.RawGetI(1, i) == LUA_TNIL {
+		return 0
+	}
+	return 2
+}
+
+// select (index, ···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-select
+// lua-5.1.5/src/lbaselib.c#luaB_select()
+func baseSelect(ls LuaState) int {
+	n := int64(ls.GetTop())
+	if ls.Type(1) == LUA_TSTRING && ls.ToString(1) == "#" {
+		ls.PushInteger(n - 1)
+		return 1
+	}
+	i := ls.CheckInteger(1)
+	if i < 0 {
+		i = n + i
+	} else if i > n {
+		i = n
+	}
+	ls.ArgCheck(1 <= i, 1, "index out of range")
+	return int(n - i)
+}
+
+// unpack (list [, i [, j]])
+// http://www.lua.org/manual/5.1/manual.html#pdf-unpack
+// lua-5.1.5/src/lbaselib.c#luaB_unpack()
+func baseUnpack(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	i := ls.OptInteger(2, 1)
+	var e int64
+	if ls.IsNoneOrNil(3) {
+		e = int64(ls.RawLen(1))
+	} else {
+		e = ls.CheckInteger(3)
+	}
+	if i > e {
+		return 0 /* empty range */
+	}
+	n := e - i + 1 /* number of elements */
+	if n <= 0 || n >= 1<<31 || !ls.CheckStack(int(n)) {
+		return ls.Error2("too many results to unpack")
+	}
+	for ; i <= e; i++ { /* push arg[i...e] */
+		ls.RawGetI(1, i)
+	}
+	return int(n)
+}
+
+// Return the loaded chunk, or nil and the error message.
+func loadAux(ls LuaState, status int) int {
+	if status == LUA_OK { /* OK? */
+		return 1
+	}
+	ls.PushNil()
+	ls.Insert(-2) /* put before error message */
+	return 2      /* return nil plus error message */
+}
+
+// loadstring (string [, chunkname])
+// http://www.lua.org/manual/5.1/manual.html#pdf-loadstring
+// lua-5.1.5/src/lbaselib.c#luaB_loadstring()
+func baseLoadString(ls LuaState) int {
+	s := ls.CheckString(1)
+	chunkName := ls.OptString(2, s)
+	return loadAux(ls, ls.Load([]byte(s), chunkName, "bt"))
+}
+
+// loadfile ([filename])
+// http://www.lua.org/manual/5.1/manual.html#pdf-loadfile
+// lua-5.1.5/src/lbaselib.c#luaB_loadfile()
+func baseLoadFile(ls LuaState) int {
+	fname := ls.OptString(1, "")
+	return loadAux(ls, ls.LoadFile(fname))
+}
+
+// load (func [, chunkname])
+// http://www.lua.org/manual/5.1/manual.html#pdf-load
+// lua-5.1.5/src/lbaselib.c#luaB_load()
+/*
+	The function is called until it returns nil or an empty string, the pieces are
+	concatenated to the chunk. An error of the function is returned as the load error.
+*/
+func baseLoad(ls LuaState) int {
+	ls.CheckType(1, LUA_TFUNCTION)
+	chunkName := ls.OptString(2, "=(load)")
+	ls.SetTop(1)
+	var chunk []byte
+	for {
+		ls.PushValue(1)
+		if ls.PCall(0, 1, 0) != LUA_OK {
+			return loadAux(ls, LUA_ERRSYNTAX)
+		}
+		if ls.IsNil(-1) {
+			ls.Pop(1)
+			break
+		} else if !ls.IsString(-1) {
+			ls.Pop(1)
+			ls.PushString("reader function must return a string")
+			return loadAux(ls, LUA_ERRSYNTAX)
+		}
+		piece := ls.ToString(-1)
+		ls.Pop(1)
+		if piece == "" {
+			break
+		}
+		chunk = append(chunk, piece...)
+	}
+	return loadAux(ls, ls.Load(chunk, chunkName, "bt"))
+}
+
+// dofile ([filename])
+// http://www.lua.org/manual/5.1/manual.html#pdf-dofile
+// lua-5.1.5/src/lbaselib.c#luaB_dofile()
+func baseDoFile(ls LuaState) int {
+	fname := ls.OptString(1, "")
+	n := ls.GetTop()
+	if ls.LoadFile(fname) != LUA_OK {
+		ls.Error()
+	}
+	ls.CallK(0, LUA_MULTRET, n, doFileCont)
+	return doFileCont(ls, LUA_OK, n)
+}
+
+// lua-5.3.4/src/lbaselib.c#dofilecont()
+func doFileCont(ls LuaState, status, n int) int {
+	return ls.GetTop() - n
+}
+
+// collectgarbage ([opt [, arg]])
+// http://www.lua.org/manual/5.1/manual.html#pdf-collectgarbage
+// lua-5.1.5/src/lbaselib.c#luaB_collectgarbage()
+/*
+	The values are collected by the go runtime, the options are mapped to it:
+		"collect", "step"			run a garbage collection
+		"count"						the heap in use, in Kbytes
+		"stop", "restart"			ignored, the collector is shared by the whole process
+		"setpause", "setstepmul"	kept by the state and ignored, return the previous value
+*/
+func baseCollectGarbage(ls LuaState) int {
+	opt := ls.OptString(1, "collect")
+	arg := ls.OptInteger(2, 0)
+	switch opt {
+	case "collect":
+		runtime.GC()
+		ls.PushInteger(0)
+	case "step":
+		runtime.GC()
+		ls.PushBoolean(true) /* a cycle is finished */
+	case "count":
+		ls.PushNumber(float64(heapInUse()) / 1024)
+	case "stop", "restart":
+		ls.PushInteger(0)
+	case "setpause":
+		ls.PushInteger(setGCParam(ls, GC_PAUSE, arg))
+	case "setstepmul":
+		ls.PushInteger(setGCParam(ls, GC_STEPMUL, arg))
+	default:
+		return ls.ArgError(1, fmt.Sprintf("invalid option '%s'", opt))
+	}
+	return 1
+}
+
+// Set the parameter of the collector kept in the registry, return its previous value.
+func setGCParam(ls LuaState, key string, value int64) int64 {
+	ls.GetField(LUA_REGISTRYINDEX, key)
+	old, ok := ls.ToIntegerX(-1)
+	if !ok {
+		old = 200 /* the default pause and step multiplier of lua */
+	}
+	ls.Pop(1)
+	ls.PushInteger(value)
+	ls.SetField(LUA_REGISTRYINDEX, key)
+	return old
+}
+
+// gcinfo ()
+// Deprecated in lua 5.1, the same as collectgarbage("count") in whole Kbytes.
+func baseGCInfo(ls LuaState) int {
+	ls.PushInteger(int64(heapInUse() / 1024))
+	return 1
+}
+
+func heapInUse() uint64 {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc
+}
@@ -0,0 +1,163 @@
+package stdlib
+
+import (
+	. "goluar/api"
+	. "goluar/common"
+)
+
+var coFuncs = map[string]GoFunction{
+	"create":  coCreate,
+	"resume":  coResume,
+	"running": coRunning,
+	"status":  coStatus,
+	"wrap":    coWrap,
+	"yield":   coYield,
+}
+
+/*
+	lua-5.1.5/src/lbaselib.c#base_open()
+	The coroutine functions are opened by the base library of lua 5.1, they are
+	a library of their own here, as lua 5.2 does.
+*/
+func OpenCoroutineLib(ls LuaState) int {
+	ls.NewLib(coFuncs)
+	return 1
+}
+
+const (
+	CO_RUN = iota /* running */
+	CO_SUS        /* suspended */
+	CO_NOR        /* 'normal' (it resumed another coroutine) */
+	CO_DEAD
+)
+
+var statNames = []string{"running", "suspended", "normal", "dead"}
+
+// lua-5.1.5/src/lbaselib.c#costatus()
+func costatus(ls, co LuaState) int {
+	if ls == co {
+		return CO_RUN
+	}
+	switch co.Status() {
+	case LUA_YIELD:
+		return CO_SUS
+	case LUA_OK:
+		if co.GetStack() { /* does it have frames? */
+			return CO_NOR /* it is running */
+		} else if co.GetTop() == 0 {
+			return CO_DEAD
+		} else {
+			return CO_SUS /* initial state */
+		}
+	default: /* some error occured */
+		return CO_DEAD
+	}
+}
+
+// coroutine.status (co)
+// http://www.lua.org/manual/5.1/manual.html#pdf-coroutine.status
+// lua-5.1.5/src/lbaselib.c#luaB_costatus()
+func coStatus(ls LuaState) int {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "coroutine expected")
+	ls.PushString(statNames[costatus(ls, co)])
+	return 1
+}
+
+/*
+	@description
+		Resume the coroutine with the n values at the top of the stack.
+	@return
+		n	int	"number of values yielded or returned by the coroutine, moved to the stack;
+				 -1 if there is an error, the error message is at the top"
+*/
+func auxResume(ls, co LuaState, nArg int) int {
+	status := costatus(ls, co)
+	if !co.CheckStack(nArg) {
+		ls.Error2("too many arguments to resume")
+	}
+	if status != CO_SUS {
+		ls.PushString("cannot resume " + statNames[status] + " coroutine")
+		return -1 /* error flag */
+	}
+	ls.XMove(co, nArg)
+	if status := co.Resume(ls, nArg); status == LUA_OK || status == LUA_YIELD {
+		nRes := co.GetTop()
+		if !ls.CheckStack(nRes + 1) {
+			ls.Error2("too many results to resume")
+		}
+		co.XMove(ls, nRes) /* move yielded values */
+		return nRes
+	}
+	co.XMove(ls, 1) /* move error message */
+	return -1       /* error flag */
+}
+
+// coroutine.resume (co [, val1, ···])
+// http://www.lua.org/manual/5.1/manual.html#pdf-coroutine.resume
+// lua-5.1.5/src/lbaselib.c#luaB_coresume()
+func coResume(ls LuaState) int {
+	co := ls.ToThread(1)
+	ls.ArgCheck(co != nil, 1, "coroutine expected")
+	r := auxResume(ls, co, ls.GetTop()-1)
+	if r < 0 {
+		ls.PushBoolean(false)
+		ls.Insert(-2)
+		return 2 /* return false + error message */
+	}
+	ls.PushBoolean(true)
+	ls.Insert(-(r + 1))
+	return r + 1 /* return true + `resume' returns */
+}
+
+// lua-5.1.5/src/lbaselib.c#auxwrap()
+func auxWrap(ls LuaState) int {
+	co := ls.ToThread(LuaUpvalueIndex(1))
+	r := auxResume(ls, co, ls.GetTop())
+	if r < 0 {
+		if ls.IsString(-1) { /* error object is a string? */
+			ls.Where(1) /* get extra info */
+			ls.Insert(-2)
+			ls.Concat(2)
+		}
+		ls.Error() /* propagate error */
+	}
+	return r
+}
+
+// coroutine.create (f)
+// http://www.lua.org/manual/5.1/manual.html#pdf-coroutine.create
+// lua-5.1.5/src/lbaselib.c#luaB_cocreate()
+func coCreate(ls LuaState) int {
+	nl := ls.NewThread()
+	ls.ArgCheck(ls.IsFunction(1) && !ls.IsGoFunction(1), 1, "Lua function expected")
+	ls.PushValue(1) /* move function to top */
+	ls.XMove(nl, 1) /* move function from ls to nl */
+	return 1
+}
+
+// coroutine.wrap (f)
+// http://www.lua.org/manual/5.1/manual.html#pdf-coroutine.wrap
+// lua-5.1.5/src/lbaselib.c#luaB_cowrap()
+func coWrap(ls LuaState) int {
+	coCreate(ls)
+	ls.PushGoClosure(auxWrap, 1)
+	return 1
+}
+
+// coroutine.yield (···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-coroutine.yield
+// lua-5.1.5/src/lbaselib.c#luaB_yield()
+func coYield(ls LuaState) int {
+	return ls.Yield(ls.GetTop())
+}
+
+// coroutine.running ()
+// http://www.lua.org/manual/5.1/manual.html#pdf-coroutine.running
+// lua-5.1.5/src/lbaselib.c#luaB_corunning()
+func coRunning(ls LuaState) int {
+	if ls.PushThread() {
+		ls.PushNil() /* main thread is not a coroutine */
+	}
+	return 1
+}
@@ -0,0 +1,760 @@
+package stdlib
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"syscall"
+)
+
+const (
+	LUA_FILEHANDLE = "FILE*"      // the registry key of the metatable of file handles
+	IO_INPUT       = "_IO_input"  // the registry key of the default input file
+	IO_OUTPUT      = "_IO_output" // the registry key of the default output file
+	IO_CONFIG      = "_IO_config" // the registry key of the IOConfig of the state
+)
+
+/*
+	The files of the io library. A nil FS can not open any file, and a nil standard
+	stream fails with "bad file descriptor", so the zero IOConfig is a sandbox without
+	any file.
+*/
+type IOConfig struct {
+	FS     FileSystem
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+/*
+	@description
+		Make the opener of an io library which uses the files of the config, such as
+		an in-memory file system and a buffer capturing the output:
+			ls := vm.New()
+			ls.RequireF("io", stdlib.NewIOLib(stdlib.IOConfig{FS: mfs, Stdout: &buf}), true)
+			ls.Pop(1)
+			ls.OpenLibs() // the io library already loaded is kept
+*/
+func NewIOLib(cfg IOConfig) GoFunction {
+	if cfg.FS == nil {
+		cfg.FS = noFileSystem{}
+	}
+	lib := &ioLib{cfg}
+	return lib.open
+}
+
+/*
+	lua-5.1.5/src/liolib.c#luaopen_io()
+	The io library of OpenLibs, which uses the files of the operating system.
+*/
+func OpenIOLib(ls LuaState) int {
+	return NewIOLib(osIOConfig)(ls)
+}
+
+// The config of the io library of the state, so that print, dofile and loadfile use the same files.
+func ioConfig(ls LuaState) *IOConfig {
+	ls.GetField(LUA_REGISTRYINDEX, IO_CONFIG)
+	cfg, _ := ls.ToUserData(-1).(*IOConfig)
+	ls.Pop(1)
+	if cfg == nil {
+		return &osIOConfig
+	}
+	return cfg
+}
+
+// The standard output of the state, print writes to it. It is nil if the output is discarded.
+func Stdout(ls LuaState) io.Writer {
+	return ioConfig(ls).Stdout
+}
+
+/*
+	@description
+		Read the file of the file system of the state, or its standard input if the name is
+		empty. LoadFile and dofile read the chunks with it, so a sandbox can not read the host
+		files through them.
+*/
+func ReadFile(ls LuaState, name string) ([]byte, error) {
+	cfg := ioConfig(ls)
+	if name == "" {
+		if cfg.Stdin == nil {
+			return nil, syscall.EBADF
+		}
+		return ioutil.ReadAll(cfg.Stdin)
+	}
+	f, err := cfg.FS.OpenFile(name, os.O_RDONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+type ioLib struct {
+	cfg IOConfig
+}
+
+// The config of the io library of OpenIOLib, used by the states without an io library.
+var osIOConfig = IOConfig{
+	FS:     OSFileSystem{},
+	Stdin:  os.Stdin,
+	Stdout: os.Stdout,
+	Stderr: os.Stderr,
+}
+
+func (self *ioLib) open(ls LuaState) int {
+	ls.PushLightUserData(&self.cfg)
+	ls.SetField(LUA_REGISTRYINDEX, IO_CONFIG)
+	createMeta(ls)
+	ls.NewLib(FuncReg{
+		"close":   ioClose,
+		"flush":   ioFlush,
+		"input":   self.input,
+		"lines":   self.lines,
+		"open":    self.openFile,
+		"output":  self.output,
+		"popen":   ioPopen,
+		"read":    ioRead,
+		"tmpfile": ioTmpfile,
+		"type":    ioType,
+		"write":   ioWrite,
+	})
+	/* create (and set) default files */
+	createStdFile(ls, &luaFile{f: stdFile{r: self.cfg.Stdin}, std: true}, IO_INPUT, "stdin")
+	createStdFile(ls, &luaFile{f: stdFile{w: self.cfg.Stdout}, std: true}, IO_OUTPUT, "stdout")
+	createStdFile(ls, &luaFile{f: stdFile{w: self.cfg.Stderr}, std: true}, "", "stderr")
+	return 1
+}
+
+var fileMethods = map[string]GoFunction{
+	"close":      fClose,
+	"flush":      fFlush,
+	"lines":      fLines,
+	"read":       fRead,
+	"seek":       fSeek,
+	"setvbuf":    fSetvbuf,
+	"write":      fWrite,
+	"__tostring": fToString,
+}
+
+// The metatable of file handles, its __index is itself.
+func createMeta(ls LuaState) {
+	ls.NewMetatable(LUA_FILEHANDLE) /* create metatable for file handles */
+	ls.PushValue(-1)                /* push metatable */
+	ls.SetField(-2, "__index")      /* metatable.__index = metatable */
+	ls.SetFuncs(fileMethods, 0)     /* add file methods to new metatable */
+	ls.Pop(1)
+}
+
+// Set the file as the field of the io table at the top, and as the default file if k is not empty.
+func createStdFile(ls LuaState, f *luaFile, k, fname string) {
+	newFile(ls, f)
+	if k != "" {
+		ls.PushValue(-1)
+		ls.SetField(LUA_REGISTRYINDEX, k)
+	}
+	ls.SetField(-2, fname)
+}
+
+/*
+	A file handle of lua, the userdata of the file. Reads are buffered by r, so the
+	file is ahead of the position seen by lua by r.Buffered() bytes.
+*/
+type luaFile struct {
+	f      File
+	r      *bufio.Reader
+	closed bool
+	std    bool // the standard files can not be closed
+}
+
+// Push a new file handle.
+func newFile(ls LuaState, f *luaFile) *luaFile {
+	ls.NewUserData(f)
+	ls.SetMetatableByName(LUA_FILEHANDLE)
+	return f
+}
+
+// The file handle at the index, or nil if the value is not a file handle.
+func testFile(ls LuaState, idx int) *luaFile {
+	f, _ := ls.TestUdata(idx, LUA_FILEHANDLE).(*luaFile)
+	return f
+}
+
+// lua-5.1.5/src/liolib.c#tofilep()
+func toFileP(ls LuaState) *luaFile {
+	return ls.CheckUdata(1, LUA_FILEHANDLE).(*luaFile)
+}
+
+// lua-5.1.5/src/liolib.c#tofile()
+func toFile(ls LuaState) *luaFile {
+	f := toFileP(ls)
+	if f.closed {
+		ls.Error2("attempt to use a closed file")
+	}
+	return f
+}
+
+func (self *luaFile) reader() *bufio.Reader {
+	if self.r == nil {
+		self.r = bufio.NewReader(self.f)
+	}
+	return self.r
+}
+
+/*
+	Drop the buffered input and move the file back to the position seen by lua,
+	before writing or seeking. The input of a file which can not seek is kept.
+*/
+func (self *luaFile) sync() error {
+	if self.r == nil || self.r.Buffered() == 0 {
+		return nil
+	}
+	s, ok := self.f.(io.Seeker)
+	if !ok {
+		return nil
+	}
+	n := self.r.Buffered()
+	self.r.Reset(self.f)
+	_, err := s.Seek(int64(-n), io.SeekCurrent)
+	return err
+}
+
+func (self *luaFile) close() error {
+	self.closed = true
+	self.r = nil
+	return self.f.Close()
+}
+
+func (self *luaFile) flush() error {
+	if f, ok := self.f.(interface{ Flush() error }); ok {
+		return f.Flush()
+	} else if f, ok := self.f.(stdFile); ok {
+		if w, ok := f.w.(interface{ Flush() error }); ok {
+			return w.Flush()
+		}
+	}
+	return nil
+}
+
+/*
+	@description
+		Push the result of an io operation: true on success, or nil, the message and
+		the error number on failure.
+	@return
+		n	int	"number of values pushed"
+*/
+func pushResult(ls LuaState, err error, filename string) int {
+	if err == nil {
+		ls.PushBoolean(true)
+		return 1
+	}
+	ls.PushNil()
+	if filename != "" {
+		ls.PushString(filename + ": " + errorString(err))
+	} else {
+		ls.PushString(errorString(err))
+	}
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		ls.PushInteger(int64(errno))
+	} else {
+		ls.PushInteger(0)
+	}
+	return 3
+}
+
+// The message of the error without the operation and the file name, like strerror of C.
+func errorString(err error) string {
+	var pathErr *fs.PathError
+	var linkErr *os.LinkError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	} else if errors.As(err, &linkErr) {
+		return linkErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// lua-5.1.5/src/liolib.c#fileerror()
+func fileError(ls LuaState, arg int, filename string, err error) {
+	ls.ArgError(arg, filename+": "+errorString(err))
+}
+
+// io.type (obj)
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.type
+// lua-5.1.5/src/liolib.c#io_type()
+func ioType(ls LuaState) int {
+	ls.CheckAny(1)
+	if f := testFile(ls, 1); f == nil {
+		ls.PushNil() /* not a file */
+	} else if f.closed {
+		ls.PushString("closed file")
+	} else {
+		ls.PushString("file")
+	}
+	return 1
+}
+
+// lua-5.1.5/src/liolib.c#io_tostring()
+func fToString(ls LuaState) int {
+	if f := toFileP(ls); f.closed {
+		ls.PushString("file (closed)")
+	} else {
+		ls.PushString(fmt.Sprintf("file (%p)", f))
+	}
+	return 1
+}
+
+// lua-5.1.5/src/liolib.c#aux_close()
+func auxClose(ls LuaState) int {
+	f := toFile(ls)
+	if f.std {
+		ls.PushNil()
+		ls.PushString("cannot close standard file")
+		return 2
+	}
+	return pushResult(ls, f.close(), "")
+}
+
+// file:close ()
+// http://www.lua.org/manual/5.1/manual.html#pdf-file:close
+// lua-5.1.5/src/liolib.c#io_close()
+func fClose(ls LuaState) int {
+	return auxClose(ls)
+}
+
+// io.close ([file])
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.close
+// lua-5.1.5/src/liolib.c#io_close()
+func ioClose(ls LuaState) int {
+	if ls.IsNone(1) {
+		ls.GetField(LUA_REGISTRYINDEX, IO_OUTPUT)
+	}
+	return auxClose(ls)
+}
+
+/*
+	The mode of fopen as the flag of os.OpenFile, false if the mode is invalid.
+	The 'b' of the binary modes is ignored.
+*/
+func openFlag(mode string) (int, bool) {
+	if len(mode) > 0 && mode[len(mode)-1] == 'b' {
+		mode = mode[:len(mode)-1]
+	}
+	switch mode {
+	case "r":
+		return os.O_RDONLY, true
+	case "w":
+		return os.O_WRONLY | os.O_CREATE | os.O_TRUNC, true
+	case "a":
+		return os.O_WRONLY | os.O_CREATE | os.O_APPEND, true
+	case "r+":
+		return os.O_RDWR, true
+	case "w+":
+		return os.O_RDWR | os.O_CREATE | os.O_TRUNC, true
+	case "a+":
+		return os.O_RDWR | os.O_CREATE | os.O_APPEND, true
+	}
+	return 0, false
+}
+
+// io.open (filename [, mode])
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.open
+// lua-5.1.5/src/liolib.c#io_open()
+// An invalid mode is an error, as lua 5.2 does.
+func (self *ioLib) openFile(ls LuaState) int {
+	filename := ls.CheckString(1)
+	mode := ls.OptString(2, "r")
+	flag, ok := openFlag(mode)
+	ls.ArgCheck(ok, 2, "invalid mode")
+	f, err := self.cfg.FS.OpenFile(filename, flag, 0666)
+	if err != nil {
+		return pushResult(ls, err, filename)
+	}
+	newFile(ls, &luaFile{f: f})
+	return 1
+}
+
+// io.popen (prog [, mode])
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.popen
+// lua-5.1.5/src/liolib.c#io_popen()
+// Programs can not be run by the io library.
+func ioPopen(ls LuaState) int {
+	return ls.Error2("'popen' not supported")
+}
+
+// io.tmpfile ()
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.tmpfile
+// lua-5.1.5/src/liolib.c#io_tmpfile()
+// The temporary file is kept in memory, out of the file system.
+func ioTmpfile(ls LuaState) int {
+	newFile(ls, &luaFile{f: newMemFile(&memData{}, os.O_RDWR)})
+	return 1
+}
+
+// Push the default file, which must not be closed.
+func getIOFile(ls LuaState, k string) *luaFile {
+	ls.GetField(LUA_REGISTRYINDEX, k)
+	f := testFile(ls, -1)
+	if f.closed {
+		name := "input"
+		if k == IO_OUTPUT {
+			name = "output"
+		}
+		ls.Error2("standard %s file is closed", name)
+	}
+	return f
+}
+
+// lua-5.1.5/src/liolib.c#g_iofile()
+func (self *ioLib) ioFile(ls LuaState, k, mode string) int {
+	if !ls.IsNoneOrNil(1) {
+		if ls.Type(1) == LUA_TSTRING || ls.Type(1) == LUA_TNUMBER {
+			filename := ls.ToString(1)
+			flag, _ := openFlag(mode)
+			f, err := self.cfg.FS.OpenFile(filename, flag, 0666)
+			if err != nil {
+				fileError(ls, 1, filename, err)
+			}
+			newFile(ls, &luaFile{f: f})
+		} else {
+			toFile(ls) /* check that it's a valid file handle */
+			ls.PushValue(1)
+		}
+		ls.SetField(LUA_REGISTRYINDEX, k)
+	}
+	/* return current value */
+	ls.GetField(LUA_REGISTRYINDEX, k)
+	return 1
+}
+
+// io.input ([file])
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.input
+// lua-5.1.5/src/liolib.c#io_input()
+func (self *ioLib) input(ls LuaState) int {
+	return self.ioFile(ls, IO_INPUT, "r")
+}
+
+// io.output ([file])
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.output
+// lua-5.1.5/src/liolib.c#io_output()
+func (self *ioLib) output(ls LuaState) int {
+	return self.ioFile(ls, IO_OUTPUT, "w")
+}
+
+// Push the iterator of the lines of the file at idx, which closes the file at the end if toClose.
+// lua-5.1.5/src/liolib.c#aux_lines()
+func auxLines(ls LuaState, idx int, toClose bool) {
+	ls.PushValue(idx)
+	ls.PushBoolean(toClose) /* close/not close file when finished */
+	ls.PushGoClosure(ioReadline, 2)
+}
+
+// file:lines ()
+// http://www.lua.org/manual/5.1/manual.html#pdf-file:lines
+// lua-5.1.5/src/liolib.c#f_lines()
+func fLines(ls LuaState) int {
+	toFile(ls) /* check that it's a valid file handle */
+	auxLines(ls, 1, false)
+	return 1
+}
+
+// io.lines ([filename])
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.lines
+// lua-5.1.5/src/liolib.c#io_lines()
+func (self *ioLib) lines(ls LuaState) int {
+	if ls.IsNoneOrNil(1) { /* no arguments? */
+		/* will iterate over default input */
+		ls.SetTop(0)
+		ls.GetField(LUA_REGISTRYINDEX, IO_INPUT)
+		return fLines(ls)
+	}
+	filename := ls.CheckString(1)
+	f, err := self.cfg.FS.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		fileError(ls, 1, filename, err)
+	}
+	newFile(ls, &luaFile{f: f})
+	auxLines(ls, ls.GetTop(), true)
+	return 1
+}
+
+/*
+** {======================================================
+** READ
+** =======================================================
+ */
+
+// lua-5.1.5/src/liolib.c#read_number()
+func (self *luaFile) readNumber(ls LuaState) (bool, error) {
+	r := self.reader()
+	var buf []byte
+	c, err := r.ReadByte()
+	for err == nil && isSpace(c) { /* skip whitespaces, like fscanf */
+		c, err = r.ReadByte()
+	}
+	accept := func(test func(byte) bool) bool {
+		if err == nil && test(c) {
+			buf = append(buf, c)
+			c, err = r.ReadByte()
+			return true
+		}
+		return false
+	}
+	digits, hex := isDigit, false
+	accept(func(c byte) bool { return c == '-' || c == '+' })
+	if accept(func(c byte) bool { return c == '0' }) &&
+		accept(func(c byte) bool { return c == 'x' || c == 'X' }) {
+		digits, hex = isXDigit, true
+	}
+	for accept(digits) {
+	}
+	if accept(func(c byte) bool { return c == '.' }) {
+		for accept(digits) {
+		}
+	}
+	if !hex && len(buf) > 0 && accept(func(c byte) bool { return c == 'e' || c == 'E' }) {
+		accept(func(c byte) bool { return c == '-' || c == '+' })
+		for accept(isDigit) {
+		}
+	}
+	if err == nil {
+		r.UnreadByte()
+	} else if err != io.EOF {
+		return false, err
+	}
+	if ls.StringToNumber(string(buf)) {
+		return true, nil
+	}
+	ls.PushNil()      /* "result" to be removed */
+	return false, nil /* read fails */
+}
+
+// lua-5.1.5/src/liolib.c#test_eof()
+func (self *luaFile) testEOF(ls LuaState) (bool, error) {
+	_, err := self.reader().Peek(1)
+	ls.PushString("")
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// lua-5.1.5/src/liolib.c#read_line()
+func (self *luaFile) readLine(ls LuaState) (bool, error) {
+	line, err := self.reader().ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1] /* do not include `eol' */
+	} else if len(line) == 0 {
+		ls.PushString("")
+		return false, nil /* eof */
+	}
+	ls.PushString(line)
+	return true, nil
+}
+
+// lua-5.1.5/src/liolib.c#read_chars()
+func (self *luaFile) readChars(ls LuaState, n int64) (bool, error) {
+	data, err := ioutil.ReadAll(io.LimitReader(self.reader(), n))
+	ls.PushString(string(data))
+	return len(data) > 0, err
+}
+
+// lua-5.1.5/src/liolib.c#g_read()
+func gRead(ls LuaState, f *luaFile, first int) int {
+	nArgs := ls.GetTop() - 1
+	var success bool
+	var err error
+	n := first
+	if nArgs == 0 { /* no arguments? */
+		success, err = f.readLine(ls)
+		n = first + 1 /* to return 1 result */
+	} else { /* ensure stack space for all results and for auxlib's buffer */
+		ls.CheckStack2(nArgs+LUA_MINSTACK, "too many arguments")
+		success = true
+		for ; nArgs > 0 && success && err == nil; n++ {
+			nArgs--
+			if ls.Type(n) == LUA_TNUMBER {
+				if l := ls.ToInteger(n); l == 0 {
+					success, err = f.testEOF(ls)
+				} else {
+					success, err = f.readChars(ls, l)
+				}
+			} else {
+				p, _ := ls.ToStringX(n)
+				ls.ArgCheck(len(p) > 0 && p[0] == '*', n, "invalid option")
+				switch p += "\x00"; p[1] { /* only the first letter counts, as "*line" */
+				case 'n': /* number */
+					success, err = f.readNumber(ls)
+				case 'l': /* line */
+					success, err = f.readLine(ls)
+				case 'a': /* file */
+					_, err = f.readChars(ls, 1<<62) /* read MAX_SIZE_T chars */
+					success = true                  /* always success */
+				default:
+					return ls.ArgError(n, "invalid format")
+				}
+			}
+		}
+	}
+	if err != nil {
+		return pushResult(ls, err, "")
+	}
+	if !success {
+		ls.Pop(1)    /* remove last result */
+		ls.PushNil() /* push nil instead */
+	}
+	return n - first
+}
+
+// io.read (···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.read
+// lua-5.1.5/src/liolib.c#io_read()
+func ioRead(ls LuaState) int {
+	return gRead(ls, getIOFile(ls, IO_INPUT), 1)
+}
+
+// file:read (···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-file:read
+// lua-5.1.5/src/liolib.c#f_read()
+func fRead(ls LuaState) int {
+	return gRead(ls, toFile(ls), 2)
+}
+
+// lua-5.1.5/src/liolib.c#io_readline()
+func ioReadline(ls LuaState) int {
+	f := testFile(ls, LuaUpvalueIndex(1))
+	if f.closed { /* file is already closed? */
+		ls.Error2("file is already closed")
+	}
+	success, err := f.readLine(ls)
+	if err != nil {
+		return ls.Error2("%s", errorString(err))
+	}
+	if success {
+		return 1
+	}
+	/* EOF */
+	if ls.ToBoolean(LuaUpvalueIndex(2)) { /* generate a `close' for file? */
+		ls.SetTop(0)
+		ls.PushValue(LuaUpvalueIndex(1))
+		auxClose(ls) /* close it */
+	}
+	return 0
+}
+
+/* }====================================================== */
+
+// lua-5.1.5/src/liolib.c#g_write()
+func gWrite(ls LuaState, f *luaFile, arg int) int {
+	nArgs := ls.GetTop() - 1
+	err := f.sync()
+	for ; nArgs > 0; arg++ {
+		nArgs--
+		s := ls.CheckString(arg) /* numbers are written in the format of tostring */
+		if err == nil {
+			_, err = io.WriteString(f.f, s)
+		}
+	}
+	return pushResult(ls, err, "")
+}
+
+// io.write (···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.write
+// lua-5.1.5/src/liolib.c#io_write()
+func ioWrite(ls LuaState) int {
+	return gWrite(ls, getIOFile(ls, IO_OUTPUT), 1)
+}
+
+// file:write (···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-file:write
+// lua-5.1.5/src/liolib.c#f_write()
+func fWrite(ls LuaState) int {
+	return gWrite(ls, toFile(ls), 2)
+}
+
+// file:seek ([whence] [, offset])
+// http://www.lua.org/manual/5.1/manual.html#pdf-file:seek
+// lua-5.1.5/src/liolib.c#f_seek()
+func fSeek(ls LuaState) int {
+	f := toFile(ls)
+	var whence int
+	switch op := ls.OptString(2, "cur"); op {
+	case "set":
+		whence = io.SeekStart
+	case "cur":
+		whence = io.SeekCurrent
+	case "end":
+		whence = io.SeekEnd
+	default:
+		return ls.ArgError(2, fmt.Sprintf("invalid option '%s'", op))
+	}
+	offset := ls.OptInteger(3, 0)
+	s, ok := f.f.(io.Seeker)
+	if !ok {
+		return pushResult(ls, errors.New("illegal seek"), "")
+	}
+	err := f.sync()
+	if f.r != nil {
+		f.r.Reset(f.f) /* the input of the old position is useless */
+	}
+	if err == nil {
+		var pos int64
+		if pos, err = s.Seek(offset, whence); err == nil {
+			ls.PushInteger(pos)
+			return 1
+		}
+	}
+	return pushResult(ls, err, "") /* error */
+}
+
+// file:setvbuf (mode [, size])
+// http://www.lua.org/manual/5.1/manual.html#pdf-file:setvbuf
+// lua-5.1.5/src/liolib.c#f_setvbuf()
+// The writes are not buffered, only the mode is checked.
+func fSetvbuf(ls LuaState) int {
+	toFile(ls)
+	switch mode := ls.CheckString(2); mode {
+	case "no", "full", "line":
+	default:
+		return ls.ArgError(2, fmt.Sprintf("invalid option '%s'", mode))
+	}
+	return pushResult(ls, nil, "")
+}
+
+// io.flush ()
+// http://www.lua.org/manual/5.1/manual.html#pdf-io.flush
+// lua-5.1.5/src/liolib.c#io_flush()
+func ioFlush(ls LuaState) int {
+	return pushResult(ls, getIOFile(ls, IO_OUTPUT).flush(), "")
+}
+
+// file:flush ()
+// http://www.lua.org/manual/5.1/manual.html#pdf-file:flush
+// lua-5.1.5/src/liolib.c#f_flush()
+func fFlush(ls LuaState) int {
+	return pushResult(ls, toFile(ls).flush(), "")
+}
+
+// The file system of a sandbox without any file.
+type noFileSystem struct{}
+
+func (noFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+}
+
+func (noFileSystem) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (noFileSystem) Rename(oldName, newName string) error {
+	return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrPermission}
+}
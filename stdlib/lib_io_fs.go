@@ -0,0 +1,280 @@
+package stdlib
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+/*
+	A file opened by the io library. Files which can be positioned by file:seek
+	implement io.Seeker as well.
+*/
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+}
+
+/*
+	The files seen by the io and os libraries. Hosts replace it with IOConfig and OSConfig
+	to run scripts against a sandbox, see MemFileSystem and ReadOnlyFileSystem.
+*/
+type FileSystem interface {
+	/*
+		@description
+			Open the named file like os.OpenFile, the flag is a combination of
+			os.O_RDONLY, os.O_WRONLY, os.O_RDWR, os.O_CREATE, os.O_EXCL, os.O_TRUNC
+			and os.O_APPEND.
+	*/
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+	Remove(name string) error             // os.remove
+	Rename(oldName, newName string) error // os.rename
+}
+
+// The files of the operating system.
+type OSFileSystem struct{}
+
+func (OSFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err // not a nil *os.File in the interface
+	}
+	return f, nil
+}
+
+func (OSFileSystem) Remove(name string) error {
+	return os.Remove(name)
+}
+
+func (OSFileSystem) Rename(oldName, newName string) error {
+	return os.Rename(oldName, newName)
+}
+
+/*
+	The files of the fs.FS, which can only be opened for reading. A leading '/' of
+	the names is ignored, as the root of fsys is the root of the file system.
+*/
+func ReadOnlyFileSystem(fsys fs.FS) FileSystem {
+	return readOnlyFS{fsys}
+}
+
+type readOnlyFS struct {
+	fsys fs.FS
+}
+
+func (self readOnlyFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrPermission}
+	}
+	f, err := self.fsys.Open(strings.TrimPrefix(path.Clean("/"+name), "/"))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := f.(io.Seeker); ok {
+		return seekableFSFile{fsFile{f}}, nil
+	}
+	return fsFile{f}, nil
+}
+
+func (self readOnlyFS) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (self readOnlyFS) Rename(oldName, newName string) error {
+	return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrPermission}
+}
+
+// A file of fs.FS, it can not be written.
+type fsFile struct {
+	fs.File
+}
+
+func (self fsFile) Write(p []byte) (int, error) {
+	return 0, errors.New("bad file descriptor")
+}
+
+type seekableFSFile struct {
+	fsFile
+}
+
+func (self seekableFSFile) Seek(offset int64, whence int) (int64, error) {
+	return self.File.(io.Seeker).Seek(offset, whence)
+}
+
+/*
+	An in-memory file system, the files are only kept by the MemFileSystem.
+	It is safe for concurrent use by several states.
+*/
+type MemFileSystem struct {
+	mu    sync.Mutex
+	files map[string]*memData
+}
+
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{files: map[string]*memData{}}
+}
+
+// The contents of a file.
+type memData struct {
+	mu   sync.Mutex
+	data []byte
+}
+
+func (self *MemFileSystem) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	d := self.files[name]
+	if d == nil {
+		if flag&os.O_CREATE == 0 {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+		}
+		d = &memData{}
+		self.files[name] = d
+	} else if flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	} else if flag&os.O_TRUNC != 0 {
+		d.mu.Lock()
+		d.data = nil
+		d.mu.Unlock()
+	}
+	return newMemFile(d, flag), nil
+}
+
+func (self *MemFileSystem) Remove(name string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	if self.files[name] == nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(self.files, name)
+	return nil
+}
+
+// The open files of the old name keep the contents, like the files of unix.
+func (self *MemFileSystem) Rename(oldName, newName string) error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	d := self.files[oldName]
+	if d == nil {
+		return &os.LinkError{Op: "rename", Old: oldName, New: newName, Err: fs.ErrNotExist}
+	}
+	delete(self.files, oldName)
+	self.files[newName] = d
+	return nil
+}
+
+// Create or replace the named file with the data.
+func (self *MemFileSystem) WriteFile(name string, data []byte) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+	self.files[name] = &memData{data: append([]byte(nil), data...)}
+}
+
+// The contents of the named file, false if it does not exist.
+func (self *MemFileSystem) ReadFile(name string) ([]byte, bool) {
+	self.mu.Lock()
+	d := self.files[name]
+	self.mu.Unlock()
+	if d == nil {
+		return nil, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]byte(nil), d.data...), true
+}
+
+// An open file of MemFileSystem, or a temporary file of io.tmpfile.
+type memFile struct {
+	d      *memData
+	pos    int64
+	flag   int
+	closed bool
+}
+
+func newMemFile(d *memData, flag int) *memFile {
+	return &memFile{d: d, flag: flag}
+}
+
+func (self *memFile) Read(p []byte) (int, error) {
+	if self.closed || self.flag&os.O_WRONLY != 0 {
+		return 0, errors.New("bad file descriptor")
+	}
+	self.d.mu.Lock()
+	defer self.d.mu.Unlock()
+	if self.pos >= int64(len(self.d.data)) {
+		return 0, io.EOF
+	}
+	n := copy(p, self.d.data[self.pos:])
+	self.pos += int64(n)
+	return n, nil
+}
+
+func (self *memFile) Write(p []byte) (int, error) {
+	if self.closed || self.flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		return 0, errors.New("bad file descriptor")
+	}
+	self.d.mu.Lock()
+	defer self.d.mu.Unlock()
+	if self.flag&os.O_APPEND != 0 {
+		self.pos = int64(len(self.d.data))
+	}
+	if end := self.pos + int64(len(p)); end > int64(len(self.d.data)) {
+		self.d.data = append(self.d.data, make([]byte, end-int64(len(self.d.data)))...)
+	}
+	copy(self.d.data[self.pos:], p)
+	self.pos += int64(len(p))
+	return len(p), nil
+}
+
+func (self *memFile) Seek(offset int64, whence int) (int64, error) {
+	if self.closed {
+		return 0, errors.New("bad file descriptor")
+	}
+	self.d.mu.Lock()
+	defer self.d.mu.Unlock()
+	switch whence {
+	case io.SeekCurrent:
+		offset += self.pos
+	case io.SeekEnd:
+		offset += int64(len(self.d.data))
+	}
+	if offset < 0 {
+		return 0, errors.New("invalid argument")
+	}
+	self.pos = offset
+	return offset, nil
+}
+
+func (self *memFile) Close() error {
+	self.closed = true
+	return nil
+}
+
+// A standard file of the io library, reading or writing is an error if the stream is nil.
+type stdFile struct {
+	r io.Reader
+	w io.Writer
+}
+
+func (self stdFile) Read(p []byte) (int, error) {
+	if self.r == nil {
+		return 0, errors.New("bad file descriptor")
+	}
+	return self.r.Read(p)
+}
+
+func (self stdFile) Write(p []byte) (int, error) {
+	if self.w == nil {
+		return 0, errors.New("bad file descriptor")
+	}
+	return self.w.Write(p)
+}
+
+func (self stdFile) Close() error {
+	return nil
+}
@@ -0,0 +1,323 @@
+package stdlib
+
+import (
+	. "goluar/api"
+	. "goluar/common"
+	"math"
+)
+
+var mathLib = map[string]GoFunction{
+	"abs":        mathAbs,
+	"acos":       mathAcos,
+	"asin":       mathAsin,
+	"atan2":      mathAtan2,
+	"atan":       mathAtan,
+	"ceil":       mathCeil,
+	"cosh":       mathCosh,
+	"cos":        mathCos,
+	"deg":        mathDeg,
+	"exp":        mathExp,
+	"floor":      mathFloor,
+	"fmod":       mathFmod,
+	"mod":        mathFmod, /* compatibility with lua 5.0 */
+	"frexp":      mathFrexp,
+	"ldexp":      mathLdexp,
+	"log10":      mathLog10,
+	"log":        mathLog,
+	"max":        mathMax,
+	"min":        mathMin,
+	"modf":       mathModf,
+	"pow":        mathPow,
+	"rad":        mathRad,
+	"random":     mathRandom,
+	"randomseed": mathRandomseed,
+	"sinh":       mathSinh,
+	"sin":        mathSin,
+	"sqrt":       mathSqrt,
+	"tanh":       mathTanh,
+	"tan":        mathTan,
+}
+
+/*
+	lua-5.1.5/src/lmathlib.c#luaopen_math()
+	math.random uses the random generator of the state, see SeedRandom and Random of
+	LuaState, so the numbers are reproducible across machines for the same seed.
+*/
+func OpenMathLib(ls LuaState) int {
+	ls.NewLib(mathLib)
+	ls.PushNumber(math.Pi)
+	ls.SetField(-2, "pi")
+	ls.PushNumber(math.Inf(1))
+	ls.SetField(-2, "huge")
+	return 1
+}
+
+// math.abs (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.abs
+// lua-5.1.5/src/lmathlib.c#math_abs()
+func mathAbs(ls LuaState) int {
+	ls.PushNumber(math.Abs(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.sin (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.sin
+// lua-5.1.5/src/lmathlib.c#math_sin()
+func mathSin(ls LuaState) int {
+	ls.PushNumber(math.Sin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.sinh (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.sinh
+// lua-5.1.5/src/lmathlib.c#math_sinh()
+func mathSinh(ls LuaState) int {
+	ls.PushNumber(math.Sinh(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.cos (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.cos
+// lua-5.1.5/src/lmathlib.c#math_cos()
+func mathCos(ls LuaState) int {
+	ls.PushNumber(math.Cos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.cosh (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.cosh
+// lua-5.1.5/src/lmathlib.c#math_cosh()
+func mathCosh(ls LuaState) int {
+	ls.PushNumber(math.Cosh(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.tan (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.tan
+// lua-5.1.5/src/lmathlib.c#math_tan()
+func mathTan(ls LuaState) int {
+	ls.PushNumber(math.Tan(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.tanh (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.tanh
+// lua-5.1.5/src/lmathlib.c#math_tanh()
+func mathTanh(ls LuaState) int {
+	ls.PushNumber(math.Tanh(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.asin (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.asin
+// lua-5.1.5/src/lmathlib.c#math_asin()
+func mathAsin(ls LuaState) int {
+	ls.PushNumber(math.Asin(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.acos (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.acos
+// lua-5.1.5/src/lmathlib.c#math_acos()
+func mathAcos(ls LuaState) int {
+	ls.PushNumber(math.Acos(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.atan (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.atan
+// lua-5.1.5/src/lmathlib.c#math_atan()
+func mathAtan(ls LuaState) int {
+	ls.PushNumber(math.Atan(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.atan2 (y, x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.atan2
+// lua-5.1.5/src/lmathlib.c#math_atan2()
+func mathAtan2(ls LuaState) int {
+	ls.PushNumber(math.Atan2(ls.CheckNumber(1), ls.CheckNumber(2)))
+	return 1
+}
+
+// math.ceil (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.ceil
+// lua-5.1.5/src/lmathlib.c#math_ceil()
+func mathCeil(ls LuaState) int {
+	ls.PushNumber(math.Ceil(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.floor (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.floor
+// lua-5.1.5/src/lmathlib.c#math_floor()
+func mathFloor(ls LuaState) int {
+	ls.PushNumber(math.Floor(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.fmod (x, y)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.fmod
+// lua-5.1.5/src/lmathlib.c#math_fmod()
+// Unlike fmod of C, the result has the sign of y, so math.fmod(a, b) == a % b for all numbers.
+func mathFmod(ls LuaState) int {
+	if ls.IsInteger(1) && ls.IsInteger(2) {
+		if d := ls.ToInteger(2); d != 0 {
+			ls.PushInteger(IMod(ls.ToInteger(1), d))
+			return 1
+		}
+	}
+	ls.PushNumber(FMod(ls.CheckNumber(1), ls.CheckNumber(2)))
+	return 1
+}
+
+// math.modf (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.modf
+// lua-5.1.5/src/lmathlib.c#math_modf()
+func mathModf(ls LuaState) int {
+	n := ls.CheckNumber(1)
+	if math.IsInf(n, 0) { /* modf of C gives a zero fraction */
+		ls.PushNumber(n)
+		ls.PushNumber(0)
+		return 2
+	}
+	ip, fp := math.Modf(n)
+	ls.PushNumber(ip)
+	ls.PushNumber(fp)
+	return 2
+}
+
+// math.sqrt (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.sqrt
+// lua-5.1.5/src/lmathlib.c#math_sqrt()
+func mathSqrt(ls LuaState) int {
+	ls.PushNumber(math.Sqrt(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.pow (x, y)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.pow
+// lua-5.1.5/src/lmathlib.c#math_pow()
+func mathPow(ls LuaState) int {
+	ls.PushNumber(math.Pow(ls.CheckNumber(1), ls.CheckNumber(2)))
+	return 1
+}
+
+// math.log (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.log
+// lua-5.1.5/src/lmathlib.c#math_log()
+func mathLog(ls LuaState) int {
+	ls.PushNumber(math.Log(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.log10 (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.log10
+// lua-5.1.5/src/lmathlib.c#math_log10()
+func mathLog10(ls LuaState) int {
+	ls.PushNumber(math.Log10(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.exp (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.exp
+// lua-5.1.5/src/lmathlib.c#math_exp()
+func mathExp(ls LuaState) int {
+	ls.PushNumber(math.Exp(ls.CheckNumber(1)))
+	return 1
+}
+
+// math.deg (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.deg
+// lua-5.1.5/src/lmathlib.c#math_deg()
+func mathDeg(ls LuaState) int {
+	ls.PushNumber(ls.CheckNumber(1) * (180.0 / math.Pi))
+	return 1
+}
+
+// math.rad (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.rad
+// lua-5.1.5/src/lmathlib.c#math_rad()
+func mathRad(ls LuaState) int {
+	ls.PushNumber(ls.CheckNumber(1) * (math.Pi / 180.0))
+	return 1
+}
+
+// math.frexp (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.frexp
+// lua-5.1.5/src/lmathlib.c#math_frexp()
+func mathFrexp(ls LuaState) int {
+	m, e := math.Frexp(ls.CheckNumber(1))
+	ls.PushNumber(m)
+	ls.PushInteger(int64(e))
+	return 2
+}
+
+// math.ldexp (m, e)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.ldexp
+// lua-5.1.5/src/lmathlib.c#math_ldexp()
+func mathLdexp(ls LuaState) int {
+	ls.PushNumber(math.Ldexp(ls.CheckNumber(1), int(ls.CheckNumber(2))))
+	return 1
+}
+
+// math.min (x, ···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.min
+// lua-5.1.5/src/lmathlib.c#math_min()
+func mathMin(ls LuaState) int {
+	n := ls.GetTop() /* number of arguments */
+	dmin := ls.CheckNumber(1)
+	for i := 2; i <= n; i++ {
+		if d := ls.CheckNumber(i); d < dmin {
+			dmin = d
+		}
+	}
+	ls.PushNumber(dmin)
+	return 1
+}
+
+// math.max (x, ···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.max
+// lua-5.1.5/src/lmathlib.c#math_max()
+func mathMax(ls LuaState) int {
+	n := ls.GetTop() /* number of arguments */
+	dmax := ls.CheckNumber(1)
+	for i := 2; i <= n; i++ {
+		if d := ls.CheckNumber(i); d > dmax {
+			dmax = d
+		}
+	}
+	ls.PushNumber(dmax)
+	return 1
+}
+
+// math.random ([m [, n]])
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.random
+// lua-5.1.5/src/lmathlib.c#math_random()
+func mathRandom(ls LuaState) int {
+	r := ls.Random()
+	switch ls.GetTop() { /* check number of arguments */
+	case 0: /* no arguments */
+		ls.PushNumber(r) /* Number between 0 and 1 */
+	case 1: /* only upper limit */
+		u := int64(ls.CheckNumber(1))
+		ls.ArgCheck(1 <= u, 1, "interval is empty")
+		ls.PushInteger(int64(math.Floor(r*float64(u))) + 1) /* int between 1 and `u' */
+	case 2: /* lower and upper limits */
+		l := int64(ls.CheckNumber(1))
+		u := int64(ls.CheckNumber(2))
+		ls.ArgCheck(l <= u, 2, "interval is empty")
+		ls.PushInteger(int64(math.Floor(r*float64(u-l+1))) + l) /* int between `l' and `u' */
+	default:
+		return ls.Error2("wrong number of arguments")
+	}
+	return 1
+}
+
+// math.randomseed (x)
+// http://www.lua.org/manual/5.1/manual.html#pdf-math.randomseed
+// lua-5.1.5/src/lmathlib.c#math_randomseed()
+func mathRandomseed(ls LuaState) int {
+	ls.SeedRandom(int64(ls.CheckNumber(1)))
+	return 0
+}
@@ -0,0 +1,410 @@
+package stdlib
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"io/fs"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+/*
+	The capabilities of the os library granted by the host. A function which is not
+	granted raises an error, so the zero OSConfig is a sandbox where only the functions
+	of time and setlocale work. Files are removed, renamed and created by tmpname in FS,
+	no file can be touched if it is nil.
+*/
+type OSConfig struct {
+	FS      FileSystem
+	Execute bool // os.execute
+	Exit    bool // os.exit
+	Getenv  bool // os.getenv
+	Remove  bool // os.remove
+	Rename  bool // os.rename
+	TmpName bool // os.tmpname
+}
+
+/*
+	@description
+		Make the opener of an os library with the capabilities of the config, like
+		NewIOLib, it must be required before OpenLibs to replace the os library of OpenLibs.
+*/
+func NewOSLib(cfg OSConfig) GoFunction {
+	if cfg.FS == nil {
+		cfg.FS = noFileSystem{}
+	}
+	lib := &osLib{cfg}
+	return lib.open
+}
+
+/*
+	lua-5.1.5/src/loslib.c#luaopen_os()
+	The os library of OpenLibs, all the capabilities are granted on the files of the
+	operating system.
+*/
+func OpenOSLib(ls LuaState) int {
+	return NewOSLib(OSConfig{
+		FS:      OSFileSystem{},
+		Execute: true,
+		Exit:    true,
+		Getenv:  true,
+		Remove:  true,
+		Rename:  true,
+		TmpName: true,
+	})(ls)
+}
+
+type osLib struct {
+	cfg OSConfig
+}
+
+func (self *osLib) open(ls LuaState) int {
+	ls.NewLib(FuncReg{
+		"clock":     osClock,
+		"date":      osDate,
+		"difftime":  osDifftime,
+		"execute":   allow(self.cfg.Execute, "execute", osExecute),
+		"exit":      allow(self.cfg.Exit, "exit", osExit),
+		"getenv":    allow(self.cfg.Getenv, "getenv", osGetenv),
+		"remove":    allow(self.cfg.Remove, "remove", self.remove),
+		"rename":    allow(self.cfg.Rename, "rename", self.rename),
+		"setlocale": osSetlocale,
+		"time":      osTime,
+		"tmpname":   allow(self.cfg.TmpName, "tmpname", self.tmpname),
+	})
+	return 1
+}
+
+// The function itself if the capability is granted, or a function raising an error.
+func allow(granted bool, name string, f GoFunction) GoFunction {
+	if granted {
+		return f
+	}
+	return func(ls LuaState) int {
+		return ls.Error2("'os.%s' is not allowed", name)
+	}
+}
+
+// os.execute ([command])
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.execute
+// lua-5.1.5/src/loslib.c#os_execute()
+// The status is the exit code of the command, or -1 if the shell can not be run.
+func osExecute(ls LuaState) int {
+	name, flag := "/bin/sh", "-c"
+	if runtime.GOOS == "windows" {
+		name, flag = "cmd", "/C"
+	}
+	if ls.IsNoneOrNil(1) { /* is a shell available? */
+		if _, err := exec.LookPath(name); err != nil {
+			ls.PushInteger(0)
+		} else {
+			ls.PushInteger(1)
+		}
+		return 1
+	}
+	cmd := exec.Command(name, flag, ls.CheckString(1))
+	cfg := ioConfig(ls) /* the streams of the io library, a nil one is the null device */
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = cfg.Stdin, cfg.Stdout, cfg.Stderr
+	var exitErr *exec.ExitError
+	if err := cmd.Run(); err == nil {
+		ls.PushInteger(0)
+	} else if errors.As(err, &exitErr) {
+		ls.PushInteger(int64(exitErr.ExitCode()))
+	} else {
+		ls.PushInteger(-1)
+	}
+	return 1
+}
+
+// os.remove (filename)
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.remove
+// lua-5.1.5/src/loslib.c#os_remove()
+func (self *osLib) remove(ls LuaState) int {
+	filename := ls.CheckString(1)
+	return pushResult(ls, self.cfg.FS.Remove(filename), filename)
+}
+
+// os.rename (oldname, newname)
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.rename
+// lua-5.1.5/src/loslib.c#os_rename()
+func (self *osLib) rename(ls LuaState) int {
+	fromName := ls.CheckString(1)
+	toName := ls.CheckString(2)
+	return pushResult(ls, self.cfg.FS.Rename(fromName, toName), fromName)
+}
+
+// os.tmpname ()
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.tmpname
+// lua-5.1.5/src/loslib.c#os_tmpname()
+// The file is created like mkstemp does, in the temporary directory of the operating
+// system, or in "/tmp" of the other file systems.
+func (self *osLib) tmpname(ls LuaState) int {
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("lua_%06x", rand.Intn(1<<24))
+		if _, ok := self.cfg.FS.(OSFileSystem); ok {
+			name = filepath.Join(os.TempDir(), name)
+		} else {
+			name = "/tmp/" + name
+		}
+		f, err := self.cfg.FS.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			f.Close()
+			ls.PushString(name)
+			return 1
+		} else if !errors.Is(err, fs.ErrExist) {
+			break
+		}
+	}
+	return ls.Error2("unable to generate a unique filename")
+}
+
+// os.getenv (varname)
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.getenv
+// lua-5.1.5/src/loslib.c#os_getenv()
+func osGetenv(ls LuaState) int {
+	if v, ok := os.LookupEnv(ls.CheckString(1)); ok {
+		ls.PushString(v)
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+// The time when the program starts, see os.clock.
+var startTime = time.Now()
+
+// os.clock ()
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.clock
+// lua-5.1.5/src/loslib.c#os_clock()
+// Go has no portable CPU time, the seconds since the program starts are used instead.
+func osClock(ls LuaState) int {
+	ls.PushNumber(time.Since(startTime).Seconds())
+	return 1
+}
+
+/*
+** {======================================================
+** Time/Date operations
+** { year=%Y, month=%m, day=%d, hour=%H, min=%M, sec=%S,
+**   wday=%w+1, yday=%j, isdst=? }
+** =======================================================
+ */
+
+func setField(ls LuaState, key string, value int) {
+	ls.PushInteger(int64(value))
+	ls.SetField(-2, key)
+}
+
+func setBoolField(ls LuaState, key string, value bool) {
+	ls.PushBoolean(value)
+	ls.SetField(-2, key)
+}
+
+// The integer field of the table at the top, d < 0 means the field is required.
+func getField(ls LuaState, key string, d int) int {
+	ls.GetField(-1, key)
+	res := d
+	if ls.IsNumber(-1) {
+		res = int(ls.ToInteger(-1))
+	} else if d < 0 {
+		ls.Error2("field '%s' missing in date table", key)
+	}
+	ls.Pop(1)
+	return res
+}
+
+// os.date ([format [, time]])
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.date
+// lua-5.1.5/src/loslib.c#os_date()
+func osDate(ls LuaState) int {
+	s := ls.OptString(1, "%c")
+	t := time.Now()
+	if !ls.IsNoneOrNil(2) {
+		t = time.Unix(int64(ls.CheckNumber(2)), 0)
+	}
+	if len(s) > 0 && s[0] == '!' { /* UTC? */
+		t = t.UTC()
+		s = s[1:] /* skip '!' */
+	} else {
+		t = t.Local()
+	}
+	if s == "*t" {
+		ls.CreateTable(0, 9) /* 9 = number of fields */
+		setField(ls, "sec", t.Second())
+		setField(ls, "min", t.Minute())
+		setField(ls, "hour", t.Hour())
+		setField(ls, "day", t.Day())
+		setField(ls, "month", int(t.Month()))
+		setField(ls, "year", t.Year())
+		setField(ls, "wday", int(t.Weekday())+1)
+		setField(ls, "yday", t.YearDay())
+		setBoolField(ls, "isdst", t.IsDST())
+	} else {
+		ls.PushString(strftime(t, s))
+	}
+	return 1
+}
+
+// os.time ([table])
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.time
+// lua-5.1.5/src/loslib.c#os_time()
+// Fields out of range are normalized, like mktime of C. isdst is not used, the
+// offset of the local time zone is always found by the time package.
+func osTime(ls LuaState) int {
+	if ls.IsNoneOrNil(1) { /* called without args? */
+		ls.PushInteger(time.Now().Unix()) /* get current time */
+		return 1
+	}
+	ls.CheckType(1, LUA_TTABLE)
+	ls.SetTop(1) /* make sure table is at the top */
+	sec := getField(ls, "sec", 0)
+	min := getField(ls, "min", 0)
+	hour := getField(ls, "hour", 12)
+	day := getField(ls, "day", -1)
+	month := getField(ls, "month", -1)
+	year := getField(ls, "year", -1)
+	t := time.Date(year, time.Month(month), day, hour, min, sec, 0, time.Local)
+	ls.PushInteger(t.Unix())
+	return 1
+}
+
+// os.difftime (t2, t1)
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.difftime
+// lua-5.1.5/src/loslib.c#os_difftime()
+func osDifftime(ls LuaState) int {
+	ls.PushNumber(float64(int64(ls.CheckNumber(1)) - int64(ls.OptNumber(2, 0))))
+	return 1
+}
+
+/* }====================================================== */
+
+// os.setlocale (locale [, category])
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.setlocale
+// lua-5.1.5/src/loslib.c#os_setlocale()
+// Only the "C" locale is supported.
+func osSetlocale(ls LuaState) int {
+	l := ls.OptString(1, "C")
+	switch op := ls.OptString(2, "all"); op {
+	case "all", "collate", "ctype", "monetary", "numeric", "time":
+	default:
+		return ls.ArgError(2, fmt.Sprintf("invalid option '%s'", op))
+	}
+	if l == "" || l == "C" || l == "POSIX" {
+		ls.PushString("C")
+	} else {
+		ls.PushNil()
+	}
+	return 1
+}
+
+// os.exit ([code])
+// http://www.lua.org/manual/5.1/manual.html#pdf-os.exit
+// lua-5.1.5/src/loslib.c#os_exit()
+// The process does not exit, *ExitError unwinds the stack to the host.
+func osExit(ls LuaState) int {
+	panic(&ExitError{Code: int(ls.OptInteger(1, 0))})
+}
+
+/*
+	@description
+		Format the time like strftime of C in the "C" locale. The conversions of C99
+		are supported, an unknown conversion is copied as it is.
+*/
+func strftime(t time.Time, format string) string {
+	var buf bytes.Buffer
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' || i+1 == len(format) {
+			buf.WriteByte(format[i])
+			continue
+		}
+		i++
+		switch c := format[i]; c {
+		case 'a': /* abbreviated weekday name */
+			buf.WriteString(t.Weekday().String()[:3])
+		case 'A': /* full weekday name */
+			buf.WriteString(t.Weekday().String())
+		case 'b', 'h': /* abbreviated month name */
+			buf.WriteString(t.Month().String()[:3])
+		case 'B': /* full month name */
+			buf.WriteString(t.Month().String())
+		case 'c': /* date and time representation */
+			buf.WriteString(strftime(t, "%a %b %e %H:%M:%S %Y"))
+		case 'C': /* year divided by 100 */
+			fmt.Fprintf(&buf, "%02d", t.Year()/100)
+		case 'd': /* day of the month (01-31) */
+			fmt.Fprintf(&buf, "%02d", t.Day())
+		case 'D', 'x': /* date representation */
+			buf.WriteString(strftime(t, "%m/%d/%y"))
+		case 'e': /* day of the month, space-padded */
+			fmt.Fprintf(&buf, "%2d", t.Day())
+		case 'F': /* ISO 8601 date */
+			buf.WriteString(strftime(t, "%Y-%m-%d"))
+		case 'g': /* last 2 digits of the ISO week-based year */
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&buf, "%02d", year%100)
+		case 'G': /* ISO week-based year */
+			year, _ := t.ISOWeek()
+			fmt.Fprintf(&buf, "%d", year)
+		case 'H': /* hour in 24h format (00-23) */
+			fmt.Fprintf(&buf, "%02d", t.Hour())
+		case 'I': /* hour in 12h format (01-12) */
+			fmt.Fprintf(&buf, "%02d", (t.Hour()+11)%12+1)
+		case 'j': /* day of the year (001-366) */
+			fmt.Fprintf(&buf, "%03d", t.YearDay())
+		case 'm': /* month (01-12) */
+			fmt.Fprintf(&buf, "%02d", int(t.Month()))
+		case 'M': /* minute (00-59) */
+			fmt.Fprintf(&buf, "%02d", t.Minute())
+		case 'n':
+			buf.WriteByte('\n')
+		case 'p': /* AM or PM */
+			if t.Hour() < 12 {
+				buf.WriteString("AM")
+			} else {
+				buf.WriteString("PM")
+			}
+		case 'r': /* 12-hour clock time */
+			buf.WriteString(strftime(t, "%I:%M:%S %p"))
+		case 'R':
+			buf.WriteString(strftime(t, "%H:%M"))
+		case 'S': /* second (00-60) */
+			fmt.Fprintf(&buf, "%02d", t.Second())
+		case 't':
+			buf.WriteByte('\t')
+		case 'T', 'X': /* time representation */
+			buf.WriteString(strftime(t, "%H:%M:%S"))
+		case 'u': /* ISO 8601 weekday, Monday is 1 (1-7) */
+			fmt.Fprintf(&buf, "%d", (int(t.Weekday())+6)%7+1)
+		case 'U': /* week number with the first Sunday as the first day of week one (00-53) */
+			fmt.Fprintf(&buf, "%02d", (t.YearDay()+6-int(t.Weekday()))/7)
+		case 'V': /* ISO 8601 week number (01-53) */
+			_, week := t.ISOWeek()
+			fmt.Fprintf(&buf, "%02d", week)
+		case 'w': /* weekday, Sunday is 0 (0-6) */
+			fmt.Fprintf(&buf, "%d", int(t.Weekday()))
+		case 'W': /* week number with the first Monday as the first day of week one (00-53) */
+			fmt.Fprintf(&buf, "%02d", (t.YearDay()+6-(int(t.Weekday())+6)%7)/7)
+		case 'y': /* year, last two digits (00-99) */
+			fmt.Fprintf(&buf, "%02d", t.Year()%100)
+		case 'Y': /* year */
+			fmt.Fprintf(&buf, "%d", t.Year())
+		case 'z': /* offset from UTC, like +0800 */
+			buf.WriteString(t.Format("-0700"))
+		case 'Z': /* time zone name */
+			buf.WriteString(t.Format("MST"))
+		case '%':
+			buf.WriteByte('%')
+		default:
+			buf.WriteByte('%')
+			buf.WriteByte(c)
+		}
+	}
+	return buf.String()
+}
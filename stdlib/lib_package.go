@@ -0,0 +1,465 @@
+package stdlib
+
+import (
+	"bytes"
+	. "goluar/api"
+	. "goluar/common"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	LUA_PATH          = "LUA_PATH"  // the environment variable of package.path
+	LUA_CPATH         = "LUA_CPATH" // the environment variable of package.cpath
+	LUA_PATH_DEFAULT  = "./?.lua;" + LUA_LDIR + "?.lua;" + LUA_LDIR + "?/init.lua;" + LUA_CDIR + "?.lua;" + LUA_CDIR + "?/init.lua"
+	LUA_CPATH_DEFAULT = "./?.so;" + LUA_CDIR + "?.so;" + LUA_CDIR + "loadall.so"
+	LUA_ROOT          = "/usr/local/"
+	LUA_LDIR          = LUA_ROOT + "share/lua/5.1/"
+	LUA_CDIR          = LUA_ROOT + "lib/lua/5.1/"
+	LUA_DIRSEP        = "/"
+	LUA_PATHSEP       = ";"
+	LUA_PATH_MARK     = "?"
+	LUA_EXECDIR       = "!"
+	LUA_IGMARK        = "-"
+	AUXMARK           = "\x01"
+	DLMSG             = "dynamic libraries not enabled; check your Lua installation"
+)
+
+/*
+	The files and the paths of the package library. A nil FS can not find any file,
+	so the modules can only be loaded from package.preload and package.loaders.
+	The path is the default path if it is empty, and ";;" in it is replaced by the
+	default path, like the LUA_PATH environment variable.
+*/
+type PackageConfig struct {
+	FS    FileSystem
+	Path  string // package.path
+	CPath string // package.cpath, the files are found but can not be loaded
+}
+
+/*
+	@description
+		Make the opener of a package library which finds the modules in the files of the config,
+		like NewIOLib, it must be required before OpenLibs to replace the package library of OpenLibs.
+*/
+func NewPackageLib(cfg PackageConfig) GoFunction {
+	if cfg.FS == nil {
+		cfg.FS = noFileSystem{}
+	}
+	lib := &packageLib{cfg}
+	return lib.open
+}
+
+/*
+	lua-5.1.5/src/loadlib.c#luaopen_package()
+	The package library of OpenLibs, which finds the modules in the files of the operating
+	system with the paths of the LUA_PATH and LUA_CPATH environment variables.
+*/
+func OpenPackageLib(ls LuaState) int {
+	return NewPackageLib(PackageConfig{
+		FS:    OSFileSystem{},
+		Path:  os.Getenv(LUA_PATH),
+		CPath: os.Getenv(LUA_CPATH),
+	})(ls)
+}
+
+type packageLib struct {
+	cfg PackageConfig
+}
+
+// lua-5.1.5/src/loadlib.c#luaopen_package()
+func (self *packageLib) open(ls LuaState) int {
+	/* create `package' table */
+	ls.NewLib(FuncReg{
+		"loadlib": pkgLoadlib,
+		"seeall":  pkgSeeall,
+	})
+	ls.PushValue(-1)
+	ls.Replace(LUA_ENVIRONINDEX) /* the functions below see the package table as their environment */
+	/* create `loaders' table */
+	loaders := []GoFunction{loaderPreload, self.loaderLua, self.loaderC, self.loaderCroot}
+	ls.CreateTable(len(loaders), 0)
+	for i, loader := range loaders {
+		ls.PushGoFunction(loader)
+		ls.RawSetI(-2, int64(i+1))
+	}
+	ls.SetField(-2, "loaders")
+	setPath(ls, "path", self.cfg.Path, LUA_PATH_DEFAULT)
+	setPath(ls, "cpath", self.cfg.CPath, LUA_CPATH_DEFAULT)
+	ls.PushString(LUA_DIRSEP + "\n" + LUA_PATHSEP + "\n" + LUA_PATH_MARK + "\n" + LUA_EXECDIR + "\n" + LUA_IGMARK)
+	ls.SetField(-2, "config")
+	/* set field `loaded' */
+	ls.GetSubTable(LUA_REGISTRYINDEX, "_LOADED")
+	ls.SetField(-2, "loaded")
+	/* set field `preload' */
+	ls.NewTable()
+	ls.SetField(-2, "preload")
+	ls.PushGlobalTable()
+	ls.SetFuncs(FuncReg{ /* open lib into global table */
+		"module":  pkgModule,
+		"require": pkgRequire,
+	}, 0)
+	ls.Pop(1)
+	return 1 /* return 'package' table */
+}
+
+// lua-5.1.5/src/loadlib.c#setpath()
+func setPath(ls LuaState, fieldName, path, def string) {
+	if path == "" { /* no environment variable? */
+		path = def /* use default */
+	} else {
+		/* replace ";;" by ";AUXMARK;" and then AUXMARK by default path */
+		path = strings.Replace(path, LUA_PATHSEP+LUA_PATHSEP, LUA_PATHSEP+AUXMARK+LUA_PATHSEP, -1)
+		path = strings.Replace(path, AUXMARK, def, -1)
+	}
+	ls.PushString(path)
+	ls.SetField(-2, fieldName)
+}
+
+// package.loadlib (libname, funcname)
+// http://www.lua.org/manual/5.1/manual.html#pdf-package.loadlib
+// lua-5.1.5/src/loadlib.c#ll_loadlib()
+// Dynamic libraries can not be loaded by go, it fails like a lua without them.
+func pkgLoadlib(ls LuaState) int {
+	ls.CheckString(1)
+	ls.CheckString(2)
+	ls.PushNil()
+	ls.PushString(DLMSG)
+	ls.PushString("absent")
+	return 3 /* return nil, error message, and where */
+}
+
+// package.seeall (module)
+// http://www.lua.org/manual/5.1/manual.html#pdf-package.seeall
+// lua-5.1.5/src/loadlib.c#ll_seeall()
+func pkgSeeall(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	if !ls.GetMetatable(1) {
+		ls.CreateTable(0, 1) /* create new metatable */
+		ls.PushValue(-1)
+		ls.SetMetatable(1)
+	}
+	ls.PushGlobalTable()
+	ls.SetField(-2, "__index") /* mt.__index = _G */
+	return 0
+}
+
+// Whether the file exists and can be read.
+// lua-5.1.5/src/loadlib.c#readable()
+func (self *packageLib) readable(filename string) bool {
+	f, err := self.cfg.FS.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		return false /* open failed */
+	}
+	f.Close()
+	return true
+}
+
+/*
+	@description
+		Find the file of the module by the templates of package.path or package.cpath,
+		the dots of the name are replaced by the directory separator.
+		lua-5.1.5/src/loadlib.c#findfile()
+	@return
+		filename	string	"the readable file"
+		ok			bool	"false if it is not found, the message of the files tried is pushed"
+*/
+func (self *packageLib) findFile(ls LuaState, name, pname string) (filename string, ok bool) {
+	name = strings.Replace(name, ".", LUA_DIRSEP, -1)
+	ls.GetField(LUA_ENVIRONINDEX, pname)
+	if !ls.IsString(-1) {
+		ls.Error2("'package.%s' must be a string", pname)
+	}
+	path := ls.ToString(-1)
+	ls.Pop(1)
+	var msg strings.Builder /* error accumulator */
+	for _, template := range strings.Split(path, LUA_PATHSEP) {
+		if template == "" {
+			continue /* skip separators */
+		}
+		filename = strings.Replace(template, LUA_PATH_MARK, name, -1)
+		if self.readable(filename) { /* does file exist and is readable? */
+			return filename, true /* return that file name */
+		}
+		msg.WriteString("\n\tno file '" + filename + "'")
+	}
+	ls.PushString(msg.String())
+	return "", false /* not found */
+}
+
+// lua-5.1.5/src/loadlib.c#loaderror()
+func loadError(ls LuaState, filename string) int {
+	return ls.Error2("error loading module '%s' from file '%s':\n\t%s",
+		ls.ToString(1), filename, ls.ToString(-1))
+}
+
+/*
+	@description
+		Load the file of the file system as a chunk, like LoadFile. The first line is
+		skipped if it starts with '#'.
+		lua-5.1.5/src/lauxlib.c#luaL_loadfile()
+*/
+func (self *packageLib) loadFile(ls LuaState, filename string) int {
+	f, err := self.cfg.FS.OpenFile(filename, os.O_RDONLY, 0)
+	if err != nil {
+		ls.PushString("cannot open " + filename)
+		return LUA_ERRFILE
+	}
+	data, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		ls.PushString("cannot read " + filename)
+		return LUA_ERRFILE
+	}
+	if len(data) > 0 && data[0] == '#' {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i:] // keep the newline so that line numbers stay the same
+		} else {
+			data = nil
+		}
+	}
+	return ls.Load(data, "@"+filename, "bt")
+}
+
+// lua-5.1.5/src/loadlib.c#loader_preload()
+func loaderPreload(ls LuaState) int {
+	name := ls.CheckString(1)
+	ls.GetField(LUA_ENVIRONINDEX, "preload")
+	if !ls.IsTable(-1) {
+		ls.Error2("'package.preload' must be a table")
+	}
+	ls.GetField(-1, name)
+	if ls.IsNil(-1) { /* not found? */
+		ls.PushFString("\n\tno field package.preload['%s']", name)
+	}
+	return 1
+}
+
+// lua-5.1.5/src/loadlib.c#loader_Lua()
+func (self *packageLib) loaderLua(ls LuaState) int {
+	name := ls.CheckString(1)
+	filename, ok := self.findFile(ls, name, "path")
+	if !ok {
+		return 1 /* library not found in this path */
+	}
+	if self.loadFile(ls, filename) != LUA_OK {
+		loadError(ls, filename)
+	}
+	return 1 /* library loaded successfully */
+}
+
+// lua-5.1.5/src/loadlib.c#loader_C()
+func (self *packageLib) loaderC(ls LuaState) int {
+	name := ls.CheckString(1)
+	filename, ok := self.findFile(ls, name, "cpath")
+	if !ok {
+		return 1 /* library not found in this path */
+	}
+	ls.PushString(DLMSG)
+	return loadError(ls, filename)
+}
+
+// lua-5.1.5/src/loadlib.c#loader_Croot()
+func (self *packageLib) loaderCroot(ls LuaState) int {
+	name := ls.CheckString(1)
+	p := strings.IndexByte(name, '.')
+	if p < 0 {
+		return 0 /* is root */
+	}
+	filename, ok := self.findFile(ls, name[:p], "cpath")
+	if !ok {
+		return 1 /* root not found */
+	}
+	ls.PushString(DLMSG)
+	return loadError(ls, filename)
+}
+
+/*
+	The value of package.loaded[name] while the module is loaded, to find the loops of require.
+	It stays there if the module raises an error.
+*/
+type loading struct {
+	seq    uint64 // the order of the requires, the modules being loaded are nested in this order
+	active bool   // whether the module is being loaded
+}
+
+var loadingSeq uint64
+
+// require (modname)
+// http://www.lua.org/manual/5.1/manual.html#pdf-require
+// lua-5.1.5/src/loadlib.c#ll_require()
+func pkgRequire(ls LuaState) int {
+	name := ls.CheckString(1)
+	ls.SetTop(1) /* _LOADED table will be at index 2 */
+	ls.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	ls.GetField(2, name)
+	if ls.ToBoolean(-1) { /* is it there? */
+		if l, ok := ls.ToUserData(-1).(*loading); ok { /* check loops */
+			if l.active {
+				ls.Error2("loop loading module '%s': %s", name, loadingChain(ls, name))
+			}
+			ls.Error2("previous error loading module '%s'", name)
+		}
+		return 1 /* package is already loaded */
+	}
+	/* else must load it; iterate over available loaders */
+	ls.GetField(LUA_ENVIRONINDEX, "loaders")
+	if !ls.IsTable(-1) {
+		ls.Error2("'package.loaders' must be a table")
+	}
+	ls.PushString("") /* error message accumulator */
+	for i := int64(1); ; i++ {
+		ls.RawGetI(-2, i) /* get a loader */
+		if ls.IsNil(-1) {
+			ls.Error2("module '%s' not found:%s", name, ls.ToString(-2))
+		}
+		ls.PushString(name)
+		ls.Call(1, 1)          /* call it */
+		if ls.IsFunction(-1) { /* did it find module? */
+			break /* module loaded successfully */
+		} else if ls.IsString(-1) { /* loader returned error message? */
+			ls.Concat(2) /* accumulate it */
+		} else {
+			ls.Pop(1)
+		}
+	}
+	sentinel := &loading{seq: atomic.AddUint64(&loadingSeq, 1), active: true}
+	defer func() { sentinel.active = false }() /* also if the module raises an error */
+	ls.NewUserData(sentinel)
+	ls.SetField(2, name) /* _LOADED[name] = sentinel */
+	ls.PushString(name)  /* pass name as argument to module */
+	ls.Call(1, 1)        /* run loaded module */
+	if !ls.IsNil(-1) {   /* non-nil return? */
+		ls.SetField(2, name) /* _LOADED[name] = returned value */
+	}
+	ls.GetField(2, name)
+	if ls.ToUserData(-1) == interface{}(sentinel) { /* module did not set a value? */
+		ls.PushBoolean(true) /* use true as result */
+		ls.PushValue(-1)     /* extra copy to be returned */
+		ls.SetField(2, name) /* _LOADED[name] = true */
+	}
+	return 1
+}
+
+/*
+	@description
+		Describe the loop of require from the modules being loaded in the _LOADED table at index 2:
+			a -> b -> a
+*/
+func loadingChain(ls LuaState, name string) string {
+	var chain []string
+	var seqs []uint64
+	ls.PushNil()
+	for ls.Next(2) {
+		if l, ok := ls.ToUserData(-1).(*loading); ok && l.active && ls.Type(-2) == LUA_TSTRING {
+			i := sort.Search(len(seqs), func(i int) bool { return seqs[i] > l.seq })
+			seqs = append(seqs[:i], append([]uint64{l.seq}, seqs[i:]...)...)
+			chain = append(chain[:i], append([]string{ls.ToString(-2)}, chain[i:]...)...)
+		}
+		ls.Pop(1)
+	}
+	for i, mod := range chain {
+		if mod == name {
+			chain = chain[i:] /* the modules before it are not in the loop */
+			break
+		}
+	}
+	return strings.Join(append(chain, name), " -> ")
+}
+
+// module (name [, ···])
+// http://www.lua.org/manual/5.1/manual.html#pdf-module
+// lua-5.1.5/src/loadlib.c#ll_module()
+func pkgModule(ls LuaState) int {
+	modName := ls.CheckString(1)
+	loaded := ls.GetTop() + 1 /* index of _LOADED table */
+	ls.GetField(LUA_REGISTRYINDEX, "_LOADED")
+	ls.GetField(loaded, modName) /* get _LOADED[modname] */
+	if !ls.IsTable(-1) {         /* not found? */
+		ls.Pop(1) /* remove previous result */
+		/* try global variable (and create one if it does not exist) */
+		ls.PushGlobalTable()
+		if !findTable(ls, -1, modName) {
+			return ls.Error2("name conflict for module '%s'", modName)
+		}
+		ls.Remove(-2) /* remove global table */
+		ls.PushValue(-1)
+		ls.SetField(loaded, modName) /* _LOADED[modname] = new table */
+	}
+	/* check whether table already has a _NAME field */
+	ls.GetField(-1, "_NAME")
+	if !ls.IsNil(-1) { /* is table an initialized module? */
+		ls.Pop(1)
+	} else { /* no; initialize it */
+		ls.Pop(1)
+		modInit(ls, modName)
+	}
+	ls.PushValue(-1)
+	setFenv(ls)
+	doOptions(ls, loaded-1)
+	return 0
+}
+
+// Set the module on the top as the environment of the function calling module.
+// lua-5.1.5/src/loadlib.c#setfenv()
+func setFenv(ls LuaState) {
+	if !ls.GetStackFunction(1) || ls.IsGoFunction(-1) { /* get calling function */
+		ls.Error2("'module' not called from a Lua function")
+	}
+	ls.PushValue(-2)
+	ls.SetFenv(-2)
+	ls.Pop(2)
+}
+
+// Call the options of module with the module.
+// lua-5.1.5/src/loadlib.c#dooptions()
+func doOptions(ls LuaState, n int) {
+	for i := 2; i <= n; i++ {
+		ls.PushValue(i)  /* get option (a function) */
+		ls.PushValue(-2) /* module */
+		ls.Call(1, 0)
+	}
+}
+
+// lua-5.1.5/src/loadlib.c#modinit()
+func modInit(ls LuaState, modName string) {
+	ls.PushValue(-1)
+	ls.SetField(-2, "_M") /* module._M = module */
+	ls.PushString(modName)
+	ls.SetField(-2, "_NAME")
+	dot := strings.LastIndexByte(modName, '.') + 1 /* look for last dot in module name */
+	/* set _PACKAGE as package name (full module name minus last part) */
+	ls.PushString(modName[:dot])
+	ls.SetField(-2, "_PACKAGE")
+}
+
+/*
+	@description
+		Push the table of the dotted name, such as "a.b.c", in the table at idx.
+		The missing tables are created.
+		lua-5.1.5/src/lauxlib.c#luaL_findtable()
+	@return
+		ok	bool	"false if a field of the name has a non-table value, nothing is pushed"
+*/
+func findTable(ls LuaState, idx int, fname string) bool {
+	ls.PushValue(idx)
+	for _, part := range strings.Split(fname, ".") {
+		ls.PushString(part)
+		ls.RawGet(-2)
+		if ls.IsNil(-1) { /* no such field? */
+			ls.Pop(1)     /* remove this nil */
+			ls.NewTable() /* new table for field */
+			ls.PushString(part)
+			ls.PushValue(-2)
+			ls.SetTable(-4) /* set new table into field */
+		} else if !ls.IsTable(-1) { /* field has a non-table value? */
+			ls.Pop(2) /* remove table and value */
+			return false
+		}
+		ls.Remove(-2) /* remove previous table */
+	}
+	return true
+}
@@ -0,0 +1,535 @@
+package stdlib
+
+import (
+	"bytes"
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"strings"
+)
+
+var strLib = map[string]GoFunction{
+	"byte":    strByte,
+	"char":    strChar,
+	"dump":    strDump,
+	"find":    strFind,
+	"format":  strFormat,
+	"gfind":   strGmatch, /* compatibility with lua 5.0 */
+	"gmatch":  strGmatch,
+	"gsub":    strGsub,
+	"len":     strLen,
+	"lower":   strLower,
+	"match":   strMatch,
+	"rep":     strRep,
+	"reverse": strReverse,
+	"sub":     strSub,
+	"upper":   strUpper,
+}
+
+// lua-5.1.5/src/lstrlib.c#luaopen_string()
+func OpenStringLib(ls LuaState) int {
+	ls.NewLib(strLib)
+	createMetatable(ls)
+	return 1
+}
+
+/*
+	All strings share a metatable whose __index is the string table,
+	so that s:upper() calls string.upper(s).
+*/
+func createMetatable(ls LuaState) {
+	ls.CreateTable(0, 1) /* create metatable for strings */
+	ls.PushString("")    /* dummy string */
+	ls.PushValue(-2)
+	ls.SetMetatable(-2)        /* set string metatable */
+	ls.Pop(1)                  /* pop dummy string */
+	ls.PushValue(-2)           /* string library... */
+	ls.SetField(-2, "__index") /* ...is the __index metamethod */
+	ls.Pop(1)                  /* pop metatable */
+}
+
+/*
+	Translate a relative string position: negative means back from end.
+*/
+func posRelat(pos int64, l int) int64 {
+	if pos < 0 {
+		pos += int64(l) + 1
+	}
+	if pos >= 0 {
+		return pos
+	}
+	return 0
+}
+
+// string.len (s)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.len
+// lua-5.1.5/src/lstrlib.c#str_len()
+func strLen(ls LuaState) int {
+	s := ls.CheckString(1)
+	ls.PushInteger(int64(len(s)))
+	return 1
+}
+
+// string.sub (s, i [, j])
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.sub
+// lua-5.1.5/src/lstrlib.c#str_sub()
+func strSub(ls LuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	start := posRelat(ls.CheckInteger(2), l)
+	end := posRelat(ls.OptInteger(3, -1), l)
+	if start < 1 {
+		start = 1
+	}
+	if end > int64(l) {
+		end = int64(l)
+	}
+	if start <= end {
+		ls.PushString(s[start-1 : end])
+	} else {
+		ls.PushString("")
+	}
+	return 1
+}
+
+// string.reverse (s)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.reverse
+// lua-5.1.5/src/lstrlib.c#str_reverse()
+func strReverse(ls LuaState) int {
+	s := ls.CheckString(1)
+	b := make([]byte, len(s))
+	for i := range b {
+		b[i] = s[len(s)-1-i]
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.lower (s)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.lower
+// lua-5.1.5/src/lstrlib.c#str_lower()
+func strLower(ls LuaState) int {
+	s := ls.CheckString(1)
+	b := []byte(s)
+	for i, c := range b {
+		b[i] = toLower(c)
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.upper (s)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.upper
+// lua-5.1.5/src/lstrlib.c#str_upper()
+func strUpper(ls LuaState) int {
+	s := ls.CheckString(1)
+	b := []byte(s)
+	for i, c := range b {
+		b[i] = toUpper(c)
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.rep (s, n)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.rep
+// lua-5.1.5/src/lstrlib.c#str_rep()
+func strRep(ls LuaState) int {
+	s := ls.CheckString(1)
+	n := ls.CheckInteger(2)
+	if n <= 0 || s == "" {
+		ls.PushString("")
+	} else if n > MAX_STRING_SIZE/int64(len(s)) {
+		return ls.Error2("resulting string too large")
+	} else {
+		ls.PushString(strings.Repeat(s, int(n)))
+	}
+	return 1
+}
+
+// string.byte (s [, i [, j]])
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.byte
+// lua-5.1.5/src/lstrlib.c#str_byte()
+func strByte(ls LuaState) int {
+	s := ls.CheckString(1)
+	l := len(s)
+	posi := posRelat(ls.OptInteger(2, 1), l)
+	pose := posRelat(ls.OptInteger(3, posi), l)
+	if posi <= 0 {
+		posi = 1
+	}
+	if pose > int64(l) {
+		pose = int64(l)
+	}
+	if posi > pose {
+		return 0 /* empty interval; return no values */
+	}
+	n := int(pose - posi + 1)
+	ls.CheckStack2(n, "string slice too long")
+	for i := 0; i < n; i++ {
+		ls.PushInteger(int64(s[int(posi)+i-1]))
+	}
+	return n
+}
+
+// string.char (···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.char
+// lua-5.1.5/src/lstrlib.c#str_char()
+func strChar(ls LuaState) int {
+	n := ls.GetTop() /* number of arguments */
+	b := make([]byte, n)
+	for i := 1; i <= n; i++ {
+		c := ls.CheckInteger(i)
+		ls.ArgCheck(c >= 0 && c <= 0xFF, i, "invalid value")
+		b[i-1] = byte(c)
+	}
+	ls.PushString(string(b))
+	return 1
+}
+
+// string.dump (function)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.dump
+// lua-5.1.5/src/lstrlib.c#str_dump()
+func strDump(ls LuaState) int {
+	var buf bytes.Buffer
+	ls.CheckType(1, LUA_TFUNCTION)
+	ls.SetTop(1)
+	if ls.Dump(&buf) != 0 {
+		return ls.Error2("unable to dump given function")
+	}
+	ls.PushString(buf.String())
+	return 1
+}
+
+/*
+** {======================================================
+** PATTERN MATCHING
+** =======================================================
+ */
+
+// string.find (s, pattern [, init [, plain]])
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.find
+func strFind(ls LuaState) int {
+	return strFindAux(ls, true)
+}
+
+// string.match (s, pattern [, init])
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.match
+func strMatch(ls LuaState) int {
+	return strFindAux(ls, false)
+}
+
+// lua-5.1.5/src/lstrlib.c#str_find_aux()
+func strFindAux(ls LuaState, find bool) int {
+	s := ls.CheckString(1)
+	p := ls.CheckString(2)
+	init := posRelat(ls.OptInteger(3, 1), len(s)) - 1
+	if init < 0 {
+		init = 0
+	} else if init > int64(len(s)) {
+		init = int64(len(s))
+	}
+	if find && (ls.ToBoolean(4) || /* explicit request? */
+		!strings.ContainsAny(p, SPECIALS)) { /* or no special characters? */
+		/* do a plain search */
+		if i := strings.Index(s[init:], p); i >= 0 {
+			ls.PushInteger(init + int64(i) + 1)
+			ls.PushInteger(init + int64(i+len(p)))
+			return 2
+		}
+	} else {
+		anchor := strings.HasPrefix(p, "^")
+		if anchor {
+			p = p[1:]
+		}
+		ms := newMatchState(ls, s, p)
+		for s1 := int(init); ; s1++ {
+			ms.level = 0
+			if res := ms.match(s1, 0); res != -1 {
+				if find {
+					ls.PushInteger(int64(s1 + 1)) /* start */
+					ls.PushInteger(int64(res))    /* end */
+					return ms.pushCaptures(-1, 0) + 2
+				}
+				return ms.pushCaptures(s1, res)
+			}
+			if s1 >= len(s) || anchor {
+				break
+			}
+		}
+	}
+	ls.PushNil() /* not found */
+	return 1
+}
+
+// string.gmatch (s, pattern)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.gmatch
+// lua-5.1.5/src/lstrlib.c#gmatch()
+func strGmatch(ls LuaState) int {
+	ls.CheckString(1)
+	ls.CheckString(2)
+	ls.SetTop(2)
+	ls.PushInteger(0)
+	ls.PushGoClosure(gmatchAux, 3)
+	return 1
+}
+
+func gmatchAux(ls LuaState) int {
+	s := ls.ToString(LuaUpvalueIndex(1))
+	p := ls.ToString(LuaUpvalueIndex(2))
+	ms := newMatchState(ls, s, p)
+	for src := int(ls.ToInteger(LuaUpvalueIndex(3))); src <= len(s); src++ {
+		ms.level = 0
+		if e := ms.match(src, 0); e != -1 {
+			newStart := e
+			if e == src {
+				newStart++ /* empty match? go at least one position */
+			}
+			ls.PushInteger(int64(newStart))
+			ls.Replace(LuaUpvalueIndex(3))
+			return ms.pushCaptures(src, e)
+		}
+	}
+	return 0 /* not found */
+}
+
+// Add the replacement string with %0-%9 and %% to the buffer.
+func addS(ms *matchState, b *bytes.Buffer, s, e int) {
+	news := ms.ls.ToString(3)
+	for i := 0; i < len(news); i++ {
+		if news[i] != L_ESC {
+			b.WriteByte(news[i])
+			continue
+		}
+		i++ /* skip ESC */
+		if i >= len(news) {
+			break
+		}
+		if !isDigit(news[i]) {
+			b.WriteByte(news[i])
+		} else if news[i] == '0' {
+			b.WriteString(ms.src[s:e])
+		} else {
+			ms.pushOneCapture(int(news[i]-'1'), s, e)
+			b.WriteString(ms.ls.ToString(-1)) /* add capture to accumulated result */
+			ms.ls.Pop(1)
+		}
+	}
+}
+
+func addValue(ms *matchState, b *bytes.Buffer, s, e int) {
+	ls := ms.ls
+	switch ls.Type(3) {
+	case LUA_TNUMBER, LUA_TSTRING:
+		addS(ms, b, s, e)
+		return
+	case LUA_TFUNCTION:
+		ls.PushValue(3)
+		n := ms.pushCaptures(s, e)
+		ls.Call(n, 1)
+	case LUA_TTABLE:
+		ms.pushOneCapture(0, s, e)
+		ls.GetTable(3)
+	}
+	if !ls.ToBoolean(-1) { /* nil or false? */
+		ls.Pop(1)
+		ls.PushString(ms.src[s:e]) /* keep original text */
+	} else if !ls.IsString(-1) {
+		ls.Error2("invalid replacement value (a %s)", ls.TypeName2(-1))
+	}
+	b.WriteString(ls.ToString(-1)) /* add result to accumulator */
+	ls.Pop(1)
+}
+
+// string.gsub (s, pattern, repl [, n])
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.gsub
+// lua-5.1.5/src/lstrlib.c#str_gsub()
+func strGsub(ls LuaState) int {
+	src := ls.CheckString(1)
+	p := ls.CheckString(2)
+	tr := ls.Type(3)
+	maxS := ls.OptInteger(4, int64(len(src)+1))
+	anchor := strings.HasPrefix(p, "^")
+	if anchor {
+		p = p[1:]
+	}
+	ls.ArgCheck(tr == LUA_TNUMBER || tr == LUA_TSTRING ||
+		tr == LUA_TFUNCTION || tr == LUA_TTABLE, 3,
+		"string/function/table expected")
+
+	var b bytes.Buffer
+	ms := newMatchState(ls, src, p)
+	s, n := 0, int64(0)
+	for n < maxS {
+		ms.level = 0
+		e := ms.match(s, 0)
+		if e != -1 {
+			n++
+			addValue(ms, &b, s, e)
+		}
+		if e != -1 && e > s { /* non empty match? */
+			s = e /* skip it */
+		} else if s < len(src) {
+			b.WriteByte(src[s])
+			s++
+		} else {
+			break
+		}
+		if anchor {
+			break
+		}
+	}
+	b.WriteString(src[s:])
+	ls.PushString(b.String())
+	ls.PushInteger(n) /* number of substitutions */
+	return 2
+}
+
+/* }====================================================== */
+
+const (
+	FLAGS           = "-+ #0"
+	MAX_STRING_SIZE = 1 << 31 // the maximum size of a string made by string.rep
+)
+
+// Add the string quoted to be read back by lua.
+func addQuoted(ls LuaState, b *bytes.Buffer, arg int) {
+	s := ls.CheckString(arg)
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', '\n':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\r':
+			b.WriteString("\\r")
+		case 0:
+			b.WriteString("\\000")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+}
+
+/*
+	Scan the flags, width and precision of a format item after the '%'.
+	@return
+		form	string	"the format item with the leading '%' and without the conversion"
+		next	int		"the index of the conversion"
+*/
+func scanFormat(ls LuaState, strfrmt string, i int) (form string, next int) {
+	p := i
+	for p < len(strfrmt) && strings.IndexByte(FLAGS, strfrmt[p]) >= 0 {
+		p++ /* skip flags */
+	}
+	if p-i >= len(FLAGS)+1 {
+		ls.Error2("invalid format (repeated flags)")
+	}
+	digit := func() bool { return p < len(strfrmt) && isDigit(strfrmt[p]) }
+	if digit() {
+		p++ /* skip width */
+	}
+	if digit() {
+		p++ /* (2 digits at most) */
+	}
+	if p < len(strfrmt) && strfrmt[p] == '.' {
+		p++
+		if digit() {
+			p++ /* skip precision */
+		}
+		if digit() {
+			p++ /* (2 digits at most) */
+		}
+	}
+	if digit() {
+		ls.Error2("invalid format (width or precision too long)")
+	}
+	return "%" + strfrmt[i:p], p
+}
+
+// string.format (formatstring, ···)
+// http://www.lua.org/manual/5.1/manual.html#pdf-string.format
+// lua-5.1.5/src/lstrlib.c#str_format()
+/*
+	The items are formatted by the fmt package in the way of the C printf.
+*/
+func strFormat(ls LuaState) int {
+	top := ls.GetTop()
+	arg := 1
+	strfrmt := ls.CheckString(arg)
+	var b bytes.Buffer
+	for i := 0; i < len(strfrmt); {
+		if strfrmt[i] != L_ESC {
+			b.WriteByte(strfrmt[i])
+			i++
+			continue
+		}
+		i++
+		if i < len(strfrmt) && strfrmt[i] == L_ESC {
+			b.WriteByte(L_ESC) /* %% */
+			i++
+			continue
+		}
+		/* format item */
+		if arg++; arg > top {
+			ls.ArgError(arg, "no value")
+		}
+		var form string
+		form, i = scanFormat(ls, strfrmt, i)
+		var conv byte
+		if i < len(strfrmt) {
+			conv = strfrmt[i]
+			i++
+		}
+		switch conv {
+		case 'c':
+			c := byte(int64(ls.CheckNumber(arg)))
+			b.WriteString(fmt.Sprintf(form+"s", string([]byte{c})))
+		case 'd', 'i':
+			n := int64(ls.CheckNumber(arg))
+			b.WriteString(fmt.Sprintf(form+"d", n))
+		case 'o', 'u', 'x', 'X':
+			n := uint64(int64(ls.CheckNumber(arg)))
+			if conv == 'u' {
+				conv = 'd'
+			}
+			b.WriteString(fmt.Sprintf(form+string(conv), n))
+		case 'e', 'E', 'f':
+			b.WriteString(formatFloat(form, conv, ls.CheckNumber(arg)))
+		case 'g', 'G':
+			if !strings.Contains(form, ".") {
+				form += ".6" /* the default precision of C */
+			}
+			b.WriteString(formatFloat(form, conv, ls.CheckNumber(arg)))
+		case 'q':
+			addQuoted(ls, &b, arg)
+		case 's':
+			s := ls.CheckString(arg)
+			if !strings.Contains(form, ".") && len(s) >= 100 {
+				/* no precision and string is too long to be formatted;
+				   keep original string */
+				b.WriteString(s)
+			} else {
+				b.WriteString(fmt.Sprintf(form+"s", s))
+			}
+		default: /* also treat cases 'pnLlh' */
+			return ls.Error2("invalid option '%%%c' to 'format'", conv)
+		}
+	}
+	ls.PushString(b.String())
+	return 1
+}
+
+// inf and nan are written as C does, the fmt package writes +Inf and NaN.
+func formatFloat(form string, conv byte, f float64) string {
+	s := fmt.Sprintf(form+string(conv), f)
+	if strings.ContainsAny(s, "IN") {
+		r := strings.NewReplacer("+Inf", "inf", "-Inf", "-inf", "Inf", "inf", "NaN", "nan")
+		s = r.Replace(s)
+		if conv == 'E' || conv == 'G' {
+			s = strings.ToUpper(s)
+		}
+	}
+	return s
+}
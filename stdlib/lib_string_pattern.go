@@ -0,0 +1,420 @@
+package stdlib
+
+import (
+	. "goluar/api"
+)
+
+/*
+	Lua patterns, a port of the matcher of lua-5.1.5/src/lstrlib.c.
+	Positions in the subject and the pattern are byte offsets, -1 is used for
+	a failed match where the C code returns NULL.
+*/
+
+const (
+	LUA_MAXCAPTURES = 32
+	CAP_UNFINISHED  = -1
+	CAP_POSITION    = -2
+	L_ESC           = '%'
+	SPECIALS        = "^$*+?.([%-"
+	MAXCCALLS       = 200 // the maximum depth of recursive calls of match
+)
+
+type capture struct {
+	init int // the start of the capture in the subject
+	len  int // the length, or CAP_UNFINISHED or CAP_POSITION
+}
+
+type matchState struct {
+	src        string // the subject
+	pattern    string
+	level      int // total number of captures (finished or unfinished)
+	matchDepth int // control for recursive depth (to avoid stack overflow)
+	capture    [LUA_MAXCAPTURES]capture
+	ls         LuaState
+}
+
+func newMatchState(ls LuaState, src, pattern string) *matchState {
+	return &matchState{src: src, pattern: pattern, ls: ls}
+}
+
+// The pattern byte at p, '\0' past the end as the C string does.
+func (ms *matchState) pat(p int) byte {
+	if p < len(ms.pattern) {
+		return ms.pattern[p]
+	}
+	return 0
+}
+
+// The subject byte at s, '\0' past the end.
+func (ms *matchState) char(s int) byte {
+	if s < len(ms.src) {
+		return ms.src[s]
+	}
+	return 0
+}
+
+func (ms *matchState) checkCapture(l byte) int {
+	i := int(l) - '1'
+	if i < 0 || i >= ms.level || ms.capture[i].len == CAP_UNFINISHED {
+		ms.ls.Error2("invalid capture index")
+	}
+	return i
+}
+
+func (ms *matchState) captureToClose() int {
+	level := ms.level
+	for level--; level >= 0; level-- {
+		if ms.capture[level].len == CAP_UNFINISHED {
+			return level
+		}
+	}
+	ms.ls.Error2("invalid pattern capture")
+	return 0
+}
+
+// The end of the single char class at p, such as 'a', '%d' or '[a-z]'.
+func (ms *matchState) classEnd(p int) int {
+	c := ms.pat(p)
+	p++
+	switch c {
+	case L_ESC:
+		if p >= len(ms.pattern) {
+			ms.ls.Error2("malformed pattern (ends with '%%')")
+		}
+		return p + 1
+	case '[':
+		if ms.pat(p) == '^' {
+			p++
+		}
+		for { /* look for a ']' */
+			if p >= len(ms.pattern) {
+				ms.ls.Error2("malformed pattern (missing ']')")
+			}
+			c := ms.pattern[p]
+			p++
+			if c == L_ESC && p < len(ms.pattern) {
+				p++ /* skip escapes (e.g. '%]') */
+			}
+			if ms.pat(p) == ']' {
+				break
+			}
+		}
+		return p + 1
+	default:
+		return p
+	}
+}
+
+func matchClass(c, cl byte) bool {
+	var res bool
+	switch toLower(cl) {
+	case 'a':
+		res = isAlpha(c)
+	case 'c':
+		res = isCntrl(c)
+	case 'd':
+		res = isDigit(c)
+	case 'l':
+		res = isLower(c)
+	case 'p':
+		res = isPunct(c)
+	case 's':
+		res = isSpace(c)
+	case 'u':
+		res = isUpper(c)
+	case 'w':
+		res = isAlpha(c) || isDigit(c)
+	case 'x':
+		res = isXDigit(c)
+	case 'z':
+		res = c == 0
+	default:
+		return cl == c
+	}
+	if isUpper(cl) {
+		return !res
+	}
+	return res
+}
+
+// Whether c matches the set [...] between p and ec, ec is the index of the closing ']'.
+func (ms *matchState) matchBracketClass(c byte, p, ec int) bool {
+	sig := true
+	if ms.pat(p+1) == '^' {
+		sig = false
+		p++ /* skip the '^' */
+	}
+	for p++; p < ec; p++ {
+		if ms.pattern[p] == L_ESC {
+			p++
+			if matchClass(c, ms.pat(p)) {
+				return sig
+			}
+		} else if ms.pat(p+1) == '-' && p+2 < ec {
+			p += 2
+			if ms.pattern[p-2] <= c && c <= ms.pattern[p] {
+				return sig
+			}
+		} else if ms.pattern[p] == c {
+			return sig
+		}
+	}
+	return !sig
+}
+
+func (ms *matchState) singleMatch(c byte, p, ep int) bool {
+	switch ms.pattern[p] {
+	case '.':
+		return true /* matches any char */
+	case L_ESC:
+		return matchClass(c, ms.pat(p+1))
+	case '[':
+		return ms.matchBracketClass(c, p, ep-1)
+	default:
+		return ms.pattern[p] == c
+	}
+}
+
+// %bxy
+func (ms *matchState) matchBalance(s, p int) int {
+	if p+1 >= len(ms.pattern) {
+		ms.ls.Error2("unbalanced pattern")
+	}
+	if s >= len(ms.src) || ms.src[s] != ms.pattern[p] {
+		return -1
+	}
+	b, e := ms.pattern[p], ms.pattern[p+1]
+	cont := 1
+	for s++; s < len(ms.src); s++ {
+		if ms.src[s] == e {
+			if cont--; cont == 0 {
+				return s + 1
+			}
+		} else if ms.src[s] == b {
+			cont++
+		}
+	}
+	return -1 /* string ends out of balance */
+}
+
+func (ms *matchState) maxExpand(s, p, ep int) int {
+	i := 0 /* counts maximum expand for item */
+	for s+i < len(ms.src) && ms.singleMatch(ms.src[s+i], p, ep) {
+		i++
+	}
+	/* keeps trying to match with the maximum repetitions */
+	for ; i >= 0; i-- {
+		if res := ms.match(s+i, ep+1); res != -1 {
+			return res
+		}
+	}
+	return -1
+}
+
+func (ms *matchState) minExpand(s, p, ep int) int {
+	for {
+		if res := ms.match(s, ep+1); res != -1 {
+			return res
+		} else if s < len(ms.src) && ms.singleMatch(ms.src[s], p, ep) {
+			s++ /* try with one more repetition */
+		} else {
+			return -1
+		}
+	}
+}
+
+func (ms *matchState) startCapture(s, p, what int) int {
+	level := ms.level
+	if level >= LUA_MAXCAPTURES {
+		ms.ls.Error2("too many captures")
+	}
+	ms.capture[level].init = s
+	ms.capture[level].len = what
+	ms.level = level + 1
+	res := ms.match(s, p)
+	if res == -1 { /* match failed? */
+		ms.level-- /* undo capture */
+	}
+	return res
+}
+
+func (ms *matchState) endCapture(s, p int) int {
+	l := ms.captureToClose()
+	ms.capture[l].len = s - ms.capture[l].init /* close capture */
+	res := ms.match(s, p)
+	if res == -1 { /* match failed? */
+		ms.capture[l].len = CAP_UNFINISHED /* undo capture */
+	}
+	return res
+}
+
+func (ms *matchState) matchCapture(s int, l byte) int {
+	i := ms.checkCapture(l)
+	cap := ms.capture[i]
+	if len(ms.src)-s >= cap.len && ms.src[cap.init:cap.init+cap.len] == ms.src[s:s+cap.len] {
+		return s + cap.len
+	}
+	return -1
+}
+
+/*
+	@description
+		Match the pattern from p against the subject from s.
+	@return
+		e	int	"the end of the match in the subject, -1 if it does not match"
+*/
+func (ms *matchState) match(s, p int) int {
+	if ms.matchDepth++; ms.matchDepth > MAXCCALLS {
+		ms.ls.Error2("pattern too complex")
+	}
+	defer func() { ms.matchDepth-- }()
+
+	for { /* loop instead of the tail calls */
+		if p >= len(ms.pattern) { /* end of pattern */
+			return s /* match succeeded */
+		}
+		switch ms.pattern[p] {
+		case '(': /* start capture */
+			if ms.pat(p+1) == ')' { /* position capture? */
+				return ms.startCapture(s, p+2, CAP_POSITION)
+			}
+			return ms.startCapture(s, p+1, CAP_UNFINISHED)
+		case ')': /* end capture */
+			return ms.endCapture(s, p+1)
+		case '$':
+			if p+1 == len(ms.pattern) { /* is the '$' the last char in pattern? */
+				if s == len(ms.src) { /* check end of string */
+					return s
+				}
+				return -1
+			}
+		case L_ESC:
+			switch next := ms.pat(p + 1); {
+			case next == 'b': /* balanced string? */
+				if s = ms.matchBalance(s, p+2); s == -1 {
+					return -1
+				}
+				p += 4
+				continue
+			case next == 'f': /* frontier? */
+				p += 2
+				if ms.pat(p) != '[' {
+					ms.ls.Error2("missing '[' after '%%f' in pattern")
+				}
+				ep := ms.classEnd(p) /* points to what is next */
+				var previous byte
+				if s > 0 {
+					previous = ms.src[s-1]
+				}
+				if ms.matchBracketClass(previous, p, ep-1) ||
+					!ms.matchBracketClass(ms.char(s), p, ep-1) {
+					return -1
+				}
+				p = ep
+				continue
+			case isDigit(next): /* capture results (%0-%9)? */
+				if s = ms.matchCapture(s, next); s == -1 {
+					return -1
+				}
+				p += 2
+				continue
+			}
+		}
+
+		/* it is a pattern item */
+		ep := ms.classEnd(p) /* points to what is next */
+		m := s < len(ms.src) && ms.singleMatch(ms.src[s], p, ep)
+		switch ms.pat(ep) {
+		case '?': /* optional */
+			if m {
+				if res := ms.match(s+1, ep+1); res != -1 {
+					return res
+				}
+			}
+			p = ep + 1
+		case '*': /* 0 or more repetitions */
+			return ms.maxExpand(s, p, ep)
+		case '+': /* 1 or more repetitions */
+			if m {
+				return ms.maxExpand(s+1, p, ep)
+			}
+			return -1
+		case '-': /* 0 or more repetitions (minimum) */
+			return ms.minExpand(s, p, ep)
+		default:
+			if !m {
+				return -1
+			}
+			s++
+			p = ep
+		}
+	}
+}
+
+/*
+	Push the i-th capture, or the whole match s..e if there are no captures.
+	A position capture is pushed as an integer.
+*/
+func (ms *matchState) pushOneCapture(i, s, e int) {
+	if i >= ms.level {
+		if i == 0 { /* ms.level == 0, too */
+			ms.ls.PushString(ms.src[s:e]) /* add whole match */
+		} else {
+			ms.ls.Error2("invalid capture index")
+		}
+		return
+	}
+	l := ms.capture[i].len
+	if l == CAP_UNFINISHED {
+		ms.ls.Error2("unfinished capture")
+	}
+	if l == CAP_POSITION {
+		ms.ls.PushInteger(int64(ms.capture[i].init + 1))
+	} else {
+		init := ms.capture[i].init
+		ms.ls.PushString(ms.src[init : init+l])
+	}
+}
+
+/*
+	Push all the captures, s is -1 if the whole match should not be pushed when
+	there are no captures.
+	@return
+		n	int	"number of values pushed"
+*/
+func (ms *matchState) pushCaptures(s, e int) int {
+	nLevels := ms.level
+	if nLevels == 0 && s != -1 {
+		nLevels = 1
+	}
+	ms.ls.CheckStack2(nLevels, "too many captures")
+	for i := 0; i < nLevels; i++ {
+		ms.pushOneCapture(i, s, e)
+	}
+	return nLevels
+}
+
+/* character classes of the C locale */
+
+func isAlpha(c byte) bool  { return isLower(c) || isUpper(c) }
+func isDigit(c byte) bool  { return '0' <= c && c <= '9' }
+func isLower(c byte) bool  { return 'a' <= c && c <= 'z' }
+func isUpper(c byte) bool  { return 'A' <= c && c <= 'Z' }
+func isCntrl(c byte) bool  { return c < ' ' || c == 0x7F }
+func isSpace(c byte) bool  { return c == ' ' || '\t' <= c && c <= '\r' }
+func isPunct(c byte) bool  { return c > ' ' && c < 0x7F && !isAlpha(c) && !isDigit(c) }
+func isXDigit(c byte) bool { return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F' }
+
+func toLower(c byte) byte {
+	if isUpper(c) {
+		return c + ('a' - 'A')
+	}
+	return c
+}
+
+func toUpper(c byte) byte {
+	if isLower(c) {
+		return c - ('a' - 'A')
+	}
+	return c
+}
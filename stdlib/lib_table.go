@@ -0,0 +1,370 @@
+package stdlib
+
+import (
+	. "goluar/api"
+	. "goluar/common"
+)
+
+// The options of the table library, like the LUA_COMPAT options of luaconf.h.
+type TableConfig struct {
+	Compat52 bool // table.pack and table.unpack of lua 5.2
+}
+
+var tabFuncs = map[string]GoFunction{
+	"concat":   tabConcat,
+	"foreach":  tabForeach,
+	"foreachi": tabForeachi,
+	"getn":     tabGetn,
+	"maxn":     tabMaxn,
+	"insert":   tabInsert,
+	"remove":   tabRemove,
+	"setn":     tabSetn,
+	"sort":     tabSort,
+}
+
+var tabFuncs52 = map[string]GoFunction{
+	"pack":   tabPack,
+	"unpack": baseUnpack,
+}
+
+/*
+	@description
+		Make the opener of a table library with the options of the config, like NewIOLib,
+		it must be required before OpenLibs to replace the table library of OpenLibs.
+*/
+func NewTableLib(cfg TableConfig) GoFunction {
+	lib := &tableLib{cfg}
+	return lib.open
+}
+
+/*
+	lua-5.1.5/src/ltablib.c#luaopen_table()
+	The table library of OpenLibs, without the functions of lua 5.2.
+	Like the reference implementation, the functions access the elements with
+	raw gets and sets, __index and __newindex are not called.
+*/
+func OpenTableLib(ls LuaState) int {
+	return NewTableLib(TableConfig{})(ls)
+}
+
+type tableLib struct {
+	cfg TableConfig
+}
+
+func (self *tableLib) open(ls LuaState) int {
+	ls.NewLib(tabFuncs)
+	if self.cfg.Compat52 {
+		ls.SetFuncs(tabFuncs52, 0)
+	}
+	return 1
+}
+
+// The length of the table at the argument n.
+func auxGetn(ls LuaState, n int) int64 {
+	ls.CheckType(n, LUA_TTABLE)
+	return int64(ls.RawLen(n))
+}
+
+// table.foreach (table, f)
+// lua-5.1.5/src/ltablib.c#foreach()
+// Deprecated in lua 5.1.
+func tabForeach(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	ls.CheckType(2, LUA_TFUNCTION)
+	ls.PushNil() /* first key */
+	for ls.Next(1) {
+		ls.PushValue(2)  /* function */
+		ls.PushValue(-3) /* key */
+		ls.PushValue(-3) /* value */
+		ls.Call(2, 1)
+		if !ls.IsNil(-1) {
+			return 1
+		}
+		ls.Pop(2) /* remove value and result */
+	}
+	return 0
+}
+
+// table.foreachi (table, f)
+// lua-5.1.5/src/ltablib.c#foreachi()
+// Deprecated in lua 5.1.
+func tabForeachi(ls LuaState) int {
+	n := auxGetn(ls, 1)
+	ls.CheckType(2, LUA_TFUNCTION)
+	for i := int64(1); i <= n; i++ {
+		ls.PushValue(2)   /* function */
+		ls.PushInteger(i) /* 1st argument */
+		ls.RawGetI(1, i)  /* 2nd argument */
+		ls.Call(2, 1)
+		if !ls.IsNil(-1) {
+			return 1
+		}
+		ls.Pop(1) /* remove nil result */
+	}
+	return 0
+}
+
+// table.maxn (table)
+// http://www.lua.org/manual/5.1/manual.html#pdf-table.maxn
+// lua-5.1.5/src/ltablib.c#maxn()
+func tabMaxn(ls LuaState) int {
+	max := 0.0
+	ls.CheckType(1, LUA_TTABLE)
+	ls.PushNil() /* first key */
+	for ls.Next(1) {
+		ls.Pop(1) /* remove value */
+		if ls.Type(-1) == LUA_TNUMBER {
+			if v := ls.ToNumber(-1); v > max {
+				max = v
+			}
+		}
+	}
+	ls.PushNumber(max)
+	return 1
+}
+
+// table.getn (table)
+// lua-5.1.5/src/ltablib.c#getn()
+// Deprecated in lua 5.1, the same as #table.
+func tabGetn(ls LuaState) int {
+	ls.PushInteger(auxGetn(ls, 1))
+	return 1
+}
+
+// table.setn (table, n)
+// lua-5.1.5/src/ltablib.c#setn()
+// The size of a table can not be set in lua 5.1.
+func tabSetn(ls LuaState) int {
+	ls.CheckType(1, LUA_TTABLE)
+	return ls.Error2("'setn' is obsolete")
+}
+
+// table.insert (table, [pos,] value)
+// http://www.lua.org/manual/5.1/manual.html#pdf-table.insert
+// lua-5.1.5/src/ltablib.c#tinsert()
+func tabInsert(ls LuaState) int {
+	e := auxGetn(ls, 1) + 1 /* first empty element */
+	var pos int64           /* where to insert new element */
+	switch ls.GetTop() {
+	case 2: /* called with only 2 arguments */
+		pos = e /* insert new element at the end */
+	case 3:
+		pos = ls.CheckInteger(2) /* 2nd argument is the position */
+		if pos > e {
+			e = pos /* 'grow' array if necessary */
+		}
+		for i := e; i > pos; i-- { /* move up elements */
+			ls.RawGetI(1, i-1)
+			ls.RawSetI(1, i) /* t[i] = t[i-1] */
+		}
+	default:
+		return ls.Error2("wrong number of arguments to 'insert'")
+	}
+	ls.RawSetI(1, pos) /* t[pos] = v */
+	return 0
+}
+
+// table.remove (table [, pos])
+// http://www.lua.org/manual/5.1/manual.html#pdf-table.remove
+// lua-5.1.5/src/ltablib.c#tremove()
+func tabRemove(ls LuaState) int {
+	e := auxGetn(ls, 1)
+	pos := ls.OptInteger(2, e)
+	if !(1 <= pos && pos <= e) { /* position is outside bounds? */
+		return 0 /* nothing to remove */
+	}
+	ls.RawGetI(1, pos) /* result = t[pos] */
+	for ; pos < e; pos++ {
+		ls.RawGetI(1, pos+1)
+		ls.RawSetI(1, pos) /* t[pos] = t[pos+1] */
+	}
+	ls.PushNil()
+	ls.RawSetI(1, e) /* t[e] = nil */
+	return 1
+}
+
+func addField(ls LuaState, buf []byte, i int64) []byte {
+	ls.RawGetI(1, i)
+	if !ls.IsString(-1) {
+		ls.Error2("invalid value (at index %d) in table for 'concat'", i)
+	}
+	buf = append(buf, ls.ToString(-1)...)
+	ls.Pop(1)
+	return buf
+}
+
+// table.concat (table [, sep [, i [, j]]])
+// http://www.lua.org/manual/5.1/manual.html#pdf-table.concat
+// lua-5.1.5/src/ltablib.c#tconcat()
+func tabConcat(ls LuaState) int {
+	sep := ls.OptString(2, "")
+	ls.CheckType(1, LUA_TTABLE)
+	i := ls.OptInteger(3, 1)
+	var last int64
+	if ls.IsNoneOrNil(4) {
+		last = int64(ls.RawLen(1))
+	} else {
+		last = ls.CheckInteger(4)
+	}
+
+	var buf []byte
+	for ; i < last; i++ {
+		buf = addField(ls, buf, i)
+		buf = append(buf, sep...)
+	}
+	if i == last { /* add last value (if interval was not empty) */
+		buf = addField(ls, buf, i)
+	}
+	ls.PushString(string(buf))
+	return 1
+}
+
+// table.pack (···)
+// http://www.lua.org/manual/5.2/manual.html#pdf-table.pack
+// lua-5.2.4/src/ltablib.c#pack()
+// Only opened if TableConfig.Compat52 is set.
+func tabPack(ls LuaState) int {
+	n := ls.GetTop()          /* number of elements to pack */
+	ls.CreateTable(n, 1)      /* create result table */
+	ls.Insert(1)              /* put it at index 1 */
+	for i := n; i >= 1; i-- { /* assign elements */
+		ls.RawSetI(1, int64(i))
+	}
+	ls.PushInteger(int64(n))
+	ls.SetField(1, "n") /* t.n = number of elements */
+	return 1            /* return table */
+}
+
+/*
+** {======================================================
+** Quicksort
+** (based on 'Algorithms in MODULA-3', Robert Sedgewick;
+**  Addison-Wesley, 1993.)
+** =======================================================
+ */
+
+func set2(ls LuaState, i, j int64) {
+	ls.RawSetI(1, i)
+	ls.RawSetI(1, j)
+}
+
+// Whether the value at a is less than the value at b, by the comparator at index 2 or '<'.
+func sortComp(ls LuaState, a, b int) bool {
+	if !ls.IsNil(2) { /* function? */
+		ls.PushValue(2)
+		ls.PushValue(a - 1) /* -1 to compensate function */
+		ls.PushValue(b - 2) /* -2 to compensate function and 'a' */
+		ls.Call(2, 1)
+		res := ls.ToBoolean(-1)
+		ls.Pop(1)
+		return res
+	}
+	return ls.Compare(a, b, LUA_OPLT) /* a < b? */
+}
+
+/*
+	Sort the elements a[l..u]. An inconsistent comparator can not make the partition
+	run out of the range, "invalid order function for sorting" is raised instead,
+	as lua 5.2 does.
+*/
+func auxSort(ls LuaState, l, u int64) {
+	for l < u { /* for tail recursion */
+		/* sort elements a[l], a[(l+u)/2] and a[u] */
+		ls.RawGetI(1, l)
+		ls.RawGetI(1, u)
+		if sortComp(ls, -1, -2) { /* a[u] < a[l]? */
+			set2(ls, l, u) /* swap a[l] - a[u] */
+		} else {
+			ls.Pop(2)
+		}
+		if u-l == 1 {
+			break /* only 2 elements */
+		}
+		i := (l + u) / 2
+		ls.RawGetI(1, i)
+		ls.RawGetI(1, l)
+		if sortComp(ls, -2, -1) { /* a[i]<a[l]? */
+			set2(ls, i, l)
+		} else {
+			ls.Pop(1) /* remove a[l] */
+			ls.RawGetI(1, u)
+			if sortComp(ls, -1, -2) { /* a[u]<a[i]? */
+				set2(ls, i, u)
+			} else {
+				ls.Pop(2)
+			}
+		}
+		if u-l == 2 {
+			break /* only 3 elements */
+		}
+		ls.RawGetI(1, i) /* Pivot */
+		ls.PushValue(-1)
+		ls.RawGetI(1, u-1)
+		set2(ls, i, u-1)
+		/* a[l] <= P == a[u-1] <= a[u], only need to sort from l+1 to u-2 */
+		i = l
+		j := u - 1
+		for { /* invariant: a[l..i] <= P <= a[j..u] */
+			/* repeat ++i until a[i] >= P */
+			for {
+				i++
+				ls.RawGetI(1, i)
+				if !sortComp(ls, -1, -2) {
+					break
+				}
+				if i >= u {
+					ls.Error2("invalid order function for sorting")
+				}
+				ls.Pop(1) /* remove a[i] */
+			}
+			/* repeat --j until a[j] <= P */
+			for {
+				j--
+				ls.RawGetI(1, j)
+				if !sortComp(ls, -3, -1) {
+					break
+				}
+				if j <= l {
+					ls.Error2("invalid order function for sorting")
+				}
+				ls.Pop(1) /* remove a[j] */
+			}
+			if j < i {
+				ls.Pop(3) /* pop pivot, a[i], a[j] */
+				break
+			}
+			set2(ls, i, j)
+		}
+		ls.RawGetI(1, u-1)
+		ls.RawGetI(1, i)
+		set2(ls, u-1, i) /* swap pivot (a[u-1]) with a[i] */
+		/* a[l..i-1] <= a[i] == P <= a[i+1..u] */
+		/* adjust so that smaller half is in [j..i] and larger one in [l..u] */
+		if i-l < u-i {
+			j = l
+			i = i - 1
+			l = i + 2
+		} else {
+			j = i + 1
+			i = u
+			u = j - 2
+		}
+		auxSort(ls, j, i) /* call recursively the smaller one */
+	} /* repeat the routine for the larger one */
+}
+
+// table.sort (table [, comp])
+// http://www.lua.org/manual/5.1/manual.html#pdf-table.sort
+// lua-5.1.5/src/ltablib.c#sort()
+func tabSort(ls LuaState) int {
+	n := auxGetn(ls, 1)
+	ls.CheckStack2(40, "")  /* assume array is smaller than 2^40 */
+	if !ls.IsNoneOrNil(2) { /* is there a 2nd argument? */
+		ls.CheckType(2, LUA_TFUNCTION)
+	}
+	ls.SetTop(2) /* make sure there is two arguments */
+	auxSort(ls, 1, n)
+	return 0
+}
+
+/* }====================================================== */
@@ -1,9 +1,14 @@
 package test
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/binary"
 	. "goluar/common"
+	"goluar/compiler"
+	"goluar/vm"
 	"io/ioutil"
+	"math"
+	"os"
 	"testing"
 )
 
@@ -12,68 +17,181 @@ func TestBinaryChunk(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
-	proto := LoadBinaryChunk(data)
+	proto, err := LoadBinaryChunk(data)
+	if err != nil {
+		t.Fatal(err)
+	}
 	println("--------------------TestBinaryChunk------------------")
-	list(proto)
+	vm.PrintFunction(os.Stdout, proto, true)
 }
 
-func list(f *FuncProto) {
-	printHeader(f)
-	printCode(f)
-	printDetail(f)
-	for _, p := range f.Protos {
-		list(p)
+func TestDumpBinaryChunk(t *testing.T) {
+	data, err := ioutil.ReadFile("out/hello.out")
+	if err != nil {
+		panic(err)
+	}
+	if dumped := DumpBinaryChunk(mustLoad(t, data), false); !bytes.Equal(dumped, data) {
+		t.Errorf("dump of out/hello.out differs from the original chunk")
+	}
+
+	chunkName := "lua/list_summary.lua"
+	data, err = ioutil.ReadFile(chunkName)
+	if err != nil {
+		panic(err)
+	}
+	proto := compiler.Compile(string(data), "@"+chunkName)
+	for _, strip := range []bool{false, true} {
+		chunk := DumpBinaryChunk(proto, strip)
+		if again := DumpBinaryChunk(mustLoad(t, chunk), strip); !bytes.Equal(again, chunk) {
+			t.Errorf("dump of the reloaded chunk differs, strip: %v", strip)
+		}
 	}
 }
 
-func printHeader(f *FuncProto) {
-	funcType := "main"
-	if f.StartLine > 0 {
-		funcType = "function"
+/*
+	Build a chunk with the given header by hand, to check the loader honors the sizes and the byte order:
+		main <=?:0,0>, RETURN 0 1, constants: nil, true, 2, "ab"
+*/
+func buildChunk(order binary.ByteOrder, intSize, sizetSize, numberSize, integral byte) []byte {
+	var buf bytes.Buffer
+	endian := byte(1)
+	if order == binary.BigEndian {
+		endian = 0
+	}
+	buf.WriteString(SIGNATURE)
+	buf.Write([]byte{VERSION, FORMAT, endian, intSize, sizetSize, INSTRUCTION_SIZE, numberSize, integral})
+	writeUint := func(size byte, i uint64) {
+		b := make([]byte, 8)
+		order.PutUint64(b, i)
+		if order == binary.BigEndian {
+			buf.Write(b[8-size:])
+		} else {
+			buf.Write(b[:size])
+		}
+	}
+	writeString := func(s string) {
+		writeUint(sizetSize, uint64(len(s)+1))
+		buf.WriteString(s)
+		buf.WriteByte(0)
 	}
 
-	varargFlag := ""
-	if f.IsVararg > 0 {
-		varargFlag = "+"
+	writeString("=?")
+	writeUint(intSize, 0)          // StartLine
+	writeUint(intSize, 0)          // EndLine
+	buf.Write([]byte{0, 0, 2, 2})  // UpvalueCount, NumParams, IsVararg, MaxStackSize
+	writeUint(intSize, 1)          // Instructions
+	writeUint(4, 1<<23|OP_RETURN)  // RETURN 0 1
+	writeUint(intSize, 4)          // Constants
+	buf.Write([]byte{TAG_NIL})     // nil
+	buf.Write([]byte{TAG_BOOLEAN}) // true
+	buf.WriteByte(1)
+	buf.WriteByte(TAG_NUMBER) // 2
+	switch {
+	case integral == 1:
+		writeUint(numberSize, 2)
+	case numberSize == 4:
+		writeUint(4, uint64(math.Float32bits(2)))
+	default:
+		writeUint(8, math.Float64bits(2))
 	}
+	buf.WriteByte(TAG_STRING) // "ab"
+	writeString("ab")
+	writeUint(intSize, 0) // Protos
+	writeUint(intSize, 1) // LineInfo
+	writeUint(intSize, 1)
+	writeUint(intSize, 0) // LocVars
+	writeUint(intSize, 0) // UpvalueNames
+	return buf.Bytes()
+}
 
-	fmt.Printf("\n%s <%s:%d,%d> (%d instructions)\n",
-		funcType, f.Source, f.StartLine, f.EndLine, len(f.Instructions))
+func TestLoadBinaryChunkFormats(t *testing.T) {
+	formats := []struct {
+		order                                    binary.ByteOrder
+		intSize, sizetSize, numberSize, integral byte
+	}{
+		{binary.LittleEndian, 4, 8, 8, 0},
+		{binary.LittleEndian, 4, 4, 8, 0},
+		{binary.BigEndian, 4, 4, 8, 0},
+		{binary.BigEndian, 8, 8, 4, 0},
+		{binary.LittleEndian, 4, 4, 4, 1},
+	}
+	for _, f := range formats {
+		proto := mustLoad(t, buildChunk(f.order, f.intSize, f.sizetSize, f.numberSize, f.integral))
+		if proto.Source != "=?" || len(proto.Instructions) != 1 || proto.Instructions[0] != 1<<23|OP_RETURN ||
+			len(proto.LineInfo) != 1 || proto.LineInfo[0] != 1 {
+			t.Errorf("format %v: bad function proto", f)
+		}
+		k := proto.Constants
+		if len(k) != 4 || k[0] != nil || k[1] != true || k[2] != float64(2) || k[3] != "ab" {
+			t.Errorf("format %v: bad constants %v", f, k)
+		}
+	}
+}
 
-	fmt.Printf("%d%s params, %d slots, ",
-		f.NumParams, varargFlag, f.MaxStackSize)
+func TestLoadBinaryChunkErrors(t *testing.T) {
+	data, err := ioutil.ReadFile("out/hello.out")
+	if err != nil {
+		panic(err)
+	}
+	// every prefix of a valid chunk is truncated
+	for n := 0; n < len(data); n++ {
+		if _, err := LoadBinaryChunk(data[:n]); err == nil {
+			t.Errorf("truncated chunk of %d bytes is loaded", n)
+		}
+	}
 
-	fmt.Printf("%d constants, %d functions\n",
-		len(f.Constants), len(f.Protos))
-}
+	corrupt := func(offset int, b byte) []byte {
+		chunk := append([]byte{}, data...)
+		chunk[offset] = b
+		return chunk
+	}
+	cases := []struct {
+		chunk []byte
+		err   error
+	}{
+		{corrupt(0, 'x'), ErrSignature},
+		{corrupt(4, 0x53), ErrVersion},
+		{corrupt(5, 1), ErrFormat},
+		{corrupt(6, 2), ErrEndianness},
+		{corrupt(7, 3), ErrIntSize},
+		{corrupt(8, 2), ErrSizetSize},
+		{corrupt(9, 8), ErrInstructionSize},
+		{corrupt(10, 5), ErrNumberSize},
+		{corrupt(11, 2), ErrIntegralFlag},
+		{corrupt(12, 0xFF), ErrTruncated}, // size of the source
+		{buildChunkWithTag(9), ErrConstantTag},
+	}
+	for i, c := range cases {
+		if _, err := LoadBinaryChunk(c.chunk); err != c.err {
+			t.Errorf("case %d: expected error %q, got %v", i, c.err, err)
+		}
+	}
 
-func printCode(f *FuncProto) {
-	for pc, c := range f.Instructions {
-		line := "-"
-		fmt.Printf("\t%d\t[%s]\t0x%08X\n", pc+1, line, c)
+	ls := vm.New()
+	if status := ls.Load(data[:len(data)-1], "=hello.out", "bt"); status != LUA_ERRSYNTAX {
+		t.Errorf("Load of a truncated chunk returns %d", status)
+	} else if msg, _ := ls.ToStringX(-1); msg != "hello.out: "+ErrTruncated.Error() {
+		t.Errorf("unexpected message %q", msg)
+	}
+	if status := ls.Load(data[:len(data)-1], string(data[:len(data)-1]), "bt"); status != LUA_ERRSYNTAX {
+		t.Errorf("Load of a truncated chunk returns %d", status)
+	} else if msg, _ := ls.ToStringX(-1); msg != "binary string: "+ErrTruncated.Error() {
+		t.Errorf("unexpected message %q", msg)
 	}
 }
 
-func printDetail(f *FuncProto) {
-	fmt.Printf("constants (%d):\n", len(f.Constants))
-	for i, k := range f.Constants {
-		fmt.Printf("\t%d\t%s\n", i+1, constantToString(k))
-	}
+// A chunk whose first constant has an unknown tag.
+func buildChunkWithTag(tag byte) []byte {
+	chunk := buildChunk(binary.LittleEndian, 4, 8, 8, 0)
+	// header 12, source 8+3, lines 4+4, 4 bytes, code 4+4, constant count 4
+	chunk[12+11+8+4+8+4] = tag
+	return chunk
 }
 
-func constantToString(k interface{}) string {
-	switch k.(type) {
-	case nil:
-		return "nil"
-	case bool:
-		return fmt.Sprintf("%t", k)
-	case float64:
-		return fmt.Sprintf("%g", k)
-	case int64:
-		return fmt.Sprintf("%d", k)
-	case string:
-		return fmt.Sprintf("%q", k)
-	default:
-		return "?"
+func mustLoad(t *testing.T, chunk []byte) *FuncProto {
+	proto, err := LoadBinaryChunk(chunk)
+	if err != nil {
+		t.Fatal(err)
 	}
+	return proto
 }
@@ -2,7 +2,9 @@ package test
 
 import (
 	. "goluar/compiler"
+	"goluar/vm"
 	"io/ioutil"
+	"os"
 	"testing"
 )
 
@@ -14,5 +16,5 @@ func TestCompiler(t *testing.T) {
 	}
 	proto := Compile(string(data), chunkName)
 	println("--------------------TestCompiler------------------")
-	list(proto)
+	vm.PrintFunction(os.Stdout, proto, true)
 }
@@ -0,0 +1,36 @@
+package test
+
+import (
+	"bytes"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Build the glua command into a temporary directory.
+func buildGlua(t *testing.T) string {
+	t.Helper()
+	bin := filepath.Join(t.TempDir(), "glua")
+	if out, err := exec.Command("go", "build", "-o", bin, "goluar/cmd").CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	return bin
+}
+
+func TestREPLIncompleteAtEOF(t *testing.T) {
+	bin := buildGlua(t)
+	for _, input := range []string{"for\n", "x = 1 +\n", "print(1)\nif true then\n"} {
+		cmd := exec.Command(bin, "-i")
+		cmd.Stdin = strings.NewReader(input)
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			t.Errorf("%q: %v\n%s", input, err, stderr.String())
+			continue
+		}
+		if !strings.Contains(stderr.String(), "near '<eof>'") || strings.Contains(stderr.String(), "panic") {
+			t.Errorf("%q: got %q", input, stderr.String())
+		}
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	. "goluar/compiler"
 	"io/ioutil"
+	"strconv"
+	"strings"
 	"testing"
 )
 
@@ -17,3 +19,59 @@ func TestParser(t *testing.T) {
 	}
 	println(string(b))
 }
+
+// The expression as a string, with parentheses around the operands of each operator.
+func expString(exp Exp) string {
+	switch x := exp.(type) {
+	case *NilExp:
+		return "nil"
+	case *TrueExp:
+		return "true"
+	case *FalseExp:
+		return "false"
+	case *IntegerExp:
+		return strconv.FormatInt(x.Val, 10)
+	case *FloatExp:
+		return strconv.FormatFloat(x.Val, 'g', -1, 64)
+	case *NameExp:
+		return x.Name
+	case *ConcatExp:
+		exps := make([]string, len(x.Exps))
+		for i, e := range x.Exps {
+			exps[i] = expString(e)
+		}
+		return "(" + strings.Join(exps, " .. ") + ")"
+	case *BinopExp:
+		ops := map[int]string{LEX_OP_OR: "or", LEX_OP_AND: "and", LEX_OP_EQ: "==", LEX_OP_LT: "<",
+			LEX_OP_ADD: "+", LEX_OP_SUB: "-", LEX_OP_MUL: "*", LEX_OP_DIV: "/", LEX_OP_MOD: "%", LEX_OP_POW: "^"}
+		return "(" + expString(x.Exp1) + " " + ops[x.Op] + " " + expString(x.Exp2) + ")"
+	}
+	return "?"
+}
+
+func TestParserPrecedence(t *testing.T) {
+	for src, want := range map[string]string{
+		"a or b and c":          "(a or (b and c))",
+		"a and b or c and d":    "((a and b) or (c and d))",
+		"a or b or c":           "((a or b) or c)",
+		"a == b and c < d":      "((a == b) and (c < d))",
+		"a .. b * c":            "(a .. (b * c))",
+		"a .. b .. c + d":       "(a .. b .. (c + d))",
+		"a + b * c - d / e % f": "((a + (b * c)) - ((d / e) % f))",
+		"a * b ^ c":             "(a * (b ^ c))",
+		"1 + 2 * 3":             "7",
+		"true and a":            "a",
+		"false and a":           "false",
+		"nil and a":             "nil",
+		"true or a":             "true",
+		"nil or a":              "a",
+		"false or a and b":      "(a and b)",
+		"a and true":            "(a and true)",
+		"a or b and nil":        "(a or (b and nil))",
+	} {
+		block := Parse("return "+src, "=test")
+		if got := expString(block.RetExps[0]); got != want {
+			t.Errorf("%s: got %s, want %s", src, got, want)
+		}
+	}
+}
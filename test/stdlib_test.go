@@ -0,0 +1,541 @@
+package test
+
+import (
+	"bytes"
+	. "goluar/api"
+	. "goluar/common"
+	"goluar/stdlib"
+	state "goluar/vm"
+	"runtime"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// Run the lua source with the standard libraries, the test fails if it raises an error.
+func runLua(t *testing.T, source string) LuaState {
+	t.Helper()
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.Load([]byte(source), "=test", "t") != LUA_OK || ls.PCall(0, LUA_MULTRET, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	return ls
+}
+
+func TestBaseLib(t *testing.T) {
+	runLua(t, `
+assert(type(print) == "function" and type(nil) == "nil" and type({}) == "table")
+assert(tostring(12) == "12" and tostring(2.5) == "2.5" and tostring(10/2) == "5" and tostring(nil) == "nil")
+assert(tostring(setmetatable({}, {__tostring = function() return "obj" end})) == "obj")
+assert(tonumber("0x10") == 16 and tonumber(" 12 ") == 12 and tonumber("1e2") == 100)
+assert(tonumber("z", 36) == 35 and tonumber("ff", 16) == 255 and tonumber("-101", 2) == -5)
+assert(tonumber("8", 8) == nil and tonumber("abc") == nil and tonumber({}) == nil)
+assert(select("#", 1, nil, 3) == 3 and select(2, "a", "b") == "b" and select(-1, "a", "b") == "b")
+
+local t = {10, 20, 30, x = 1}
+local sum = 0
+for i, v in ipairs(t) do sum = sum + i * v end
+assert(sum == 140)
+local n = 0
+for k, v in pairs(t) do n = n + 1 end
+assert(n == 4 and next({}) == nil)
+assert(rawget(t, 1) == 10 and rawequal(t, t) and rawset(t, 4, 40) == t and #t == 4)
+local a, b, c = unpack({1, 2, 3})
+assert(a == 1 and b == 2 and c == 3 and select("#", unpack({1, 2, 3}, 2)) == 2)
+
+local ok, msg = pcall(error, "msg")
+assert(not ok and msg == "msg")
+ok, msg = pcall(function() error("lvl") end)
+assert(msg == "test:23: lvl")
+ok, msg = pcall(error, {code = 1})
+assert(msg.code == 1)
+ok, msg = pcall(function() assert(nil, "boom") end)
+assert(msg == "test:27: boom")
+assert(select("#", assert(1, 2)) == 2)
+
+local mt = {__metatable = "locked"}
+setmetatable(t, mt)
+assert(getmetatable(t) == "locked" and getmetatable({}) == nil)
+ok, msg = pcall(setmetatable, t, {})
+assert(not ok and msg == "cannot change a protected metatable")
+ok, msg = pcall(setmetatable, 1, {})
+assert(msg == "bad argument #1 to '?' (table expected, got number)")
+
+assert(loadstring("return 1 + ...")(41) == 42)
+local f, err = loadstring("x =")
+assert(f == nil and err == [[[string "x ="]:1: syntax error near '<eof>']])
+local parts, i = {"return ", "'hi'"}, 0
+assert(load(function() i = i + 1 return parts[i] end)() == "hi")
+assert(collectgarbage("count") > 0 and collectgarbage() == 0)
+assert(collectgarbage("stop") == 0 and collectgarbage("restart") == 0)
+assert(collectgarbage("setpause", 150) == 200 and collectgarbage("setpause", 100) == 150)
+assert(collectgarbage("setstepmul", 400) == 200 and collectgarbage("setstepmul") == 400)
+
+local x, y, z = nil, 1, 2
+assert(y + z * 3 == 7 and y - z - z == -3 and (x or y and z) == 2 and (y or z and x) == 1)
+`)
+}
+
+func TestStringLib(t *testing.T) {
+	runLua(t, `
+assert(string.format("%s, %d, %5.2f, %-3s|, %05d, %x, %X, %o", "a", 3, 3.14159, "b", 42, 255, 255, 8) ==
+	"a, 3,  3.14, b  |, 00042, ff, FF, 10")
+assert(string.format("%e %g %g %q %c %% %+d", 12345.678, 0.1, 1e20, 'a "q"\n', 65, 5) ==
+	'1.234568e+04 0.1 1e+20 "a \\"q\\"\\\n" A % +5')
+assert(("hello"):upper() == "HELLO" and ("x"):rep(3) == "xxx" and ("abc"):reverse() == "cba")
+assert(("abc"):sub(2) == "bc" and ("abc"):sub(-2, -1) == "bc" and ("abc"):sub(5) == "" and #"abc" == 3)
+local a, b, c = string.byte("ABC", 1, -1)
+assert(a == 65 and c == 67 and string.char(72, 105) == "Hi")
+
+local i, j = string.find("hello world", "o w")
+assert(i == 5 and j == 7 and string.find("a.b", ".", 1, true) == 2 and string.find("abc", "d") == nil)
+local k, v = string.match("key = value", "(%w+)%s*=%s*(%w+)")
+assert(k == "key" and v == "value" and string.match("  trim  ", "^%s*(.-)%s*$") == "trim")
+local p1, p2 = string.match("hello", "()ll()")
+assert(p1 == 3 and p2 == 5 and string.match("abcabc", "(abc)%1") == "abc")
+assert(string.match("THE (quick) fox", "%((%a+)%)") == "quick" and select(2, string.find("x(a(b)c)y", "%b()")) == 8)
+assert(string.match("the word", "%f[%w]%w+") == "the" and string.match("aaab", "a-b") == "aaab")
+
+assert(string.gsub("hello world", "o", "0") == "hell0 w0rld")
+assert(string.gsub("hello world", "(%w+)", "<%1>") == "<hello> <world>")
+assert(string.gsub("abc", "", "-") == "-a-b-c-" and select(2, string.gsub("abc", "%w", "%0%0")) == 3)
+assert(string.gsub("$name is $age", "%$(%w+)", {name = "bob", age = 3}) == "bob is 3")
+assert(string.gsub("abc", "%w", function(c) return c:upper() .. "." end) == "A.B.C.")
+assert(string.gsub("hello", "l", "L", 1) == "heLlo" and string.gsub("THE (quick) fox", "%f[%a]%a+", "X") == "X (X) X")
+local s = ""
+for k, v in string.gmatch("a=1, b=2", "(%w+)=(%w+)") do s = s .. k .. v end
+assert(s == "a1b2")
+
+assert(not pcall(string.find, "a", "[a") and not pcall(string.find, "a", "%") and not pcall(string.match, "a", "("))
+assert(select(2, pcall(string.format, "%d", "x")) == "bad argument #2 to '?' (number expected, got string)")
+assert(loadstring(string.dump(function(a) return a * 2 end))(21) == 42 and not pcall(string.dump, print))
+`)
+}
+
+func TestTableLib(t *testing.T) {
+	runLua(t, `
+local t = {5, 2, 8, 1, 9, 3}
+table.sort(t)
+assert(table.concat(t, ",") == "1,2,3,5,8,9")
+table.sort(t, function(a, b) return a > b end)
+assert(table.concat(t, ",") == "9,8,5,3,2,1")
+local r = {}
+for i = 1, 200 do r[i] = (i * 7919) % 211 end
+table.sort(r)
+for i = 2, 200 do assert(r[i - 1] <= r[i]) end
+local ok, msg = pcall(table.sort, {3, 1, 2, 5, 4, 7, 6, 9, 8, 10, 11, 12}, function(a, b) return true end)
+assert(not ok and msg == "invalid order function for sorting")
+
+local q = {1, 2, 3}
+table.insert(q, 4)
+table.insert(q, 1, 0)
+assert(table.concat(q, ",") == "0,1,2,3,4" and table.remove(q) == 4 and table.remove(q, 1) == 0)
+assert(table.concat(q, ",") == "1,2,3" and #q == 3 and table.remove({}) == nil)
+assert(table.maxn({1, 2, [10] = 3}) == 10 and table.getn({1, 2}) == 2 and not pcall(table.setn, {}, 1))
+assert(table.concat({1, 2, 3}, "-", 2, 3) == "2-3" and table.concat({}, "x") == "")
+ok, msg = pcall(table.concat, {1, {}, 3})
+assert(msg == "invalid value (at index 2) in table for 'concat'")
+assert(table.pack == nil and table.unpack == nil)
+
+local proxy = setmetatable({}, {__index = function() return 1 end, __newindex = function() error("raw") end})
+table.insert(proxy, "x")
+assert(rawget(proxy, 1) == "x" and table.getn(proxy) == 1)
+
+local h = {a = 1, b = 2, c = 3}
+h[1] = 1
+assert(#h == 1 and table.getn(h) == 1)
+h = {x = 1, y = 2, z = 3, w = 4, v = 5}
+h[3], h[2], h[1] = 3, 2, 1
+assert(#h == 3)
+table.insert(h, 4)
+assert(h[4] == 4 and #h == 4)
+local rev = {}
+for i = 10, 1, -1 do rev[i] = i end
+assert(#rev == 10 and #{n = 1, 1, 2, 3, nil} == 3)
+`)
+
+	ls := state.New()
+	ls.RequireF("table", stdlib.NewTableLib(stdlib.TableConfig{Compat52: true}), true)
+	ls.Pop(1)
+	ls.OpenLibs()
+	if ls.Load([]byte(`
+local t = table.pack(1, nil, 3)
+assert(t.n == 3 and t[1] == 1 and t[3] == 3 and select("#", table.unpack({1, 2, 3})) == 3)
+`), "=test", "t") != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+func TestMathLib(t *testing.T) {
+	ls := runLua(t, `
+for _, a in ipairs({5, -5, 5.5, -5.5}) do
+  for _, b in ipairs({3, -3, 2.5, -2.5, math.huge, -math.huge}) do
+    assert(math.fmod(a, b) == a % b, a .. " % " .. b)
+  end
+end
+assert(math.fmod(-5, 3) == 1 and math.fmod(5, -3) == -1)
+local nan = 5 % 0
+assert(nan ~= nan and math.fmod(5, 0) ~= math.fmod(5, 0))
+assert(math.floor(3.7) == 3 and math.floor(-3.5) == -4 and math.ceil(3.2) == 4 and math.ceil(-3.5) == -3)
+local i, f = math.modf(-3.75)
+assert(i == -3 and f == -0.75 and select(2, math.modf(math.huge)) == 0)
+local m, e = math.frexp(12)
+assert(m == 0.75 and e == 4 and math.ldexp(m, e) == 12)
+assert(math.max(1, 5, 3) == 5 and math.min(2, -1, 0) == -1 and math.huge > 1e308 and math.mod(-5, 3) == 1)
+for _ = 1, 100 do
+  local r = math.random(3, 5)
+  assert(r >= 3 and r <= 5 and r == math.floor(r))
+end
+assert(not pcall(math.random, 0) and not pcall(math.random, 1, 2, 3))
+math.randomseed(42)
+first = {math.random(), math.random(100), math.random(-10, 10)}
+`)
+	ls.SeedRandom(42)
+	for i, want := range []float64{ls.Random(), ls.Random(), ls.Random()} {
+		ls.GetGlobal("first")
+		ls.RawGetI(-1, int64(i+1))
+		got := ls.ToNumber(-1)
+		ls.Pop(2)
+		switch i {
+		case 1:
+			want = float64(int64(want*100) + 1)
+		case 2:
+			want = float64(int64(want*21) - 10)
+		}
+		if got != want {
+			t.Errorf("random #%d after SeedRandom(42): got %v, want %v", i+1, got, want)
+		}
+	}
+}
+
+// Run the lua source with an io library using the config.
+func runLuaIO(t *testing.T, cfg stdlib.IOConfig, source string) {
+	t.Helper()
+	ls := state.New()
+	ls.RequireF("io", stdlib.NewIOLib(cfg), true)
+	ls.Pop(1)
+	ls.OpenLibs()
+	if ls.Load([]byte(source), "=test", "t") != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+func TestIOLib(t *testing.T) {
+	mfs := stdlib.NewMemFileSystem()
+	mfs.WriteFile("in.txt", []byte("first\n12.5 -3 0x10\nlast"))
+	var out bytes.Buffer
+	runLuaIO(t, stdlib.IOConfig{FS: mfs, Stdin: strings.NewReader("a\nb\n"), Stdout: &out}, `
+io.write("n=", 1, " ", 2.5, "\n")
+assert(io.type(io.stdout) == "file" and io.type({}) == nil and type(io.stdout) == "userdata")
+assert(io.read() == "a" and io.read("*a") == "b\n" and io.read() == nil and io.read(0) == nil)
+
+local f = assert(io.open("in.txt"))
+assert(f:read("*l") == "first")
+local a, b, c = f:read("*n", "*n", "*n")
+assert(a == 12.5 and b == -3 and c == 16 and f:read("*l") == "")
+assert(f:read(0) == "" and f:read(2) == "la" and f:read("*a") == "st" and f:read("*a") == "" and f:read("*l") == nil)
+assert(f:seek("set", 1) == 1 and f:read(4) == "irst" and f:seek() == 5 and f:seek("end") == 23)
+assert(f:close() and io.type(f) == "closed file" and tostring(f) == "file (closed)")
+assert(not pcall(f.read, f) and select(2, io.close(io.stdout)) == "cannot close standard file")
+
+f = assert(io.open("out.txt", "w"))
+assert(f:write("x", 1, "\n") and f:write("y\n") and f:close())
+f = assert(io.open("out.txt", "a+"))
+f:write("z")
+f:seek("set")
+assert(f:read("*a") == "x1\ny\nz")
+f:close()
+local lines = {}
+for l in io.lines("out.txt") do lines[#lines + 1] = l end
+assert(table.concat(lines, ",") == "x1,y,z")
+
+f = assert(io.open("out.txt", "r+"))
+f:read(1)
+f:write("2")
+f:seek("set")
+assert(f:read("*l") == "x2")
+f:close()
+
+local nf, msg = io.open("missing.txt")
+assert(nf == nil and msg == "missing.txt: file does not exist")
+assert(not pcall(io.lines, "missing.txt") and not pcall(io.open, "in.txt", "rw"))
+local tmp = io.tmpfile()
+tmp:write("tmp")
+tmp:seek("set")
+assert(tmp:read("*a") == "tmp")
+io.output("log.txt")
+io.write("logged")
+io.close()
+io.output(io.stdout)
+io.input("log.txt")
+assert(io.read("*l") == "logged")
+`)
+	if out.String() != "n=1 2.5\n" {
+		t.Errorf("stdout: got %q", out.String())
+	}
+	if data, ok := mfs.ReadFile("out.txt"); !ok || string(data) != "x2\ny\nz" {
+		t.Errorf("out.txt: got %q", data)
+	}
+
+	rofs := stdlib.ReadOnlyFileSystem(fstest.MapFS{"data/a.txt": {Data: []byte("ro")}})
+	runLuaIO(t, stdlib.IOConfig{FS: rofs}, `
+assert(io.open("/data/a.txt"):read("*a") == "ro")
+assert(io.open("data/a.txt", "w") == nil and io.open("data/b.txt") == nil)
+assert(io.write("x") == nil and select(2, io.open("a.txt", "w")) == "a.txt: permission denied")
+`)
+	runLuaIO(t, stdlib.IOConfig{}, `assert(io.open("in.txt") == nil and io.read() == nil)`)
+
+	mfs = stdlib.NewMemFileSystem()
+	mfs.WriteFile("lib.lua", []byte("#!/usr/bin/lua\nprint('lib', ...)\nreturn 42"))
+	out.Reset()
+	runLuaIO(t, stdlib.IOConfig{FS: mfs, Stdout: &out}, `
+print("a", 1, nil)
+assert(dofile("lib.lua") == 42 and loadfile("lib.lua")("x") == 42)
+assert(loadfile("stdlib_test.go") == nil and not pcall(dofile, "stdlib_test.go"))
+`)
+	if out.String() != "a\t1\tnil\nlib\nlib\tx\n" {
+		t.Errorf("print: got %q", out.String())
+	}
+	runLuaIO(t, stdlib.IOConfig{FS: mfs}, `print("discarded")`)
+}
+
+func TestOSLib(t *testing.T) {
+	runLua(t, `
+assert(os.date("!%Y-%m-%d %H:%M:%S %a %b %j %p %I", 0) == "1970-01-01 00:00:00 Thu Jan 001 AM 12")
+assert(os.date("!%c|%x|%X|%%|%U %W %w", 86400 * 3) == "Sun Jan  4 00:00:00 1970|01/04/70|00:00:00|%|01 00 0")
+local d = os.date("!*t", 86400 * 365)
+assert(d.year == 1971 and d.month == 1 and d.day == 1 and d.hour == 0 and d.wday == 6 and d.yday == 1)
+local t = os.time({year = 2000, month = 1, day = 1})
+assert(os.date("*t", t).hour == 12 and os.time({year = 2000, month = 13, day = 1}) == os.time({year = 2001, month = 1, day = 1}))
+local ok, msg = pcall(os.time, {year = 2000})
+assert(not ok and msg == "field 'day' missing in date table")
+assert(os.difftime(t + 60, t) == 60 and os.clock() >= 0 and os.setlocale() == "C" and os.setlocale("xx") == nil)
+`)
+
+	mfs := stdlib.NewMemFileSystem()
+	mfs.WriteFile("a.txt", []byte("a"))
+	ls := state.New()
+	ls.RequireF("os", stdlib.NewOSLib(stdlib.OSConfig{FS: mfs, Exit: true, Remove: true, Rename: true, TmpName: true}), true)
+	ls.Pop(1)
+	ls.OpenLibs()
+	source := `
+assert(type(os.time()) == "number")
+local ok, msg = pcall(os.getenv, "HOME")
+assert(not ok and msg == "'os.getenv' is not allowed" and not pcall(os.execute, "echo"))
+assert(os.rename("a.txt", "b.txt") and select(2, os.remove("a.txt")) == "a.txt: file does not exist")
+local name = os.tmpname()
+assert(os.remove(name) and os.remove("b.txt"))
+pcall(os.exit, 3)
+error("not exited")
+`
+	if ls.Load([]byte(source), "=test", "t") != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	func() {
+		defer func() {
+			if e, ok := recover().(*ExitError); !ok || e.Code != 3 {
+				t.Errorf("os.exit(3): got %v", e)
+			}
+		}()
+		ls.PCall(0, 0, 0)
+		t.Error("os.exit(3) returns: " + ls.ToString(-1))
+	}()
+	if _, ok := mfs.ReadFile("b.txt"); ok {
+		t.Error("b.txt is not removed")
+	}
+	if ls.GetTop() != 0 {
+		t.Errorf("top after os.exit: got %d", ls.GetTop())
+	}
+	for i := 0; i < 10000; i++ { /* the calls unwound by os.exit do not overflow the stack */
+		func() {
+			defer func() { recover() }()
+			ls.Load([]byte("pcall(os.exit, 1)"), "=exit", "t")
+			ls.PCall(0, 0, 0)
+		}()
+	}
+	if ls.Load([]byte("return coroutine.wrap(function() return 1 + 1 end)()"), "=reuse", "t") != LUA_OK ||
+		ls.PCall(0, 1, 0) != LUA_OK || ls.ToInteger(-1) != 2 {
+		t.Fatal("the state is not reusable after os.exit: " + ls.ToString(-1))
+	}
+	ls.Pop(1)
+
+	/* os.execute runs the command with the streams of the io library */
+	var out bytes.Buffer
+	ex := state.New()
+	ex.RequireF("io", stdlib.NewIOLib(stdlib.IOConfig{Stdin: strings.NewReader("in\n"), Stdout: &out}), true)
+	ex.RequireF("os", stdlib.NewOSLib(stdlib.OSConfig{Execute: true}), true)
+	ex.Pop(2)
+	ex.OpenLibs()
+	if ex.Load([]byte(`assert(os.execute("read x; echo $x out; echo err >&2") == 0)`), "=execute", "t") != LUA_OK ||
+		ex.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ex.ToString(-1))
+	}
+	if out.String() != "in out\n" {
+		t.Errorf("os.execute: got %q", out.String())
+	}
+
+	co := ls.NewThread()
+	co.Load([]byte("os.exit(5, 'in a coroutine')"), "=co", "t")
+	func() {
+		defer func() {
+			if e, ok := recover().(*ExitError); !ok || e.Code != 5 {
+				t.Errorf("os.exit(5) in a coroutine: got %v", e)
+			}
+		}()
+		co.Resume(ls, 0)
+	}()
+	if co.GetTop() != 0 || co.Status() != LUA_ERRRUN || ls.GetTop() != 1 {
+		t.Errorf("coroutine after os.exit: top %d, status %d", co.GetTop(), co.Status())
+	}
+}
+
+func TestPackageLib(t *testing.T) {
+	mfs := stdlib.NewMemFileSystem()
+	mfs.WriteFile("lib/a/b.lua", []byte("#!/usr/bin/lua\nreturn {name = ...}"))
+	mfs.WriteFile("lib/old.lua", []byte(`module("old.style", package.seeall) function hello() return _NAME end`))
+	mfs.WriteFile("lib/cyc1.lua", []byte(`require "cyc2"`))
+	mfs.WriteFile("lib/cyc2.lua", []byte(`require "cyc1"`))
+	mfs.WriteFile("lib/err.lua", []byte(`error("fails")`))
+	mfs.WriteFile("lib/bad.lua", []byte(`x = = 1`))
+	ls := state.New()
+	ls.RequireF("package", stdlib.NewPackageLib(stdlib.PackageConfig{FS: mfs, Path: "lib/?.lua"}), true)
+	ls.Pop(1)
+	ls.OpenLibs()
+	if ls.Load([]byte(`
+assert(package.path == "lib/?.lua" and package.loaded._G == _G and package.loaded.package == package)
+local b = require "a.b"
+assert(b.name == "a.b" and require("a.b") == b and package.loaded["a.b"] == b)
+package.preload.pre = function(name) return "preloaded " .. name end
+assert(require "pre" == "preloaded pre")
+table.insert(package.loaders, 2, function(name)
+  if name == "virt" then return function() end end
+  return "\n\tno virtual '" .. name .. "'"
+end)
+assert(require "virt" == true and package.loaded.virt == true)
+require "old"
+assert(old.style.hello() == "old.style" and old.style._PACKAGE == "old." and old.style._M == old.style)
+local ok, msg = pcall(require, "cyc1")
+assert(not ok and msg == "lib/cyc2.lua:1: loop loading module 'cyc1': cyc1 -> cyc2 -> cyc1", msg)
+ok, msg = pcall(require, "cyc1")
+assert(not ok and msg == "previous error loading module 'cyc1'", msg)
+ok, msg = pcall(require, "err")
+assert(not ok and msg == "lib/err.lua:1: fails", msg)
+ok, msg = pcall(require, "bad")
+assert(not ok and msg:find("^error loading module 'bad' from file 'lib/bad.lua':\n\t"), msg)
+ok, msg = pcall(require, "nope")
+assert(not ok and msg == "module 'nope' not found:\n\tno field package.preload['nope']" ..
+  "\n\tno virtual 'nope'\n\tno file 'lib/nope.lua'" ..
+  "\n\tno file './nope.so'\n\tno file '/usr/local/lib/lua/5.1/nope.so'\n\tno file '/usr/local/lib/lua/5.1/loadall.so'", msg)
+assert(select("#", package.loadlib("x", "y")) == 3)
+`), "=test", "t") != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+}
+
+func TestCoroutineLib(t *testing.T) {
+	runLua(t, `
+local co = coroutine.create(function(a, b)
+  assert(coroutine.status(coroutine.running()) == "running")
+  local c = coroutine.yield(a + b)
+  local d, e = coroutine.yield(c * 2)
+  return d + e
+end)
+assert(coroutine.status(co) == "suspended")
+local ok, v = coroutine.resume(co, 1, 2)
+assert(ok and v == 3 and coroutine.status(co) == "suspended")
+ok, v = coroutine.resume(co, 10)
+assert(ok and v == 20)
+ok, v = coroutine.resume(co, 3, 4)
+assert(ok and v == 7 and coroutine.status(co) == "dead")
+local msg
+ok, msg = coroutine.resume(co)
+assert(not ok and msg == "cannot resume dead coroutine")
+
+assert(coroutine.running() == nil)
+ok, msg = pcall(coroutine.yield, 1)
+assert(not ok and msg == "attempt to yield from outside a coroutine")
+
+local gen = coroutine.wrap(function() for i = 1, 3 do coroutine.yield(i) end end)
+assert(gen() == 1 and gen() == 2 and gen() == 3)
+ok, msg = pcall(coroutine.wrap(function() error("oops") end))
+assert(not ok and msg == "test:25: oops")
+local err = {}
+ok, msg = coroutine.resume(coroutine.create(function() error(err) end))
+assert(not ok and msg == err)
+
+local outer
+outer = coroutine.create(function()
+  local inner = coroutine.create(function() return coroutine.status(outer) end)
+  return coroutine.resume(inner)
+end)
+local ok1, ok2, status = coroutine.resume(outer)
+assert(ok1 and ok2 and status == "normal")
+assert(not pcall(coroutine.create, print) and not pcall(coroutine.resume, {}))
+
+co = coroutine.wrap(function(a)
+  local ok, v = pcall(function() return coroutine.yield(a) * 2 end)
+  assert(ok and v == 42)
+  local ok2, e = pcall(function() coroutine.yield("again") error("boom") end)
+  coroutine.yield(ok2, e)
+  local function iter(_, i) if i < 2 then coroutine.yield("it" .. i) return i + 1 end end
+  for i in iter, nil, 0 do end
+  return "done"
+end)
+assert(co(1) == 1 and co(21) == "again")
+ok, msg = co()
+assert(not ok and msg == "test:43: boom")
+assert(co() == "it0" and co() == "it1" and co() == "done")
+ok, msg = coroutine.resume(coroutine.create(function()
+  table.sort({3, 2, 1}, function(a, b) coroutine.yield() return a < b end)
+end))
+assert(not ok and msg == "attempt to yield across metamethod/C-call boundary")
+local function depth(n) if n == 0 then return 0 end return 1 + depth(n - 1) end
+assert(depth(10000) == 10000)
+local function forever() return 1 + forever() end
+ok, msg = pcall(forever)
+assert(not ok and msg == "test:59: stack overflow")
+`)
+}
+
+func TestCoroutineContinuation(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	// call the argument, the lua function yields across the go function
+	ls.Register("callk", func(ls LuaState) int {
+		ls.CallK(0, 1, 10, func(ls LuaState, status, ctx int) int {
+			ls.PushInteger(int64(status*100 + ctx))
+			return 2
+		})
+		return 1
+	})
+	// yield the argument, k gets the values of resume after the ones under the argument
+	ls.Register("yieldk", func(ls LuaState) int {
+		ls.PushString("kept")
+		ls.Insert(1)
+		return ls.YieldK(1, 0, func(ls LuaState, status, ctx int) int {
+			return ls.GetTop()
+		})
+	})
+	goroutines := runtime.NumGoroutine()
+	if ls.Load([]byte(`
+local co = coroutine.wrap(function()
+  local r, s = callk(function() return coroutine.yield(1) + 1 end)
+  assert(r == 3 and s == 110)
+  local a, b, c = yieldk("y")
+  assert(a == "kept" and b == "r1" and c == "r2")
+  return "done"
+end)
+assert(co() == 1 and co(2) == "y" and co("r1", "r2") == "done")
+local cos = {}
+for i = 1, 10000 do
+  cos[i] = coroutine.create(function() coroutine.yield(i) end)
+  coroutine.resume(cos[i])
+end
+`), "=test", "t") != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("suspended coroutines: %d goroutines, %d before", n, goroutines)
+	}
+}
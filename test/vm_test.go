@@ -1,9 +1,17 @@
 package test
 
 import (
+	"bytes"
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"goluar/compiler"
+	"goluar/stdlib"
 	state "goluar/vm"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -12,7 +20,628 @@ func TestVM(t *testing.T) {
 	if err != nil {
 		panic(err)
 	}
+	var out bytes.Buffer
 	ls := state.New()
+	ls.RequireF("io", stdlib.NewIOLib(stdlib.IOConfig{Stdout: &out}), true)
+	ls.Pop(1)
+	ls.OpenLibs()
 	ls.Load(data, os.Args[1], "b")
 	ls.Call(0, 0)
+	if want := "Hello world, from 5.1 !\n"; out.String() != want {
+		t.Errorf("output: got %q, want %q", out.String(), want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data, err := ioutil.ReadFile("lua/list_summary.lua")
+	if err != nil {
+		panic(err)
+	}
+	if err := state.Verify(compiler.Compile(string(data), "@list_summary.lua")); err != nil {
+		t.Errorf("compiled chunk is rejected: %v", err)
+	}
+
+	iABC := func(op, a, b, c int) uint32 { return uint32(b<<23 | c<<14 | a<<6 | op) }
+	iABx := func(op, a, bx int) uint32 { return uint32(bx<<14 | a<<6 | op) }
+	iAsBx := func(op, a, sBx int) uint32 { return iABx(op, a, sBx+MAXARG_sBx) }
+	ret := iABC(OP_RETURN, 0, 1, 0)
+	cases := []struct {
+		name string
+		code []uint32
+	}{
+		{"unknown opcode", []uint32{iABC(63, 0, 0, 0), ret}},
+		{"register out of range", []uint32{iABC(OP_MOVE, 2, 0, 0), ret}},
+		{"constant out of range", []uint32{iABx(OP_LOADK, 0, 1), ret}},
+		{"RK constant out of range", []uint32{iABC(OP_ADD, 0, 0x100|1, 0), ret}},
+		{"jump out of range", []uint32{iAsBx(OP_JMP, 0, 5), ret}},
+		{"jump backward out of range", []uint32{iAsBx(OP_JMP, 0, -3), ret}},
+		{"closure out of range", []uint32{iABx(OP_CLOSURE, 0, 1), ret}},
+		{"upvalue out of range", []uint32{iABC(OP_GETUPVAL, 0, 0, 0), ret}},
+		{"test without jump", []uint32{iABC(OP_EQ, 0, 0, 1), ret, ret}},
+		{"no return", []uint32{iABC(OP_MOVE, 0, 1, 0)}},
+		{"table size too big", []uint32{iABC(OP_NEWTABLE, 0, 511, 0), ret}},
+		{"table hash size too big", []uint32{iABC(OP_NEWTABLE, 0, 0, 0xF8), ret}},
+	}
+	for _, c := range cases {
+		proto := &FuncProto{
+			MaxStackSize: 2,
+			Instructions: c.code,
+			Constants:    []interface{}{"k"},
+		}
+		if state.Verify(proto) == nil {
+			t.Errorf("%s: bad code is accepted", c.name)
+		}
+	}
+
+	valid := &FuncProto{MaxStackSize: 2, Instructions: []uint32{iABC(OP_MOVE, 0, 1, 0), ret}}
+	if err := state.Verify(valid); err != nil {
+		t.Errorf("valid code is rejected: %v", err)
+	}
+	// a sub function with bad code
+	bad := &FuncProto{MaxStackSize: 2, Instructions: []uint32{iABx(OP_LOADK, 0, 0), ret}}
+	valid.Protos = []*FuncProto{bad}
+	if state.Verify(valid) == nil {
+		t.Errorf("bad code in sub function is accepted")
+	}
+	// a closure without the pseudo instruction of its upvalue
+	sub := &FuncProto{UpvalueCount: 1, Upvalues: []Upvalue{{Instack: 1, Idx: 0}}, MaxStackSize: 2, Instructions: []uint32{ret}}
+	parent := &FuncProto{MaxStackSize: 2, Instructions: []uint32{iABx(OP_CLOSURE, 0, 0), ret}, Protos: []*FuncProto{sub}}
+	if state.Verify(parent) == nil {
+		t.Errorf("closure without upvalue is accepted")
+	}
+	parent.Instructions = []uint32{iABx(OP_CLOSURE, 0, 0), iABC(OP_MOVE, 0, 0, 0), ret}
+	if err := state.Verify(parent); err != nil {
+		t.Errorf("closure with upvalue is rejected: %v", err)
+	}
+
+	ls := state.New()
+	chunk := DumpBinaryChunk(valid, false)
+	if ls.Load(chunk, "=chunk", "b") != LUA_OK {
+		t.Errorf("Load without 'v' should not verify the chunk")
+	}
+	if ls.Load(chunk, "=chunk", "bv") != LUA_ERRSYNTAX {
+		t.Errorf("Load with 'v' should verify the chunk")
+	}
+	if ls.Load(chunk, "=chunk", "t") != LUA_ERRSYNTAX {
+		t.Errorf("binary chunk is loaded in mode 't'")
+	}
+	if ls.Load([]byte("return 1"), "=chunk", "b") != LUA_ERRSYNTAX {
+		t.Errorf("text chunk is loaded in mode 'b'")
+	}
+	// the size hints of an unchecked NEWTABLE are not allocated
+	huge := &FuncProto{Source: "=huge", MaxStackSize: 2, Instructions: []uint32{iABC(OP_NEWTABLE, 0, 511, 511), iABC(OP_RETURN, 0, 2, 0)}, LineInfo: []uint32{1, 1}}
+	if ls.Load(DumpBinaryChunk(huge, false), "=huge", "b") != LUA_OK {
+		t.Fatalf("Load of the huge table fails: %v", ls.ToString(-1))
+	}
+	if ls.PCall(0, 1, 0) != LUA_ERRRUN || ls.ToString(-1) != "huge:1: table overflow" {
+		t.Errorf("unexpected result %q", ls.ToString(-1))
+	}
+}
+
+func TestClosure(t *testing.T) {
+	source := `
+local function counter()
+  local n = 0
+  local function inc() n = n + 1 return n end
+  local function get() return n end
+  return inc, get
+end
+local inc, get = counter()
+inc() inc()
+local fs = {}
+for i = 1, 3 do
+  local j = i * 10
+  fs[i] = function() return i + j end
+end
+local k = 0
+while true do
+  local x = k
+  fs[#fs + 1] = function() return x end
+  k = k + 1
+  if k == 2 then break end
+end
+local r = 0
+repeat
+  local y = r
+  fs[#fs + 1] = function() return y end
+  r = r + 1
+until r >= 2
+return get(), inc(), fs[1](), fs[2](), fs[3](), fs[4](), fs[5](), fs[6](), fs[7]()`
+	expected := []int64{2, 3, 11, 22, 33, 0, 1, 0, 1}
+
+	proto := compiler.Compile(source, "=closure")
+	chunks := map[string][]byte{
+		"source": []byte(source),
+		"binary": DumpBinaryChunk(proto, false),
+	}
+	for name, chunk := range chunks {
+		ls := state.New()
+		if ls.Load(chunk, "=closure", "btv") != LUA_OK {
+			t.Fatalf("%s: %s", name, ls.ToString(-1))
+		}
+		ls.Call(0, LUA_MULTRET)
+		if ls.GetTop() != len(expected) {
+			t.Fatalf("%s: %d results, expected %d", name, ls.GetTop(), len(expected))
+		}
+		for i, v := range expected {
+			if got := ls.ToInteger(i + 1); got != v {
+				t.Errorf("%s: result %d is %d, expected %d", name, i+1, got, v)
+			}
+		}
+	}
+}
+
+func TestFenv(t *testing.T) {
+	source := `
+x = 1
+local env = {x = 42}
+local function f() return x end
+setfenv(f, env)
+local function g() setfenv(1, {y = 7}) return y end
+local function h() return function() return x end end
+setfenv(h, env)
+return f(), g(), h()(), getfenv(f) == env, getfenv(0) == _G and getfenv() == _G, x`
+
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.Load([]byte(source), "=fenv", "t") != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.Call(0, LUA_MULTRET)
+	expected := []interface{}{int64(42), int64(7), int64(42), true, true, int64(1)}
+	if ls.GetTop() != len(expected) {
+		t.Fatalf("%d results, expected %d", ls.GetTop(), len(expected))
+	}
+	for i, v := range expected {
+		var got interface{}
+		if ls.IsBoolean(i + 1) {
+			got = ls.ToBoolean(i + 1)
+		} else {
+			got = ls.ToInteger(i + 1)
+		}
+		if got != v {
+			t.Errorf("result %d is %v, expected %v", i+1, got, v)
+		}
+	}
+
+	ls.SetTop(0)
+	ls.Load([]byte(`setfenv(getfenv, {})`), "=fenv", "t")
+	if ls.PCall(0, 0, 0) == LUA_OK {
+		t.Errorf("setfenv should not change the environment of a Go function")
+	}
+}
+
+type account struct {
+	owner   string
+	balance int64
+}
+
+func TestUserData(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	if !ls.NewMetatable("Account") || ls.NewMetatable("Account") {
+		t.Fatal("NewMetatable should create the metatable only once")
+	}
+	ls.Pop(1)
+	ls.PushValue(-1)
+	ls.SetField(-2, "__index") /* methods in the metatable itself */
+	ls.SetFuncs(FuncReg{
+		"deposit": func(ls LuaState) int {
+			a := ls.CheckUdata(1, "Account").(*account)
+			a.balance += ls.CheckInteger(2)
+			return 0
+		},
+		"balance": func(ls LuaState) int {
+			ls.PushInteger(ls.CheckUdata(1, "Account").(*account).balance)
+			return 1
+		},
+		"__eq": func(ls LuaState) int {
+			a, b := ls.TestUdata(1, "Account").(*account), ls.TestUdata(2, "Account").(*account)
+			ls.PushBoolean(a != nil && b != nil && a.owner == b.owner)
+			return 1
+		},
+	}, 0)
+	ls.Pop(1)
+
+	acc := &account{owner: "ann"}
+	ls.NewUserData(acc)
+	ls.SetMetatableByName("Account")
+	ls.SetGlobal("acc")
+	ls.NewUserData(&account{owner: "ann"})
+	ls.SetMetatableByName("Account")
+	ls.SetGlobal("same")
+	ls.PushLightUserData(acc)
+	ls.SetGlobal("light")
+	ls.PushLightUserData(acc)
+	ls.SetGlobal("light2")
+
+	if ls.Load([]byte(`
+acc:deposit(10)
+acc:deposit(5)
+assert(acc:balance() == 15)
+assert(acc == same and rawequal(acc, acc) and not rawequal(acc, same))
+assert(light == light2 and type(light) == "userdata")
+local t = {[light] = 1}
+assert(t[light2] == 1)
+assert(not pcall(acc.deposit, light, 1))
+local ok, err = pcall(acc.balance, io.stdout)
+assert(err == "bad argument #1 to '?' (Account expected, got FILE*)", err)
+`), "=ud", "t") != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	if acc.balance != 15 {
+		t.Errorf("balance %d, expected 15", acc.balance)
+	}
+
+	ls.GetGlobal("light")
+	if !ls.IsLightUserData(-1) || !ls.IsUserData(-1) || ls.ToUserData(-1) != acc {
+		t.Errorf("light userdata does not hold the go value")
+	}
+	ls.GetGlobal("acc")
+	ls.GetFenv(-1)
+	ls.PushGlobalTable()
+	if !ls.RawEqual(-1, -2) {
+		t.Errorf("the environment of a new userdata should be the globals")
+	}
+	ls.Pop(2)
+	ls.NewTable()
+	ls.PushValue(-1)
+	if !ls.SetFenv(-3) {
+		t.Errorf("SetFenv should set the environment of a userdata")
+	}
+	ls.GetFenv(-2)
+	if !ls.RawEqual(-1, -2) {
+		t.Errorf("the environment of the userdata was not set")
+	}
+}
+
+type point struct{ X, Y int }
+
+type shape struct {
+	Name   string
+	Pos    point
+	Tags   []string
+	Props  map[string]float64
+	hidden int
+}
+
+func (s *shape) Move(dx, dy int) *shape {
+	s.Pos.X += dx
+	s.Pos.Y += dy
+	return s
+}
+
+func (s *shape) Rename(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	s.Name = name
+	return nil
+}
+
+func (s shape) String() string { return "shape " + s.Name }
+
+func TestGoValue(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	s := &shape{Name: "box", Tags: []string{"a", "b"}, Props: map[string]float64{"w": 2}}
+	ls.PushGoValue(s)
+	ls.SetGlobal("s")
+	ls.PushGoValue(func(a int, rest ...string) (int, string) {
+		return a * 2, strings.Join(rest, ",")
+	})
+	ls.SetGlobal("f")
+	ls.PushGoValue(uint8(7))
+	ls.SetGlobal("n")
+	ls.PushGoValue(^uint64(0))
+	ls.SetGlobal("big")
+
+	if ls.Load([]byte(`
+assert(s.Name == "box" and s.hidden == nil and n == 7)
+assert(s:Move(1, 2) == s and s.Pos.X == 1 and s.Pos.Y == 2)
+s.Pos.X = 10
+s.Tags[2] = "c"
+assert(#s.Tags == 2 and s.Tags[1] == "a" and s.Tags[3] == nil)
+s.Props.h = 3
+s.Props.w = nil
+assert(s.Props.h == 3 and s.Props.w == nil and #s.Props == 1)
+assert(tostring(s) == "shape box")
+local a, b = f(21, "x", "y")
+assert(a == 42 and b == "x,y")
+local ok, err = pcall(s.Move, s, "right", 1)
+assert(err == "bad argument #2 to '?' (int expected, got string)", err)
+ok, err = pcall(function() s:Move(1, {}) end)
+assert(err == "test:15: bad argument #2 to 'Move' (int expected, got table)", err)
+ok, err = pcall(function() s:Rename("") end)
+assert(err == "test:17: empty name", err)
+ok, err = pcall(function() s.Name = {} end)
+assert(err == "test:19: string expected for field 'Name', got table", err)
+ok, err = pcall(function() s.Size = 1 end)
+assert(err == "test:21: no field 'Size' in test.shape", err)
+assert(not pcall(s.Move, 1, 2, 3))
+assert(big == 2^64 and big > 0)
+ok, err = pcall(s.Move)
+assert(err == "bad argument #1 to '?' (*test.shape expected, got no value)", err)
+ok, err = pcall(function() s.Rename(nil, "x") end)
+assert(err == "test:27: bad argument #1 to 'Rename' (*test.shape expected, got nil)", err)
+assert(not pcall(s.Move, {}, 1, 2) and not pcall(s.Move, n, 1, 2))
+s:Rename("ball")
+`), "=test", "t") != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	if s.Name != "ball" || s.Pos.X != 10 || s.Tags[1] != "c" || s.Props["h"] != 3 {
+		t.Errorf("the go value was not changed by lua: %+v", *s)
+	}
+}
+
+type server struct {
+	Host  string `lua:"host"`
+	Port  int    `lua:"port,omitempty"`
+	Debug bool   `lua:"-"`
+}
+
+type config struct {
+	Name    string
+	Servers []*server         `lua:"servers"`
+	Limits  map[string]uint16 `lua:"limits"`
+	Extra   interface{}       `lua:"extra"`
+	Key     []byte            `lua:"key"`
+}
+
+func TestMarshal(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	if ls.Load([]byte(`
+return {
+  Name = "prod",
+  servers = {{host = "a", port = 80}, {host = "b"}},
+  limits = {conn = 100},
+  extra = {1, "two", {x = 3}},
+  key = "k1",
+}`), "=config", "t") != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.Call(0, 1)
+	var c config
+	if err := ls.Unmarshal(-1, &c); err != nil {
+		t.Fatal(err)
+	}
+	extra, _ := c.Extra.([]interface{})
+	if c.Name != "prod" || len(c.Servers) != 2 || *c.Servers[0] != (server{"a", 80, false}) ||
+		c.Servers[1].Host != "b" || c.Limits["conn"] != 100 || string(c.Key) != "k1" ||
+		len(extra) != 3 || extra[0] != int64(1) || extra[1] != "two" ||
+		extra[2].(map[string]interface{})["x"] != int64(3) {
+		t.Errorf("unmarshal: %+v", c)
+	}
+
+	ls.SetTop(0)
+	if err := ls.Marshal(&c); err != nil {
+		t.Fatal(err)
+	}
+	ls.SetGlobal("c")
+	if ls.Load([]byte(`
+assert(c.Name == "prod" and c.servers[1].host == "a" and c.servers[1].port == 80)
+assert(c.servers[2].port == nil and c.servers[2].Debug == nil and #c.servers == 2)
+assert(c.limits.conn == 100 and c.extra[3].x == 3 and c.key == "k1")
+c.servers[1].port = "http"
+c.limits.conn = -1
+`), "=test", "t") != LUA_OK || ls.PCall(0, 0, 0) != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+
+	ls.GetGlobal("c")
+	err := ls.Unmarshal(-1, &c)
+	if err == nil || err.Error() != "servers[1].port: int expected, got string" {
+		t.Errorf("unmarshal error: %v", err)
+	}
+	if ls.GetTop() != 1 {
+		t.Errorf("unmarshal left %d values on the stack", ls.GetTop())
+	}
+	var n int
+	if err := ls.Unmarshal(-1, n); err == nil {
+		t.Errorf("unmarshal into a non-pointer should fail")
+	}
+	ls.DoString("cyclic = {}; cyclic.self = cyclic")
+	ls.GetGlobal("cyclic")
+	var m map[string]interface{}
+	if err := ls.Unmarshal(-1, &m); err == nil || err.Error() != "self: cycle of the table" {
+		t.Errorf("unmarshal cycle: %v", err)
+	}
+
+	ls.SetTop(0)
+	ls.DoString(`numbered = {[1] = "a", [2] = "b", x = "c"}`)
+	ls.GetGlobal("numbered")
+	var sm map[string]string
+	if err := ls.Unmarshal(-1, &sm); err != nil || len(sm) != 3 || sm["1"] != "a" || sm["2"] != "b" || sm["x"] != "c" {
+		t.Errorf("unmarshal number keys: %v %v", sm, err)
+	}
+	if ls.Type(-1) != LUA_TTABLE || ls.GetTop() != 1 {
+		t.Errorf("unmarshal changed the stack")
+	}
+	ls.Load([]byte(`return setmetatable({}, {__index = function(t, k) error("no " .. k) end})`), "=meta", "t")
+	ls.Call(0, 1)
+	var s struct{ Name string }
+	if err := ls.Unmarshal(-1, &s); err == nil || err.Error() != "meta:1: no Name" {
+		t.Errorf("unmarshal lua error: %v", err)
+	}
+	if ls.GetTop() != 2 {
+		t.Errorf("unmarshal left %d values on the stack", ls.GetTop())
+	}
+}
+
+func TestTable(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	r := rand.New(rand.NewSource(1))
+	expected := map[interface{}]int64{}
+	ls.NewTable()
+	for i := int64(1); i <= 50000; i++ {
+		var k interface{}
+		switch r.Intn(3) {
+		case 0:
+			k = int64(r.Intn(2000) - 100)
+			ls.PushInteger(k.(int64))
+		case 1:
+			k = fmt.Sprintf("k%d", r.Intn(1000))
+			ls.PushString(k.(string))
+		default:
+			k = float64(r.Intn(1000)) + 0.5
+			ls.PushNumber(k.(float64))
+		}
+		if r.Intn(3) == 0 {
+			delete(expected, k)
+			ls.PushNil()
+		} else {
+			expected[k] = i
+			ls.PushInteger(i)
+		}
+		ls.RawSet(-3)
+	}
+
+	n := 0
+	ls.PushNil()
+	for ls.Next(-2) {
+		var k interface{}
+		if ls.IsInteger(-2) {
+			k = ls.ToInteger(-2)
+		} else if ls.Type(-2) == LUA_TNUMBER {
+			k = ls.ToNumber(-2)
+		} else {
+			k = ls.ToString(-2)
+		}
+		if v := ls.ToInteger(-1); expected[k] != v {
+			t.Fatalf("t[%v] is %d, expected %d", k, v, expected[k])
+		}
+		n++
+		ls.Pop(1)
+		ls.PushValue(-1)
+		ls.PushNil()
+		ls.RawSet(-4) /* clear the field during the traversal */
+	}
+	if n != len(expected) {
+		t.Errorf("%d fields, expected %d", n, len(expected))
+	}
+	ls.PushNil()
+	if ls.Next(-2) {
+		t.Errorf("the table should be empty")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct{ source, msg string }{
+		{`foo()`, `[string "foo()"]:1: attempt to call a nil value (global 'foo')`},
+		{"local t\nreturn t.x", `[string "local t..."]:2: attempt to index a nil value (local 't')`},
+		{`local t = {} t.a.b = 1`, `[string "local t = {} t.a.b = 1"]:1: attempt to index a nil value (field 'a')`},
+		{`local t = {} t:m()`, `[string "local t = {} t:m()"]:1: attempt to call a nil value (method 'm')`},
+		{`return {} .. "x"`, `[string "return {} .. "x""]:1: attempt to concatenate a table value`},
+		{`return 1 < "2"`, `[string "return 1 < "2""]:1: attempt to compare number with string`},
+		{`return {} < {}`, `[string "return {} < {}"]:1: attempt to compare two table values`},
+		{`local x = 1 return x + nil`, `[string "local x = 1 return x + nil"]:1: attempt to perform arithmetic on a nil value`},
+		{`for i = 1, "x" do end`, `[string "for i = 1, "x" do end"]:1: 'for' limit must be a number`},
+		{`return #5`, `[string "return #5"]:1: attempt to get length of a number value`},
+		{`local t = {} t[nil] = 1`, `[string "local t = {} t[nil] = 1"]:1: table index is nil`},
+		{`local t = {1} t[0/0] = 1`, `[string "local t = {1} t[0/0] = 1"]:1: table index is NaN`},
+		{`rawset({}, nil, 1)`, `table index is nil`},
+		{`next({}, "x")`, `invalid key to 'next'`},
+	}
+	ls := state.New()
+	ls.OpenLibs()
+	for _, test := range tests {
+		ls.SetTop(0)
+		if ls.Load([]byte(test.source), test.source, "t") != LUA_OK {
+			t.Fatal(ls.ToString(-1))
+		}
+		if ls.PCall(0, 0, 0) != LUA_ERRRUN {
+			t.Errorf("%s: no error", test.source)
+		} else if msg := ls.ToString(-1); msg != test.msg {
+			t.Errorf("%s: error is %q, expected %q", test.source, msg, test.msg)
+		}
+	}
+
+	ls.SetTop(0)
+	ls.Register("trace", func(ls LuaState) int {
+		ls.Traceback(ls, "msg", 1)
+		return 1
+	})
+	source := `local function f()
+  return trace()
+end
+function g() return (f()) end
+return g()`
+	if ls.Load([]byte(source), "@tb.lua", "t") != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	ls.Call(0, 1)
+	expected := "msg\nstack traceback:\n\ttb.lua:2: in function 'f'\n\ttb.lua:4: in function 'g'\n\ttb.lua:5: in main chunk"
+	if msg := ls.ToString(-1); msg != expected {
+		t.Errorf("traceback is %q, expected %q", msg, expected)
+	}
+}
+
+func TestXPCall(t *testing.T) {
+	ls := state.New()
+	ls.OpenLibs()
+	traceback := func(ls LuaState) int {
+		ls.Traceback(ls, ls.ToString(1), 1)
+		return 1
+	}
+
+	source := `local function f()
+  local t = nil
+  return t.x
+end
+f()`
+	if ls.Load([]byte(source), "@xp.lua", "t") != LUA_OK {
+		t.Fatal(ls.ToString(-1))
+	}
+	if ls.XPCall(0, 0, traceback) != LUA_ERRRUN {
+		t.Fatal("no error")
+	}
+	expected := "xp.lua:3: attempt to index a nil value (local 't')\nstack traceback:\n\txp.lua:3: in function 'f'\n\txp.lua:5: in main chunk"
+	if msg := ls.ToString(-1); msg != expected || ls.GetTop() != 1 {
+		t.Errorf("error is %q, expected %q", msg, expected)
+	}
+
+	ls.SetTop(0)
+	ls.Register("handler", func(ls LuaState) int {
+		ls.PushString("handled: " + ls.ToString(1))
+		return 1
+	})
+	source = `local ok, msg = xpcall(function() local t t.x = 1 end, handler)
+local _, a, b = xpcall(function() return 1, 2 end, handler)
+return ok, msg, a, b, xpcall(function() local t t.x = 1 end, function() local t t.y = 2 end)`
+	ls.Load([]byte(source), "=xpcall", "t")
+	ls.Call(0, LUA_MULTRET)
+	results := []string{"false", "handled: xpcall:1: attempt to index a nil value (local 't')", "1", "2", "false", "error in error handling"}
+	if ls.GetTop() != len(results) {
+		t.Fatalf("%d results, expected %d", ls.GetTop(), len(results))
+	}
+	for i, r := range results {
+		if s := ls.ToString2(i + 1); s != r {
+			t.Errorf("result %d is %q, expected %q", i+1, s, r)
+		}
+		ls.Pop(1)
+	}
+
+	ls.SetTop(0)
+	source = `local function f() return 1 + f() end
+local t = setmetatable({}, {__index = function(t, k) return t[k] end})
+local function g() return t.x end
+local function loop() local function h() return 1 + h() end return h() end
+local ok, msg = xpcall(f, handler)
+assert(not ok and msg == "handled: so:1: stack overflow", msg)
+ok, msg = xpcall(g, handler)
+assert(not ok and msg == "handled: so:2: stack overflow", msg)
+ok, msg = xpcall(f, loop)
+assert(not ok and msg == "error in error handling", msg)
+ok, msg = xpcall(f, handler)
+assert(not ok and msg == "handled: so:1: stack overflow", msg)
+f()`
+	ls.Load([]byte(source), "=so", "t")
+	if ls.XPCall(0, 0, traceback) != LUA_ERRRUN {
+		t.Fatal("no error")
+	}
+	if msg := ls.ToString(-1); !strings.HasPrefix(msg, "so:1: stack overflow\nstack traceback:\n\tso:1: in function 'f'") {
+		t.Errorf("error is %q", msg)
+	}
 }
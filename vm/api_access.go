@@ -97,6 +97,20 @@ func (self *luaState) IsThread(idx int) bool {
 	return self.Type(idx) == LUA_TTHREAD
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.1/manual.html#lua_isuserdata
+// True for both full and light userdata.
+func (self *luaState) IsUserData(idx int) bool {
+	t := self.Type(idx)
+	return t == LUA_TUSERDATA || t == LUA_TLIGHTUSERDATA
+}
+
+// [-0, +0, –]
+// http://www.lua.org/manual/5.1/manual.html#lua_islightuserdata
+func (self *luaState) IsLightUserData(idx int) bool {
+	return self.Type(idx) == LUA_TLIGHTUSERDATA
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_isstring
 func (self *luaState) IsString(idx int) bool {
@@ -181,8 +195,12 @@ func (self *luaState) ToStringX(idx int) (string, bool) {
 	switch x := val.(type) {
 	case string:
 		return x, true
-	case int64, float64:
-		s := fmt.Sprintf("%v", x) // todo
+	case int64:
+		s := fmt.Sprintf("%d", x)
+		self.stack.set(idx, s)
+		return s, true
+	case float64:
+		s := FormatNumber(x)
 		self.stack.set(idx, s)
 		return s, true
 	default:
@@ -216,9 +234,26 @@ func (self *luaState) ToThread(idx int) LuaState {
 	return nil
 }
 
+// [-0, +0, –]
+// http://www.lua.org/manual/5.1/manual.html#lua_touserdata
+// The go value of the full or light userdata at idx, or nil if the value is not a userdata.
+func (self *luaState) ToUserData(idx int) interface{} {
+	switch x := self.stack.get(idx).(type) {
+	case *userdata:
+		return x.value
+	case lightUserdata:
+		return x.value
+	default:
+		return nil
+	}
+}
+
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_topointer
 func (self *luaState) ToPointer(idx int) interface{} {
 	// todo
+	if x, ok := self.stack.get(idx).(lightUserdata); ok {
+		return x.value
+	}
 	return self.stack.get(idx)
 }
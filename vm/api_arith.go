@@ -60,7 +60,7 @@ func (self *luaState) Arith(op ArithOp) {
 		return
 	}
 
-	panic("arithmetic error!")
+	self.arithError(a, b)
 }
 
 func _arith(a, b luaValue, op operator) luaValue {
@@ -73,11 +73,11 @@ func _arith(a, b luaValue, op operator) luaValue {
 	} else { // arith
 		if op.integerFunc != nil { // add,sub,mul,mod,unm
 			if x, ok := a.(int64); ok {
-				if y, ok := b.(int64); ok {
+				if y, ok := b.(int64); ok && !(y == 0 && op.metamethod == "__mod") {
 					return op.integerFunc(x, y)
 				}
 			}
-		}
+		} // n % 0 is nan, as numbers of lua 5.1 are all floats
 		if x, ok := convertToFloat(a); ok {
 			if y, ok := convertToFloat(b); ok {
 				return op.floatFunc(x, y)
@@ -1,33 +1,137 @@
 package vm
 
 import (
+	"fmt"
+	. "goluar/api"
 	common "goluar/common"
 	"goluar/compiler"
+	"io"
+	"strings"
 )
 
 // [-0, +1, –]
 /*
-	Load binary chunk, and initialize _ENV. Put _ENV as upvalues in the current function upvalues.
+	Load binary chunk or source code, push the main function whose environment is the table of globals.
+	If the source code can not be compiled, or the binary chunk is malformed,
+	the error message is pushed instead and LUA_ERRSYNTAX is returned.
+	The mode controls what kind of chunk is accepted:
+		"b"		binary chunks only
+		"t"		text chunks only
+		"bt"	both binary and text, the same as ""
+	A 'v' in the mode, such as "bv", runs Verify on binary chunks before they are accepted.
 */
-func (self *luaState) Load(chunk []byte, chunkName, mode string) int {
+func (self *luaState) Load(chunk []byte, chunkName, mode string) (status int) {
+	if mode == "" {
+		mode = "bt"
+	}
 	var proto *common.FuncProto
 	if common.IsBinaryChunk(chunk) {
-		proto = common.LoadBinaryChunk(chunk)
+		if !strings.Contains(mode, "b") {
+			self.stack.push(fmt.Sprintf("attempt to load a binary chunk (mode is '%s')", mode))
+			return common.LUA_ERRSYNTAX
+		}
+		var err error
+		if proto, err = common.LoadBinaryChunk(chunk); err == nil && strings.Contains(mode, "v") {
+			err = Verify(proto)
+		}
+		if err != nil {
+			name := common.ChunkID(chunkName)
+			if strings.HasPrefix(chunkName, common.SIGNATURE[:1]) { /* like luaU_undump */
+				name = "binary string"
+			}
+			self.stack.push(fmt.Sprintf("%s: %s", name, err))
+			return common.LUA_ERRSYNTAX
+		}
 	} else {
+		if !strings.Contains(mode, "t") {
+			self.stack.push(fmt.Sprintf("attempt to load a text chunk (mode is '%s')", mode))
+			return common.LUA_ERRSYNTAX
+		}
+		defer func() {
+			if err := recover(); err != nil {
+				msg, ok := err.(string)
+				if !ok {
+					panic(err)
+				}
+				self.stack.push(msg)
+				status = common.LUA_ERRSYNTAX
+			}
+		}()
 		proto = compiler.Compile(string(chunk), chunkName)
 	}
 
-	c := newLuaClosure(proto)
+	c := newLuaClosure(proto, self.env)
 	self.stack.push(c)
-	// if len(proto.UpvalueCount) > 0 {
-	// 	env := self.registry.get(LUA_RIDX_GLOBALS)
-	// 	c.upvals[0] = &upvalue{&env}
-	// }
 	return common.LUA_OK
 }
 
+// [-0, +0, m]
+// http://www.lua.org/manual/5.1/manual.html#lua_dump
+/*
+	Dump the lua function on the top of the stack as a binary chunk, which is loaded
+	back by Load. The function is not popped.
+	Returns 0 on success, or 1 if the value is not a lua function or the writer fails.
+*/
+func (self *luaState) Dump(w io.Writer) int {
+	c, ok := self.stack.get(-1).(*closure)
+	if !ok || c.proto == nil {
+		return 1
+	}
+	if _, err := w.Write(common.DumpBinaryChunk(c.proto, false)); err != nil {
+		return 1
+	}
+	return 0
+}
+
 // [-(nargs+1), +nresults, e]
 func (self *luaState) Call(nArgs, nResults int) {
+	self.CallK(nArgs, nResults, 0, nil)
+}
+
+// [-(nargs+1), +nresults, e]
+/*
+	Call the function like Call. If the thread can yield, the callee can yield across the call:
+	k is saved in the stack of the running go function, and called to finish it when the callee
+	returns after the coroutine is resumed. Without k, a yield in the callee is an error.
+	http://www.lua.org/manual/5.3/manual.html#lua_callk
+	lua-5.3.4/src/lapi.c#lua_callk()
+*/
+func (self *luaState) CallK(nArgs, nResults, ctx int, k KFunction) {
+	if k != nil && self.nny == 0 { /* need to prepare continuation? */
+		stack := self.stack
+		stack.k, stack.ctx = k, ctx /* save continuation */
+		self.call(nArgs, nResults)  /* just do the call */
+	} else { /* no continuation or no yieldable */
+		self.nny++
+		self.call(nArgs, nResults) /* just do the call */
+		self.nny--
+	}
+}
+
+/*
+	@description
+		Call the function from go, a lua function is run by a new run of the interpreter loop.
+		lua-5.3.4/src/ldo.c#luaD_call()
+*/
+func (self *luaState) call(nArgs, nResults int) {
+	if !self.PreCall(nArgs, nResults) { /* is a Lua function? */
+		self.stack.fresh = true
+		self.execute() /* call it */
+	}
+}
+
+/*
+	@description
+		Call the function at the top of the stack under the n arguments, the __call metamethod
+		is called for a value which is not a function.
+		A go function is run to the end and its results are left on the stack.
+		The stack of a lua function is pushed for the interpreter loop, which runs the function
+		and moves the results back when it returns, the go stack does not grow for the call.
+		lua-5.3.4/src/ldo.c#luaD_precall()
+	@return
+		ok	bool	"true if the call is finished, false for a lua function"
+*/
+func (self *luaState) PreCall(nArgs, nResults int) bool {
 	val := self.stack.get(-(nArgs + 1))
 
 	c, ok := val.(*closure)
@@ -41,15 +145,15 @@ func (self *luaState) Call(nArgs, nResults int) {
 		}
 	}
 
-	if ok {
-		if c.proto != nil {
-			self.callLuaClosure(nArgs, nResults, c)
-		} else {
-			self.callGoClosure(nArgs, nResults, c)
-		}
-	} else {
-		panic("not function!")
+	if !ok {
+		self.operandError(val, "call")
+	}
+	if c.proto == nil {
+		self.callGoClosure(nArgs, nResults, c)
+		return true
 	}
+	self.enterLuaClosure(nArgs, nResults, c)
+	return false
 }
 
 /*
@@ -64,6 +168,7 @@ func (self *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	// create new lua stack
 	newStack := newLuaStack(nArgs+common.LUA_MINSTACK, self)
 	newStack.closure = c
+	newStack.nResults = nResults
 
 	// pass args, pop func
 	// args is a array of luaValue
@@ -76,32 +181,24 @@ func (self *luaState) callGoClosure(nArgs, nResults int, c *closure) {
 	// run closure
 	self.pushLuaStack(newStack)
 	r := c.goFunc(self)
-	self.popLuaStack()
-
-	// return results
-	if nResults != 0 {
-		results := newStack.popN(r)
-		self.stack.check(len(results))
-		self.stack.pushN(results, nResults)
-	}
+	self.postCall(r)
 }
 
 /*
 	@description
 		Create a temp stack for calling closure, initialize the temp stack.
 		Push the temp stack to the state, the current stack will be replaced by the temp stack.
-		Run the closure on the current stack.
-		Pop the temp stack from the state, the current stack will be restored.
-		Get the result from the temp stack, and push the result to the current stack.
+		The closure is run by the interpreter loop on the current stack, see execute.
 */
-func (self *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
+func (self *luaState) enterLuaClosure(nArgs, nResults int, c *closure) {
 	nRegs := int(c.proto.MaxStackSize)
 	nParams := int(c.proto.NumParams)
-	isVararg := c.proto.IsVararg == 1
+	isVararg := c.proto.IsVararg&common.VARARG_ISVARARG != 0
 
 	// create new lua stack
 	newStack := newLuaStack(nRegs+common.LUA_MINSTACK, self)
 	newStack.closure = c
+	newStack.nResults = nResults
 
 	// pass args, pop func
 	funcAndArgs := self.stack.popN(nArgs + 1)
@@ -111,49 +208,175 @@ func (self *luaState) callLuaClosure(nArgs, nResults int, c *closure) {
 		newStack.varargs = funcAndArgs[nParams+1:]
 	}
 
-	// run closure
 	self.pushLuaStack(newStack)
-	self.runLuaClosure()
+}
+
+/*
+	@description
+		Pop the stack of the returning function, the n results at the top of it are moved
+		to the caller, adjusted to the number of results wanted by the caller.
+		lua-5.3.4/src/ldo.c#luaD_poscall()
+*/
+func (self *luaState) postCall(n int) {
+	stack := self.stack
+	results := stack.popN(n)
 	self.popLuaStack()
 
 	// return results
-	if nResults != 0 {
-		results := newStack.popN(newStack.top - nRegs)
+	if stack.nResults != 0 {
 		self.stack.check(len(results))
-		self.stack.pushN(results, nResults)
+		self.stack.pushN(results, stack.nResults)
 	}
 }
 
-func (self *luaState) runLuaClosure() {
+/*
+	@description
+		The interpreter loop. A lua function called by a lua function is run by the same loop,
+		the loop returns when the fresh function which it was started with returns.
+		lua-5.3.4/src/lvm.c#luaV_execute()
+*/
+func (self *luaState) execute() {
 	for {
 		inst := Instruction(self.Fetch())
 		inst.Execute(self)
 		if inst.Opcode() == common.OP_RETURN {
-			break
+			stack := self.stack
+			self.postCall(stack.top - self.RegisterCount())
+			if stack.fresh {
+				return /* external invocation: return */
+			}
+			self.finishOp() /* invocation via reentry: continue execution */
 		}
 	}
 }
 
-// Calls a function in protected mode.
-// http://www.lua.org/manual/5.3/manual.html#lua_pcall
+/*
+	@description
+		Finish the CALL, TAILCALL or TFORLOOP instruction of the running lua function,
+		whose callee has returned the results to the top of the stack.
+		lua-5.3.4/src/lvm.c#luaV_finishOp()
+*/
+func (self *luaState) finishOp() {
+	stack := self.stack
+	inst := Instruction(stack.closure.proto.Instructions[stack.pc-1])
+	a, _, c := inst.ABC()
+	switch inst.Opcode() {
+	case common.OP_CALL:
+		_popResults(a+1, c, self)
+	case common.OP_TAILCALL:
+		_popResults(a+1, 0, self)
+	case common.OP_TFORLOOP:
+		_finishTForLoop(a+1, c, self)
+	}
+}
+
+// [-(nargs+1), +(nresults|1), –]
+/*
+	Calls a function in protected mode.
+	If msgh is not 0, it is the stack index of a message handler. On error the handler is called
+	with the error object before the stack is unwound, so the frames of the error are still live
+	and the handler can collect a traceback; its return value is the error object left on the stack.
+	LUA_ERRERR is returned if the handler itself raises an error.
+	http://www.lua.org/manual/5.1/manual.html#lua_pcall
+*/
 func (self *luaState) PCall(nArgs, nResults, msgh int) (status int) {
+	return self.PCallK(nArgs, nResults, msgh, 0, nil)
+}
+
+// [-(nargs+1), +(nresults|1), –]
+/*
+	Calls a function in protected mode like PCall. If the thread can yield, the callee can yield
+	across the call: the errors are caught by Resume, which unwinds the stack to the go function
+	and calls k with the error status, see CallK.
+	http://www.lua.org/manual/5.3/manual.html#lua_pcallk
+	lua-5.3.4/src/lapi.c#lua_pcallk()
+*/
+func (self *luaState) PCallK(nArgs, nResults, msgh, ctx int, k KFunction) (status int) {
 	caller := self.stack
+	oldTop := caller.top - (nArgs + 1) // where the function is
+	var handler luaValue
+	if msgh != 0 {
+		handler = caller.get(msgh)
+	}
+
+	if k != nil && self.nny == 0 { /* prepare continuation (call is already protected by 'resume') */
+		caller.k, caller.ctx = k, ctx /* save continuation */
+		/* save information for error recovery */
+		caller.pcall, caller.errFunc, caller.oldTop = true, handler, oldTop
+		self.call(nArgs, nResults) /* do the call */
+		caller.pcall, caller.errFunc = false, nil
+		return common.LUA_OK /* if it is here, there were no errors */
+	}
+
+	oldNny := self.nny
 	status = common.LUA_ERRRUN
 
 	// catch error
 	defer func() {
 		if err := recover(); err != nil {
-			if msgh != 0 {
-				panic(err)
+			exit, isExit := err.(*ExitError)
+			if e, ok := err.(error); ok && !isExit { // go runtime error
+				err = e.Error()
+			}
+			if handler != nil && !isExit {
+				err, status = self.callHandler(handler, err)
 			}
 			for self.stack != caller {
 				self.popLuaStack()
 			}
+			self.restoreStackLimit()
+			self.SetTop(oldTop)
+			self.nny = oldNny
+			if isExit {
+				panic(exit) // os.exit unwinds to the host, the state can still be used
+			}
 			self.stack.push(err)
 		}
 	}()
 
-	self.Call(nArgs, nResults)
+	self.nny++
+	self.call(nArgs, nResults)
+	self.nny--
 	status = common.LUA_OK
 	return
 }
+
+/*
+	@description
+		Call the message handler with the error object on the stack where the error is raised.
+	@return
+		msg		luaValue	"the error object returned by the handler"
+		status	int			"LUA_ERRRUN, or LUA_ERRERR if the handler raises an error"
+*/
+func (self *luaState) callHandler(handler, err luaValue) (msg luaValue, status int) {
+	defer func() {
+		if e := recover(); e != nil {
+			if e, ok := e.(*ExitError); ok {
+				panic(e)
+			}
+			msg, status = "error in error handling", common.LUA_ERRERR
+		}
+	}()
+
+	stack := self.stack
+	stack.check(2)
+	stack.push(handler)
+	stack.push(err)
+	self.Call(1, 1)
+	return stack.pop(), common.LUA_ERRRUN
+}
+
+// [-(nargs+1), +(nresults|1), –]
+/*
+	Calls a function in protected mode with the go function as the message handler,
+	like PCall with the handler pushed below the function. The handler is removed from
+	the stack after the call.
+*/
+func (self *luaState) XPCall(nArgs, nResults int, msgh GoFunction) int {
+	self.PushGoFunction(msgh)
+	funcIdx := self.AbsIndex(-(nArgs + 2))
+	self.Insert(funcIdx) // put the handler under the function
+	status := self.PCall(nArgs, nResults, funcIdx)
+	self.Remove(funcIdx)
+	return status
+}
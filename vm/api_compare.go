@@ -73,6 +73,13 @@ func _eq(a, b luaValue, ls *luaState) bool {
 			}
 		}
 		return a == b
+	case *userdata:
+		if y, ok := b.(*userdata); ok && x != y && ls != nil {
+			if result, ok := callMetamethod(x, y, "__eq", ls); ok {
+				return convertToBoolean(result)
+			}
+		}
+		return a == b
 	default:
 		return a == b
 	}
@@ -103,7 +110,8 @@ func _lt(a, b luaValue, ls *luaState) bool {
 	if result, ok := callMetamethod(a, b, "__lt", ls); ok {
 		return convertToBoolean(result)
 	} else {
-		panic("comparison error!")
+		ls.orderError(a, b)
+		return false
 	}
 }
 
@@ -134,6 +142,7 @@ func _le(a, b luaValue, ls *luaState) bool {
 	} else if result, ok := callMetamethod(b, a, "__lt", ls); ok {
 		return !convertToBoolean(result)
 	} else {
-		panic("comparison error!")
+		ls.orderError(a, b)
+		return false
 	}
 }
@@ -9,61 +9,221 @@ import (
 // http://www.lua.org/manual/5.3/manual.html#lua_newthread
 // lua-5.3.4/src/lstate.c#lua_newthread()
 func (self *luaState) NewThread() LuaState {
-	t := &luaState{registry: self.registry}
+	t := &luaState{registry: self.registry, env: self.env, rand: self.rand, goTypes: self.goTypes, nny: 1}
 	t.pushLuaStack(newLuaStack(LUA_MINSTACK, t))
 	self.stack.push(t)
 	return t
 }
 
+/*
+	The panic of YieldK, it unwinds the go stack to Resume. The stacks of the functions
+	of the coroutine are kept by the thread, Resume goes on with them.
+*/
+type yieldSignal struct{}
+
 // [-?, +?, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_resume
+// lua-5.3.4/src/ldo.c#lua_resume()
 func (self *luaState) Resume(from LuaState, nArgs int) int {
-	lsFrom := from.(*luaState)
-	if lsFrom.coChan == nil {
-		lsFrom.coChan = make(chan int)
+	if self.coStatus == LUA_OK { /* may be starting a coroutine */
+		if self.stack.prev != nil { /* not in base level? */
+			return self.resumeError("cannot resume non-suspended coroutine", nArgs)
+		}
+	} else if self.coStatus != LUA_YIELD {
+		return self.resumeError("cannot resume dead coroutine", nArgs)
 	}
 
-	if self.coChan == nil {
-		// start coroutine
-		self.coChan = make(chan int)
-		self.coCaller = lsFrom
-		go func() {
-			self.coStatus = self.PCall(nArgs, -1, 0)
-			lsFrom.coChan <- 1
-		}()
-	} else {
-		// resume coroutine
-		if self.coStatus != LUA_YIELD { // todo
-			self.stack.push("cannot resume non-suspended coroutine")
-			return LUA_ERRRUN
+	oldNny := self.nny
+	defer func() { self.nny = oldNny }()
+	self.nny = 0 /* allow yields */
+	status, err := self.runProtected(func() { self.resume(nArgs) })
+	for status != LUA_OK && status != LUA_YIELD { /* error? */
+		stack := self.findPCall()
+		if stack == nil { /* no recovery point, the coroutine is dead */
+			self.coStatus = status
+			for self.stack.prev != nil {
+				self.popLuaStack()
+			}
+			self.stack.check(1)
+			self.stack.push(err)
+			break
 		}
-		self.coStatus = LUA_OK
-		self.coChan <- 1
+		/* continue running the coroutine after the error */
+		kStatus := self.recoverPCall(stack, status, err)
+		status, err = self.runProtected(func() {
+			self.finishGoCall(kStatus) /* finish 'PCallK' callee */
+			self.unroll()
+		})
 	}
+	return status
+}
 
-	<-lsFrom.coChan // wait coroutine to finish or yield
-	return self.coStatus
+// lua-5.3.4/src/ldo.c#resume_error()
+func (self *luaState) resumeError(msg string, nArgs int) int {
+	self.stack.popN(nArgs) /* remove args from the stack */
+	self.stack.push(msg)   /* push error message */
+	return LUA_ERRRUN
+}
+
+/*
+	@description
+		Run f, the yield and the errors of the coroutine are caught. os.exit is passed
+		to the host after the stacks are unwound, the coroutine is dead.
+	@return
+		status	int			"LUA_OK, LUA_YIELD or LUA_ERRRUN"
+		err		luaValue	"the error object"
+*/
+func (self *luaState) runProtected(f func()) (status int, err luaValue) {
+	defer func() {
+		if e := recover(); e != nil {
+			switch e := e.(type) {
+			case yieldSignal:
+				status = LUA_YIELD
+			case *ExitError:
+				self.coStatus = LUA_ERRRUN
+				for self.stack.prev != nil {
+					self.popLuaStack()
+				}
+				panic(e) // os.exit unwinds to the host
+			case error: // go runtime error
+				status, err = LUA_ERRRUN, e.Error()
+			default:
+				status, err = LUA_ERRRUN, e
+			}
+		}
+	}()
+	f()
+	return LUA_OK, nil
+}
+
+/*
+	@description
+		Start the coroutine by calling its function, or go on with the go function which yielded.
+		lua-5.3.4/src/ldo.c#resume()
+*/
+func (self *luaState) resume(nArgs int) {
+	if self.coStatus == LUA_OK { /* starting a coroutine? */
+		self.call(nArgs, LUA_MULTRET) /* just call its body */
+		return
+	}
+	/* resuming from previous yield */
+	self.coStatus = LUA_OK
+	stack := self.stack
+	n := nArgs          /* the values of resume are the results of yield */
+	if stack.k != nil { /* does it have a continuation function? */
+		args := stack.popN(nArgs)
+		stack.pushN(stack.kept, -1) /* give back the values under the yielded ones */
+		stack.pushN(args, nArgs)
+		stack.kept = nil
+		n = stack.k(self, LUA_YIELD, stack.ctx) /* call continuation */
+	}
+	self.postCall(n) /* finish the call of the go function */
+	self.unroll()    /* run continuation */
+}
+
+/*
+	@description
+		Execute the stacks of the coroutine until they are all finished. A go function
+		is finished by its continuation, a lua function by the interpreter loop.
+		lua-5.3.4/src/ldo.c#unroll()
+*/
+func (self *luaState) unroll() {
+	for self.stack.prev != nil { /* something in the stack */
+		if self.stack.closure.proto == nil { /* go function? */
+			self.finishGoCall(LUA_YIELD) /* complete its execution */
+		} else { /* lua function */
+			self.finishOp() /* finish interrupted instruction */
+			self.execute()  /* execute down to higher go 'boundary' */
+		}
+	}
+}
+
+/*
+	@description
+		Finish the go function which called CallK or PCallK by its continuation,
+		the callee has returned.
+		lua-5.3.4/src/ldo.c#finishCcall()
+*/
+func (self *luaState) finishGoCall(status int) {
+	stack := self.stack
+	if stack.pcall { /* was inside a pcall? */
+		stack.pcall, stack.errFunc = false, nil
+	}
+	n := stack.k(self, status, stack.ctx) /* call continuation */
+	self.postCall(n)                      /* finish 'PreCall' */
+}
+
+// The stack of the nearest go function running PCallK, nil if there is none.
+// lua-5.3.4/src/ldo.c#findpcall()
+func (self *luaState) findPCall() *luaStack {
+	for stack := self.stack; stack != nil; stack = stack.prev {
+		if stack.pcall {
+			return stack
+		}
+	}
+	return nil
+}
+
+/*
+	@description
+		Catch the error by the PCallK of the stack like PCall: the message handler is called
+		on the stack of the error, the stack is unwound to the go function and the error
+		object is pushed.
+		lua-5.3.4/src/ldo.c#recover()
+	@return
+		status	int	"LUA_ERRRUN, or LUA_ERRERR if the handler raises an error"
+*/
+func (self *luaState) recoverPCall(stack *luaStack, status int, err luaValue) int {
+	if stack.errFunc != nil {
+		err, status = self.callHandler(stack.errFunc, err)
+	}
+	for self.stack != stack {
+		self.popLuaStack()
+	}
+	self.restoreStackLimit()
+	self.SetTop(stack.oldTop)
+	self.stack.push(err)
+	stack.pcall, stack.errFunc = false, nil
+	self.nny = 0 /* should be zero to be yieldable */
+	return status
 }
 
 // [-?, +?, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_yield
 func (self *luaState) Yield(nResults int) int {
-	if self.coCaller == nil { // todo
-		panic("attempt to yield from outside a coroutine")
+	return self.YieldK(nResults, 0, nil)
+}
+
+// [-?, +?, e]
+/*
+	Yield the coroutine with the n values at the top of the stack, the go function must
+	be called by a lua function or by CallK or PCallK. YieldK does not return: when the
+	coroutine is resumed, k is called with the values of resume replacing the yielded
+	values, or the values of resume are returned by the go function if k is nil.
+	http://www.lua.org/manual/5.3/manual.html#lua_yieldk
+	lua-5.3.4/src/ldo.c#lua_yieldk()
+*/
+func (self *luaState) YieldK(nResults, ctx int, k KFunction) int {
+	if self.nny > 0 {
+		if !self.isMainThread() {
+			self.runError("attempt to yield across metamethod/C-call boundary")
+		}
+		self.runError("attempt to yield from outside a coroutine")
 	}
+	stack := self.stack
+	stack.k, stack.ctx = k, ctx /* save continuation */
+	/* only the yielded values are seen by the resumer */
+	results := stack.popN(nResults)
+	stack.kept = stack.popN(stack.top)
+	stack.pushN(results, nResults)
 	self.coStatus = LUA_YIELD
-	self.coCaller.coChan <- 1
-	<-self.coChan
-	return self.GetTop()
+	panic(yieldSignal{})
 }
 
 // [-0, +0, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_isyieldable
 func (self *luaState) IsYieldable() bool {
-	if self.isMainThread() {
-		return false
-	}
-	return self.coStatus != LUA_YIELD // todo
+	return self.nny == 0
 }
 
 // [-0, +0, –]
@@ -77,3 +237,15 @@ func (self *luaState) Status() int {
 func (self *luaState) GetStack() bool {
 	return self.stack.prev != nil
 }
+
+// [-0, +(0|1), –]
+// Pushes the function running at the given level of the call stack: level 0 is the current
+// running function, level 1 is the function that called it, and so on.
+// Returns false and pushes nothing if the level is greater than the stack depth.
+func (self *luaState) GetStackFunction(level int) bool {
+	if stack := self.getStack(level); stack != nil {
+		self.stack.push(stack.closure)
+		return true
+	}
+	return false
+}
@@ -14,6 +14,9 @@ func (self *luaState) NewTable() {
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_createtable
 func (self *luaState) CreateTable(nArr, nRec int) {
+	if nArr < 0 || nArr > 1<<MAXBITS || nRec < 0 || nRec > 1<<MAXBITS { /* the hints of an unchecked NEWTABLE */
+		self.runError("table overflow")
+	}
 	t := newLuaTable(nArr, nRec)
 	self.stack.push(t)
 }
@@ -58,7 +61,7 @@ func (self *luaState) RawGetI(idx int, i int64) LuaType {
 // [-0, +1, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_getglobal
 func (self *luaState) GetGlobal(name string) LuaType {
-	t := self.registry.get(LUA_RIDX_GLOBALS)
+	t := self.env
 	return self.getTable(t, name, false)
 }
 
@@ -76,6 +79,22 @@ func (self *luaState) GetMetatable(idx int) bool {
 	}
 }
 
+// [-0, +1, –]
+// http://www.lua.org/manual/5.1/manual.html#lua_getfenv
+// Pushes the environment table of the function, the thread or the userdata at the given index, or nil for other values.
+func (self *luaState) GetFenv(idx int) {
+	switch x := self.stack.get(idx).(type) {
+	case *closure:
+		self.stack.push(x.env)
+	case *luaState:
+		self.stack.push(x.env)
+	case *userdata:
+		self.stack.push(x.env)
+	default:
+		self.stack.push(nil)
+	}
+}
+
 // push(t[k])
 func (self *luaState) getTable(t, k luaValue, raw bool) LuaType {
 	if tbl, ok := t.(*luaTable); ok {
@@ -102,5 +121,6 @@ func (self *luaState) getTable(t, k luaValue, raw bool) LuaType {
 		}
 	}
 
-	panic("index error!")
+	self.operandError(t, "index")
+	return LUA_TNIL
 }
@@ -16,7 +16,7 @@ func (self *luaState) Len(idx int) {
 	} else if t, ok := val.(*luaTable); ok {
 		self.stack.push(int64(t.len()))
 	} else {
-		panic("length error!")
+		self.operandError(val, "get length of")
 	}
 }
 
@@ -43,7 +43,7 @@ func (self *luaState) Concat(n int) {
 				continue
 			}
 
-			panic("concatenation error!")
+			self.concatError(a, b)
 		}
 	}
 	// n == 1, do nothing
@@ -55,9 +55,13 @@ func (self *luaState) Next(idx int) bool {
 	val := self.stack.get(idx)
 	if t, ok := val.(*luaTable); ok {
 		key := self.stack.pop()
-		if nextKey := t.nextKey(key); nextKey != nil {
+		nextKey, val, ok := t.next(key)
+		if !ok {
+			self.runError("invalid key to 'next'")
+		}
+		if nextKey != nil {
 			self.stack.push(nextKey)
-			self.stack.push(t.get(nextKey))
+			self.stack.push(val)
 			return true
 		}
 		return false
@@ -85,3 +89,15 @@ func (self *luaState) StringToNumber(s string) bool {
 	}
 	return false
 }
+
+// [-0, +0, –]
+// Seed the random generator of the state, the sequence of Random only depends on the seed.
+func (self *luaState) SeedRandom(seed int64) {
+	self.rand.Seed(seed)
+}
+
+// [-0, +0, –]
+// The next number of the random generator, in [0, 1).
+func (self *luaState) Random() float64 {
+	return self.rand.Float64()
+}
@@ -3,7 +3,7 @@ package vm
 import (
 	"fmt"
 	. "goluar/api"
-	. "goluar/common"
+	"reflect"
 )
 
 // [-0, +1, –]
@@ -48,16 +48,17 @@ func (self *luaState) PushFString(fmtStr string, a ...interface{}) {
 /*
 	@description
 		Use go function to initialize a go closure, and then push it to the stack.
+		The environment of the go closure is the one of the running function.
 */
 func (self *luaState) PushGoFunction(f GoFunction) {
-	self.stack.push(newGoClosure(f, 0))
+	self.stack.push(newGoClosure(f, 0, self.stack.env()))
 }
 
 // [-n, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushcclosure
 //pop n args from the stack, make f and args to a closure, push to the stack.
 func (self *luaState) PushGoClosure(f GoFunction, n int) {
-	closure := newGoClosure(f, n)
+	closure := newGoClosure(f, n, self.stack.env())
 	for i := n; i > 0; i-- {
 		val := self.stack.pop()
 		closure.upvals[i-1] = &upvalue{&val}
@@ -68,8 +69,7 @@ func (self *luaState) PushGoClosure(f GoFunction, n int) {
 // [-0, +1, –]
 // http://www.lua.org/manual/5.3/manual.html#lua_pushglobaltable
 func (self *luaState) PushGlobalTable() {
-	global := self.registry.get(LUA_RIDX_GLOBALS)
-	self.stack.push(global)
+	self.stack.push(self.env)
 }
 
 // [-0, +1, –]
@@ -78,3 +78,22 @@ func (self *luaState) PushThread() bool {
 	self.stack.push(self)
 	return self.isMainThread()
 }
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.1/manual.html#lua_newuserdata
+// Push a new full userdata holding the go value, without a metatable. Its
+// environment is the one of the running function.
+func (self *luaState) NewUserData(value interface{}) {
+	self.stack.push(&userdata{value: value, env: self.stack.env()})
+}
+
+// [-0, +1, –]
+// http://www.lua.org/manual/5.1/manual.html#lua_pushlightuserdata
+// Push the go value as a light userdata. The value must be comparable, it is
+// usually a pointer.
+func (self *luaState) PushLightUserData(p interface{}) {
+	if p != nil && !reflect.TypeOf(p).Comparable() {
+		panic(fmt.Sprintf("light userdata of uncomparable type %T", p))
+	}
+	self.stack.push(lightUserdata{p})
+}
@@ -2,7 +2,7 @@ package vm
 
 import (
 	. "goluar/api"
-	. "goluar/common"
+	"math"
 )
 
 // [-2, +0, e]
@@ -50,7 +50,7 @@ func (self *luaState) RawSetI(idx int, i int64) {
 // [-1, +0, e]
 // http://www.lua.org/manual/5.3/manual.html#lua_setglobal
 func (self *luaState) SetGlobal(name string) {
-	t := self.registry.get(LUA_RIDX_GLOBALS)
+	t := self.env
 	v := self.stack.pop()
 	self.setTable(t, name, v, false)
 }
@@ -77,10 +77,39 @@ func (self *luaState) SetMetatable(idx int) {
 	}
 }
 
+// [-1, +0, –]
+// http://www.lua.org/manual/5.1/manual.html#lua_setfenv
+// Pops a table from the stack and sets it as the new environment for the function, the thread or the userdata at the given index.
+// Returns false if the value is neither a function nor a thread nor a userdata.
+func (self *luaState) SetFenv(idx int) bool {
+	val := self.stack.get(idx)
+	env, ok := self.stack.pop().(*luaTable)
+	if !ok {
+		panic("table expected!")
+	}
+
+	switch x := val.(type) {
+	case *closure:
+		x.env = env
+	case *luaState:
+		x.env = env
+	case *userdata:
+		x.env = env
+	default:
+		return false
+	}
+	return true
+}
+
 // t[k]=v
 func (self *luaState) setTable(t, k, v luaValue, raw bool) {
 	if tbl, ok := t.(*luaTable); ok {
 		if raw || tbl.get(k) != nil || !tbl.hasMetafield("__newindex") {
+			if k == nil {
+				self.runError("table index is nil")
+			} else if f, ok := k.(float64); ok && math.IsNaN(f) {
+				self.runError("table index is NaN")
+			}
 			tbl.put(k, v)
 			return
 		}
@@ -103,5 +132,5 @@ func (self *luaState) setTable(t, k, v luaValue, raw bool) {
 		}
 	}
 
-	panic("index error!")
+	self.operandError(t, "index")
 }
@@ -43,7 +43,8 @@ func (self *luaState) LoadVararg(n int) {
 /*
 	@description
 		Get the function proto from the protos by index. Initialize a closure by the proto.
-		Push the closure to the stack. Traverse the upvalues of the sub proto.
+		Push the closure to the stack, it shares the environment of the current function.
+		Traverse the upvalues of the sub proto.
 		If a upvalue is a local variable of the current proto. We assign sub function closure upvals by current function openuv.
 		The openuv contains upvalues which is referenced by the sub fuction, and these upvalues is the current fuction variable in the stack.
 		Otherwise, assign sub function closure upvals by current function upvals.
@@ -51,27 +52,34 @@ func (self *luaState) LoadVararg(n int) {
 func (self *luaState) LoadProto(idx int) {
 	stack := self.stack
 	subProto := stack.closure.proto.Protos[idx]
-	closure := newLuaClosure(subProto)
+	closure := newLuaClosure(subProto, stack.closure.env)
 	stack.push(closure)
-	// for i, uvInfo := range subProto.Upvalues {
-	// 	uvIdx := int(uvInfo.Idx)
-	// 	if uvInfo.Instack == 1 {
-	// 		if stack.openuvs == nil {
-	// 			stack.openuvs = map[int]*upvalue{}
-	// 		}
+	for i, uvInfo := range subProto.Upvalues {
+		uvIdx := int(uvInfo.Idx)
+		if uvInfo.Instack == 1 {
+			if stack.openuvs == nil {
+				stack.openuvs = map[int]*upvalue{}
+			}
 
-	// 		if openuv, found := stack.openuvs[uvIdx]; found {
-	// 			closure.upvals[i] = openuv
-	// 		} else {
-	// 			closure.upvals[i] = &upvalue{&stack.slots[uvIdx]}
-	// 			stack.openuvs[uvIdx] = closure.upvals[i]
-	// 		}
-	// 	} else {
-	// 		closure.upvals[i] = stack.closure.upvals[uvIdx]
-	// 	}
-	// }
+			if openuv, found := stack.openuvs[uvIdx]; found {
+				closure.upvals[i] = openuv
+			} else {
+				closure.upvals[i] = &upvalue{&stack.slots[uvIdx]}
+				stack.openuvs[uvIdx] = closure.upvals[i]
+			}
+		} else {
+			closure.upvals[i] = stack.closure.upvals[uvIdx]
+		}
+	}
+	// skip the pseudo instructions which describe the upvalues
+	self.AddPC(len(subProto.Upvalues))
 }
 
+/*
+	@description
+		Close the open upvalues from the stack index a: the value is moved out of the register,
+		and shared by the closures which captured it.
+*/
 func (self *luaState) CloseUpvalues(a int) {
 	for i, openuv := range self.stack.openuvs {
 		if i >= a-1 {
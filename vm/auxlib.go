@@ -1,26 +1,41 @@
 package vm
 
 import (
+	"bytes"
 	"fmt"
 	. "goluar/api"
 	. "goluar/common"
-	"io/ioutil"
+	"goluar/stdlib"
 )
 
-// import "goluar/stdlib"
-
 // [-0, +0, v]
-// http://www.lua.org/manual/5.3/manual.html#luaL_error
+// http://www.lua.org/manual/5.1/manual.html#luaL_error
+// The message is prefixed with the position of the lua function which calls the go function.
 func (self *luaState) Error2(fmt string, a ...interface{}) int {
-	self.PushFString(fmt, a...) // todo
+	self.Where(1)
+	self.PushFString(fmt, a...)
+	self.Concat(2)
 	return self.Error()
 }
 
 // [-0, +0, v]
-// http://www.lua.org/manual/5.3/manual.html#luaL_argerror
+// http://www.lua.org/manual/5.1/manual.html#luaL_argerror
 func (self *luaState) ArgError(arg int, extraMsg string) int {
 	// bad argument #arg to 'funcname' (extramsg)
-	return self.Error2("bad argument #%d (%s)", arg, extraMsg) // todo
+	if self.stack.closure == nil { /* no stack frame? */
+		return self.Error2("bad argument #%d (%s)", arg, extraMsg)
+	}
+	kind, name := getFuncName(self.stack)
+	if kind == "method" {
+		arg--         /* do not count 'self' */
+		if arg == 0 { /* error is in the self argument itself? */
+			return self.Error2("calling '%s' on bad self (%s)", name, extraMsg)
+		}
+	}
+	if name == "" {
+		name = "?"
+	}
+	return self.Error2("bad argument #%d to '%s' (%s)", arg, name, extraMsg)
 }
 
 // [-0, +0, v]
@@ -139,11 +154,32 @@ func (self *luaState) LoadFile(filename string) int {
 
 // [-0, +1, m]
 // http://www.lua.org/manual/5.3/manual.html#luaL_loadfilex
+/*
+	@description
+		Load the file as a chunk. An error message is pushed if the file can not be read.
+		The file is read from the file system of the io library of the state, see stdlib.ReadFile.
+		The standard input is read if the filename is empty.
+		The first line of the file is skipped if it starts with '#', so that scripts can
+		start with a shebang line.
+*/
 func (self *luaState) LoadFileX(filename, mode string) int {
-	if data, err := ioutil.ReadFile(filename); err == nil {
-		return self.Load(data, "@"+filename, mode)
+	chunkName := "@" + filename
+	if filename == "" {
+		chunkName = "=stdin"
+	}
+	data, err := stdlib.ReadFile(self, filename)
+	if err != nil {
+		self.PushString(fmt.Sprintf("cannot open %s", chunkName[1:]))
+		return LUA_ERRFILE
 	}
-	return LUA_ERRFILE
+	if len(data) > 0 && data[0] == '#' {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i:] // keep the newline so that line numbers stay the same
+		} else {
+			data = nil
+		}
+	}
+	return self.Load(data, chunkName, mode)
 }
 
 // [-0, +1, –]
@@ -180,11 +216,8 @@ func (self *luaState) ToString2(idx int) string {
 	} else {
 		switch self.Type(idx) {
 		case LUA_TNUMBER:
-			if self.IsInteger(idx) {
-				self.PushString(fmt.Sprintf("%d", self.ToInteger(idx))) // todo
-			} else {
-				self.PushString(fmt.Sprintf("%g", self.ToNumber(idx))) // todo
-			}
+			self.PushValue(idx)
+			self.ToString(-1) /* convert the copy to a string */
 		case LUA_TSTRING:
 			self.PushValue(idx)
 		case LUA_TBOOLEAN:
@@ -257,24 +290,148 @@ func (self *luaState) CallMeta(obj int, event string) bool {
 	return true
 }
 
+// [-0, +1, m]
+// http://www.lua.org/manual/5.1/manual.html#luaL_newmetatable
+// Returns false and pushes the registered table if the registry already has the key tname.
+// The new metatable also has the field __name, the type name in ToString2 and the argument errors.
+func (self *luaState) NewMetatable(tname string) bool {
+	if self.GetField(LUA_REGISTRYINDEX, tname) != LUA_TNIL { /* name already in use? */
+		return false /* leave previous value on top, but return false */
+	}
+	self.Pop(1)
+	self.CreateTable(0, 2) /* create metatable */
+	self.PushString(tname)
+	self.SetField(-2, "__name") /* metatable.__name = tname */
+	self.PushValue(-1)
+	self.SetField(LUA_REGISTRYINDEX, tname) /* registry.name = metatable */
+	return true
+}
+
+// [-0, +0, -]
+// http://www.lua.org/manual/5.1/manual.html#luaL_getmetatable
+// Sets the metatable registered as tname by NewMetatable as the metatable of the object at
+// the top, like luaL_getmetatable followed by lua_setmetatable(L, -2).
+func (self *luaState) SetMetatableByName(tname string) {
+	self.GetField(LUA_REGISTRYINDEX, tname)
+	self.SetMetatable(-2)
+}
+
+// [-0, +0, m]
+// http://www.lua.org/manual/5.3/manual.html#luaL_testudata
+// The go value of the userdata at arg if its metatable is the one registered as tname, nil otherwise.
+func (self *luaState) TestUdata(arg int, tname string) interface{} {
+	p, _ := self.testUdata(arg, tname)
+	return p
+}
+
+// [-0, +0, v]
+// http://www.lua.org/manual/5.3/manual.html#luaL_checkudata
+func (self *luaState) CheckUdata(arg int, tname string) interface{} {
+	p, ok := self.testUdata(arg, tname)
+	if !ok {
+		self.typeError(arg, tname)
+	}
+	return p
+}
+
+// The userdata may hold nil, so whether it matches is returned apart from its value.
+func (self *luaState) testUdata(arg int, tname string) (interface{}, bool) {
+	if self.IsUserData(arg) { /* value is a userdata? */
+		if self.GetMetatable(arg) { /* does it have a metatable? */
+			self.GetField(LUA_REGISTRYINDEX, tname) /* get correct metatable */
+			ok := self.RawEqual(-1, -2)
+			self.Pop(2) /* remove both metatables */
+			if ok {
+				return self.ToUserData(arg), true
+			}
+		}
+	}
+	return nil, false /* value is not a userdata with the metatable */
+}
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.1/manual.html#luaL_where
+// Pushes "chunkname:currentline: " of the function at the level, or "" if it is not a lua function.
+// Level 0 is the running function, level 1 is the function that called it.
+func (self *luaState) Where(level int) {
+	if stack := self.getStack(level); stack != nil {
+		if line := stack.currentLine(); line > 0 {
+			self.PushFString("%s:%d: ", ChunkID(stack.closure.proto.Source), line)
+			return
+		}
+	}
+	self.PushString("")
+}
+
+const (
+	LEVELS1 = 12 // size of the first part of the stack
+	LEVELS2 = 10 // size of the second part of the stack
+)
+
+// [-0, +1, m]
+// http://www.lua.org/manual/5.2/manual.html#luaL_traceback
+// Pushes the traceback of the stack of L1, starting from the level, after the message if it is not empty:
+//	msg
+//	stack traceback:
+//		[C]: in function 'error'
+//		hello.lua:2: in function 'f'
+//		hello.lua:4: in main chunk
+//		[C]: ?
+func (self *luaState) Traceback(L1 LuaState, msg string, level int) {
+	ls := L1.(*luaState)
+	var stacks []*luaStack
+	for stack := ls.getStack(level); stack != nil && stack.closure != nil; stack = stack.prev {
+		stacks = append(stacks, stack)
+	}
+
+	var buf bytes.Buffer
+	if msg != "" {
+		buf.WriteString(msg)
+		buf.WriteString("\n")
+	}
+	buf.WriteString("stack traceback:")
+	for i, stack := range stacks {
+		if i == LEVELS1 && len(stacks) > LEVELS1+LEVELS2 {
+			buf.WriteString("\n\t...") /* too many levels, skip to the last ones */
+			continue
+		} else if i > LEVELS1 && i < len(stacks)-LEVELS2 {
+			continue
+		}
+		buf.WriteString("\n\t")
+		if stack.closure.proto == nil {
+			buf.WriteString("[C]:")
+		} else {
+			buf.WriteString(ChunkID(stack.closure.proto.Source) + ":")
+			if line := stack.currentLine(); line > 0 {
+				fmt.Fprintf(&buf, "%d:", line)
+			}
+		}
+		buf.WriteString(" " + describeFunction(stack))
+	}
+	self.PushString(buf.String())
+}
+
 // [-0, +0, e]
 // http://www.lua.org/manual/5.3/manual.html#luaL_openlibs
 func (self *luaState) OpenLibs() {
-	// libs := map[string]GoFunction{
-	// 	"_G":        stdlib.OpenBaseLib,
-	// 	"math":      stdlib.OpenMathLib,
-	// 	"table":     stdlib.OpenTableLib,
-	// 	"string":    stdlib.OpenStringLib,
-	// 	"utf8":      stdlib.OpenUTF8Lib,
-	// 	"os":        stdlib.OpenOSLib,
-	// 	"package":   stdlib.OpenPackageLib,
-	// 	"coroutine": stdlib.OpenCoroutineLib,
-	// }
-
-	// for name, fun := range libs {
-	// 	self.RequireF(name, fun, true)
-	// 	self.Pop(1)
-	// }
+	libs := []struct {
+		name string
+		fun  GoFunction
+	}{
+		{"_G", stdlib.OpenBaseLib},
+		{"coroutine", stdlib.OpenCoroutineLib},
+		{"package", stdlib.OpenPackageLib},
+		{"table", stdlib.OpenTableLib},
+		{"string", stdlib.OpenStringLib},
+		{"math", stdlib.OpenMathLib},
+		{"io", stdlib.OpenIOLib},
+		{"os", stdlib.OpenOSLib},
+	}
+
+	for _, lib := range libs {
+		self.RequireF(lib.name, lib.fun, true)
+		self.Pop(1)
+	}
 }
 
 // [-0, +1, e]
@@ -338,15 +495,24 @@ func (self *luaState) tagError(arg int, tag LuaType) {
 }
 
 func (self *luaState) typeError(arg int, tname string) int {
-	var typeArg string /* name for the type of the actual argument */
-	if self.GetMetafield(arg, "__name") == LUA_TSTRING {
-		typeArg = self.ToString(-1) /* use the given type name */
-	} else if self.Type(arg) == LUA_TLIGHTUSERDATA {
-		typeArg = "light userdata" /* special name for messages */
-	} else {
-		typeArg = self.TypeName2(arg) /* standard name */
-	}
-	msg := tname + " expected, got " + typeArg
+	msg := tname + " expected, got " + self.typeArgName(arg)
 	self.PushString(msg)
 	return self.ArgError(arg, msg)
 }
+
+// The name for the type of the value at idx in the messages of errors.
+func (self *luaState) typeArgName(idx int) string {
+	switch self.GetMetafield(idx, "__name") {
+	case LUA_TNIL:
+	case LUA_TSTRING:
+		name := self.ToString(-1)
+		self.Pop(1)
+		return name /* use the given type name */
+	default:
+		self.Pop(1)
+	}
+	if self.Type(idx) == LUA_TLIGHTUSERDATA {
+		return "light userdata" /* special name for messages */
+	}
+	return self.TypeName2(idx) /* standard name */
+}
@@ -13,18 +13,19 @@ type closure struct {
 	proto  *common.FuncProto // lua closure
 	goFunc GoFunction        // go closure
 	upvals []*upvalue
+	env    *luaTable // the table of global variables, see getfenv and setfenv
 }
 
 /*
 	@description
-		Get Upvalues from function proto, and then assign the Upvalues to a closure upvals.
-		Return closure.
+		Create a lua closure with room for the upvalues of the function proto.
+		The upvalues are assigned by LoadProto.
 */
-func newLuaClosure(proto *common.FuncProto) *closure {
-	c := &closure{proto: proto}
-	// if nUpvals := len(proto.Upvalues); nUpvals > 0 {
-	// 	c.upvals = make([]*upvalue, nUpvals)
-	// }
+func newLuaClosure(proto *common.FuncProto, env *luaTable) *closure {
+	c := &closure{proto: proto, env: env}
+	if nUpvals := int(proto.UpvalueCount); nUpvals > 0 {
+		c.upvals = make([]*upvalue, nUpvals)
+	}
 	return c
 }
 
@@ -32,8 +33,8 @@ func newLuaClosure(proto *common.FuncProto) *closure {
 	@description
 		Use Gofunction f to initialize go closuer c, and then initialize upvals in c.
 */
-func newGoClosure(f GoFunction, nUpvals int) *closure {
-	c := &closure{goFunc: f}
+func newGoClosure(f GoFunction, nUpvals int, env *luaTable) *closure {
+	c := &closure{goFunc: f, env: env}
 	if nUpvals > 0 {
 		c.upvals = make([]*upvalue, nUpvals)
 	}
@@ -0,0 +1,297 @@
+package vm
+
+import (
+	"fmt"
+	. "goluar/common"
+)
+
+/*
+	@description
+		Raise a runtime error. The message is prefixed with the chunk and the current line
+		if the running function is a lua function, like "hello.lua:3: msg".
+*/
+func (self *luaState) runError(format string, a ...interface{}) {
+	msg := fmt.Sprintf(format, a...)
+	if line := self.stack.currentLine(); line >= 0 {
+		msg = fmt.Sprintf("%s:%d: %s", ChunkID(self.stack.closure.proto.Source), line, msg)
+	}
+	panic(msg)
+}
+
+// RunError is runError for the instructions, see LuaVM.
+func (self *luaState) RunError(format string, a ...interface{}) {
+	self.runError(format, a...)
+}
+
+/*
+	@description
+		Raise the error of an operation on a value of the wrong type:
+			attempt to call a nil value (global 'foo')
+		The variable is found from the operands of the current instruction.
+	@param
+		val		luaValue	"the bad value"
+		op		string		"the operation, such as 'call', 'index' or 'perform arithmetic on'"
+*/
+func (self *luaState) operandError(val luaValue, op string) {
+	tName := self.TypeName(typeOf(val))
+	if kind, name := self.varInfo(val); kind != "" {
+		self.runError("attempt to %s a %s value (%s '%s')", op, tName, kind, name)
+	}
+	self.runError("attempt to %s a %s value", op, tName)
+}
+
+// The first operand which is not a number is reported.
+func (self *luaState) arithError(a, b luaValue) {
+	if _, ok := convertToFloat(a); !ok {
+		b = a
+	}
+	self.operandError(b, "perform arithmetic on")
+}
+
+// The first operand which is not a string or a number is reported.
+func (self *luaState) concatError(a, b luaValue) {
+	switch a.(type) {
+	case string, int64, float64:
+		a = b
+	}
+	self.operandError(a, "concatenate")
+}
+
+func (self *luaState) orderError(a, b luaValue) {
+	t1 := self.TypeName(typeOf(a))
+	t2 := self.TypeName(typeOf(b))
+	if t1 == t2 {
+		self.runError("attempt to compare two %s values", t1)
+	}
+	self.runError("attempt to compare %s with %s", t1, t2)
+}
+
+/*
+	@description
+		Find the variable holding the value among the register operands of the current instruction.
+	@return
+		kind	string	"global, local, field, upvalue or method; empty if it is unknown"
+		name	string	"the name of the variable"
+*/
+func (self *luaState) varInfo(val luaValue) (kind, name string) {
+	stack := self.stack
+	if stack.closure == nil || stack.closure.proto == nil || stack.pc <= 0 {
+		return "", ""
+	}
+	proto := stack.closure.proto
+	pc := stack.pc - 1
+	i := Instruction(proto.Instructions[pc])
+	a, b, c := i.ABC()
+
+	var regs []int
+	switch i.Opcode() {
+	case OP_CALL, OP_TAILCALL, OP_TFORLOOP, OP_SETTABLE:
+		regs = []int{a}
+	case OP_GETTABLE, OP_SELF, OP_UNM, OP_LEN:
+		regs = []int{b}
+	case OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW, OP_LT, OP_LE:
+		regs = []int{b, c}
+	case OP_CONCAT:
+		for r := b; r <= c; r++ {
+			regs = append(regs, r)
+		}
+	}
+	for _, r := range regs {
+		if r <= 0xFF && r < len(stack.slots) && stack.slots[r] == val {
+			return getObjName(proto, pc, r)
+		}
+	}
+	return "", ""
+}
+
+/*
+	@description
+		Find the name of the value in the register at the pc, by looking for the instruction
+		which loads the register, like getobjname of lua.
+	@return
+		kind	string	"global, local, field, upvalue or method; empty if it is unknown"
+		name	string	"the name of the variable"
+*/
+func getObjName(proto *FuncProto, lastPC, reg int) (kind, name string) {
+	if name = getLocalName(proto, reg+1, lastPC); name != "" {
+		return "local", name
+	}
+	pc := findSetReg(proto, lastPC, reg)
+	if pc < 0 {
+		return "", ""
+	}
+	i := Instruction(proto.Instructions[pc])
+	a, b, c := i.ABC()
+	switch i.Opcode() {
+	case OP_GETGLOBAL:
+		_, bx := i.ABx()
+		if s, ok := proto.Constants[bx].(string); ok {
+			return "global", s
+		}
+	case OP_MOVE:
+		if b < a {
+			return getObjName(proto, pc, b) // get name for b
+		}
+	case OP_GETTABLE:
+		return "field", constantName(proto, c)
+	case OP_GETUPVAL:
+		if b < len(proto.UpvalueNames) {
+			return "upvalue", proto.UpvalueNames[b]
+		}
+		return "upvalue", "?"
+	case OP_SELF:
+		return "method", constantName(proto, c)
+	}
+	return "", ""
+}
+
+// The name of the n-th (from 1) local variable active at the pc.
+func getLocalName(proto *FuncProto, n, pc int) string {
+	for _, locVar := range proto.LocVars {
+		if int(locVar.StartPC) > pc {
+			break
+		}
+		if pc < int(locVar.EndPC) { // is variable active?
+			n--
+			if n == 0 {
+				return locVar.VarName
+			}
+		}
+	}
+	return ""
+}
+
+func constantName(proto *FuncProto, rk int) string {
+	if isK(rk) {
+		if s, ok := proto.Constants[indexK(rk)].(string); ok {
+			return s
+		}
+	}
+	return "?"
+}
+
+/*
+	Find the last instruction before lastPC which sets the register. An instruction skipped by
+	a forward jump may not be executed, so -1 is returned for it.
+*/
+func findSetReg(proto *FuncProto, lastPC, reg int) int {
+	code := proto.Instructions
+	setReg := -1
+	jmpTarget := 0 // any code before this address is conditional
+	for pc := 0; pc < lastPC; pc++ {
+		i := Instruction(code[pc])
+		op := i.Opcode()
+		a, b, c := i.ABC()
+		change := false
+		switch op {
+		case OP_LOADNIL:
+			change = a <= reg && reg <= b
+		case OP_TFORLOOP:
+			change = reg >= a+2
+		case OP_CALL, OP_TAILCALL:
+			change = reg >= a
+		case OP_JMP:
+			_, sBx := i.AsBx()
+			dest := pc + 1 + sBx
+			if pc < dest && dest <= lastPC && dest > jmpTarget {
+				jmpTarget = dest
+			}
+		case OP_CLOSURE:
+			_, bx := i.ABx()
+			change = reg == a
+			pc += int(proto.Protos[bx].UpvalueCount) // skip the upvalues
+		case OP_SETLIST:
+			if c == 0 {
+				pc++ // skip the batch number
+			}
+		default:
+			change = opcodes[op].setAFlag != 0 && reg == a
+		}
+		if change {
+			if pc < jmpTarget {
+				setReg = -1
+			} else {
+				setReg = pc
+			}
+		}
+	}
+	return setReg
+}
+
+// The line of the current instruction, or -1 if the function is not a lua function.
+func (self *luaStack) currentLine() int {
+	if self.closure == nil || self.closure.proto == nil {
+		return -1
+	}
+	lineInfo := self.closure.proto.LineInfo
+	if pc := self.pc - 1; pc >= 0 && pc < len(lineInfo) {
+		return int(lineInfo[pc])
+	}
+	return -1
+}
+
+/*
+	@description
+		Find the stack of the function running at the level: 0 is the current running
+		function, 1 is the function that called it, and so on.
+	@return
+		stack	*luaStack	"nil if the level is greater than the stack depth"
+*/
+func (self *luaState) getStack(level int) *luaStack {
+	for stack := self.stack; stack != nil && stack.closure != nil; stack = stack.prev {
+		if level == 0 {
+			return stack
+		}
+		level--
+	}
+	return nil
+}
+
+/*
+	@description
+		Describe the function running on the stack for tracebacks, the name is found from
+		the instruction which calls it:
+			in function 'name'
+			in main chunk
+			in function <hello.lua:12>
+			?
+*/
+func describeFunction(stack *luaStack) string {
+	if kind, name := getFuncName(stack); kind != "" {
+		return fmt.Sprintf("in function '%s'", name)
+	}
+	proto := stack.closure.proto
+	if proto == nil {
+		return "?"
+	} else if proto.StartLine == 0 {
+		return "in main chunk"
+	}
+	return fmt.Sprintf("in function <%s:%d>", ChunkID(proto.Source), proto.StartLine)
+}
+
+/*
+	@description
+		Find the name of the function running on the stack from the instruction of the caller
+		which calls it.
+	@return
+		kind	string	"global, local, field, upvalue or method; empty if it is unknown"
+		name	string	"the name of the function"
+*/
+func getFuncName(stack *luaStack) (kind, name string) {
+	caller := stack.prev
+	if caller == nil || caller.closure == nil || caller.closure.proto == nil {
+		return "", "" // called from go
+	}
+	proto := caller.closure.proto
+	pc := caller.pc - 1
+	if pc < 0 || pc >= len(proto.Instructions) {
+		return "", ""
+	}
+	i := Instruction(proto.Instructions[pc])
+	switch i.Opcode() {
+	case OP_CALL, OP_TAILCALL, OP_TFORLOOP:
+		a, _, _ := i.ABC()
+		return getObjName(proto, pc, a)
+	}
+	return "", "" // called by a metamethod
+}
@@ -0,0 +1,411 @@
+package vm
+
+import (
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"math"
+	"reflect"
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// [-0, +1, m]
+// Push the go value. Booleans, numbers and strings, also of named types, are pushed as the lua
+// values, the unsigned integers above math.MaxInt64 as floats, a GoFunction as a function, and nil, nil pointers, maps, funcs and channels as nil.
+// Other values (structs, pointers, maps, slices, arrays, funcs...) are pushed as a userdata with
+// the metatable of their go type, see pushGoMetatable.
+func (self *luaState) PushGoValue(value interface{}) {
+	switch x := value.(type) {
+	case nil:
+		self.PushNil()
+		return
+	case GoFunction:
+		self.PushGoFunction(x)
+		return
+	case func(LuaState) int:
+		self.PushGoFunction(x)
+		return
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Bool:
+		self.PushBoolean(v.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		self.PushInteger(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if u := v.Uint(); u > math.MaxInt64 { /* out of the range of the integers */
+			self.PushNumber(float64(u))
+		} else {
+			self.PushInteger(int64(u))
+		}
+	case reflect.Float32, reflect.Float64:
+		self.PushNumber(v.Float())
+	case reflect.String:
+		self.PushString(v.String())
+	case reflect.Ptr, reflect.Map, reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		if v.IsNil() {
+			self.PushNil()
+			return
+		}
+		fallthrough
+	default:
+		self.NewUserData(value)
+		self.pushGoMetatable(v.Type())
+		self.SetMetatable(-2)
+	}
+}
+
+/*
+	@description
+		Push the metatable of the values of the go type, it is made once and cached by the state:
+			obj.Name	the method of the go type, or the exported field of the struct (or the
+				pointer to the struct). The methods come first. A field of the struct or an
+				element of an array which can be addressed is pushed as a pointer, so
+				obj.Pos.X = 1 changes the go value.
+			obj[k]	the element of the map, or the element k of the slice or the array,
+				from 1 like the lua sequences; nil if there is none.
+			obj.Name = v, obj[k] = v	set the field or the element, a nil deletes the key of a map
+			#obj	the length of the slice, the array, the map, the channel or the string
+			obj(...)	call the func
+			obj1 == obj2	compare the go values with ==
+			tostring(obj)	the String() or Error() of the value if it has one
+		The lua arguments are converted to the types of the parameters by toGoValue, and the
+		results are pushed by PushGoValue. If the last result is an error, it is raised when it
+		is not nil and it is not pushed.
+*/
+func (self *luaState) pushGoMetatable(t reflect.Type) {
+	if mt, ok := self.goTypes[t]; ok {
+		self.stack.push(mt)
+		return
+	}
+
+	self.stack.check(4)
+	self.CreateTable(0, 8)
+	self.PushString(t.String())
+	self.SetField(-2, "__name")
+	self.CreateTable(0, t.NumMethod()) /* the methods, the upvalue of __index */
+	for i := 0; i < t.NumMethod(); i++ {
+		m := t.Method(i)
+		self.PushGoFunction(func(ls LuaState) int {
+			checkGoReceiver(ls, t)
+			return callGoFunc(ls, m.Func, 1, 0) /* the receiver is self */
+		})
+		self.SetField(-2, m.Name)
+	}
+	self.PushGoClosure(goIndex, 1)
+	self.SetField(-2, "__index")
+	self.SetFuncs(goMetamethods, 0)
+	if t.Kind() == reflect.Func {
+		self.PushGoFunction(goCall)
+		self.SetField(-2, "__call")
+	}
+	if t.Implements(errorType) || t.Implements(reflect.TypeOf((*fmt.Stringer)(nil)).Elem()) {
+		self.PushGoFunction(goToString)
+		self.SetField(-2, "__tostring")
+	}
+	self.goTypes[t] = self.stack.get(-1).(*luaTable)
+}
+
+var goMetamethods = FuncReg{
+	"__newindex": goNewIndex,
+	"__len":      goLen,
+	"__eq":       goEq,
+}
+
+// The go value of the userdata at idx, through the pointer to it if there is one.
+func toGoElem(ls LuaState, idx int) reflect.Value {
+	v := reflect.ValueOf(ls.ToUserData(idx))
+	if v.Kind() == reflect.Ptr && !v.IsNil() {
+		return v.Elem()
+	}
+	return v
+}
+
+// The exported field of the struct.
+func goField(v reflect.Value, name string) (reflect.Value, bool) {
+	if f, ok := v.Type().FieldByName(name); ok && f.PkgPath == "" {
+		return v.FieldByIndex(f.Index), true
+	}
+	return reflect.Value{}, false
+}
+
+// The index from 1 of the element of the slice or the array at idx.
+func goElemIndex(ls LuaState, v reflect.Value, idx int) (int, bool) {
+	if ls.Type(idx) == LUA_TNUMBER {
+		if i, ok := ls.ToIntegerX(idx); ok && i >= 1 && i <= int64(v.Len()) {
+			return int(i - 1), true
+		}
+	}
+	return 0, false
+}
+
+// Push the field or the element, as a pointer if it is a struct or an array which can be addressed.
+func pushGoElem(ls LuaState, v reflect.Value) {
+	if !v.CanInterface() {
+		ls.PushNil()
+	} else if k := v.Kind(); v.CanAddr() && (k == reflect.Struct || k == reflect.Array) {
+		ls.PushGoValue(v.Addr().Interface())
+	} else {
+		ls.PushGoValue(v.Interface())
+	}
+}
+
+func goIndex(ls LuaState) int {
+	if ls.Type(2) == LUA_TSTRING {
+		ls.PushValue(2)
+		if ls.RawGet(LuaUpvalueIndex(1)) != LUA_TNIL { /* a method? */
+			return 1
+		}
+		ls.Pop(1)
+	}
+
+	v := toGoElem(ls, 1)
+	switch v.Kind() {
+	case reflect.Struct:
+		if ls.Type(2) == LUA_TSTRING {
+			if f, ok := goField(v, ls.ToString(2)); ok {
+				pushGoElem(ls, f)
+				return 1
+			}
+		}
+	case reflect.Map:
+		if k, ok := toGoValue(ls, 2, v.Type().Key()); ok {
+			if e := v.MapIndex(k); e.IsValid() {
+				pushGoElem(ls, e)
+				return 1
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if i, ok := goElemIndex(ls, v, 2); ok {
+			pushGoElem(ls, v.Index(i))
+			return 1
+		}
+	}
+	ls.PushNil()
+	return 1
+}
+
+func goNewIndex(ls LuaState) int {
+	v := toGoElem(ls, 1)
+	switch v.Kind() {
+	case reflect.Struct:
+		name, _ := ls.ToStringX(2)
+		f, ok := goField(v, name)
+		if !ok {
+			return ls.Error2("no field '%s' in %s", name, v.Type())
+		}
+		if !f.CanSet() {
+			return ls.Error2("cannot assign to field '%s' of %s value", name, v.Type())
+		}
+		f.Set(checkGoValue(ls, 3, f.Type(), "field '"+name+"'"))
+	case reflect.Map:
+		k, ok := toGoValue(ls, 2, v.Type().Key())
+		if !ok {
+			return ls.Error2("%s expected as key of %s, got %s", v.Type().Key(), v.Type(), typeArgName(ls, 2))
+		}
+		if ls.IsNil(3) {
+			v.SetMapIndex(k, reflect.Value{}) /* delete the key */
+		} else {
+			v.SetMapIndex(k, checkGoValue(ls, 3, v.Type().Elem(), "element of "+v.Type().String()))
+		}
+	case reflect.Slice, reflect.Array:
+		i, ok := goElemIndex(ls, v, 2)
+		if !ok {
+			return ls.Error2("index out of range [1, %d] of %s", v.Len(), v.Type())
+		}
+		e := v.Index(i)
+		if !e.CanSet() {
+			return ls.Error2("cannot assign to element of %s value", v.Type())
+		}
+		e.Set(checkGoValue(ls, 3, e.Type(), "element of "+v.Type().String()))
+	default:
+		return ls.Error2("attempt to index a %s value", v.Type())
+	}
+	return 0
+}
+
+func goLen(ls LuaState) int {
+	v := toGoElem(ls, 1)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Chan, reflect.String:
+		ls.PushInteger(int64(v.Len()))
+		return 1
+	}
+	return ls.Error2("attempt to get length of a %s value", v.Type())
+}
+
+func goEq(ls LuaState) int {
+	a, b := ls.ToUserData(1), ls.ToUserData(2)
+	t := reflect.TypeOf(a)
+	ls.PushBoolean(t != nil && t == reflect.TypeOf(b) && t.Comparable() && a == b)
+	return 1
+}
+
+func goCall(ls LuaState) int {
+	return callGoFunc(ls, reflect.ValueOf(ls.ToUserData(1)), 2, 1)
+}
+
+func goToString(ls LuaState) int {
+	switch x := ls.ToUserData(1).(type) {
+	case error:
+		ls.PushString(x.Error())
+	case fmt.Stringer:
+		ls.PushString(x.String())
+	}
+	return 1
+}
+
+/*
+	@description
+		Call the go func with the lua arguments from first, and push its results.
+		The arguments are counted from skip + 1 in the messages of errors.
+	@param
+		first	int	"index of the first argument"
+		skip	int	"number of values under the arguments, not seen by the caller"
+	@return
+		n	int	"number of results pushed"
+*/
+func callGoFunc(ls LuaState, fn reflect.Value, first, skip int) int {
+	t := fn.Type()
+	nIn := t.NumIn()
+	args := make([]reflect.Value, 0, nIn)
+	for i := 0; i < nIn; i++ {
+		idx := first + i
+		if t.IsVariadic() && i == nIn-1 {
+			for ; idx <= ls.GetTop(); idx++ {
+				args = append(args, checkGoArg(ls, idx, t.In(i).Elem(), skip))
+			}
+			break
+		}
+		args = append(args, checkGoArg(ls, idx, t.In(i), skip))
+	}
+
+	results := fn.Call(args)
+	n := len(results)
+	if n > 0 && t.Out(n-1) == errorType {
+		n--
+		if err := results[n]; !err.IsNil() {
+			ls.Error2("%s", err.Interface().(error).Error())
+		}
+	}
+	ls.CheckStack2(n, "too many results")
+	for _, r := range results[:n] {
+		ls.PushGoValue(r.Interface())
+	}
+	return n
+}
+
+func checkGoArg(ls LuaState, idx int, t reflect.Type, skip int) reflect.Value {
+	v, ok := toGoValue(ls, idx, t)
+	if !ok {
+		ls.ArgError(idx-skip, fmt.Sprintf("%s expected, got %s", t, typeArgName(ls, idx)))
+	}
+	return v
+}
+
+// The receiver of a method must be a userdata of the go type, such as obj in obj:Name().
+func checkGoReceiver(ls LuaState, t reflect.Type) {
+	if ls.Type(1) != LUA_TUSERDATA || reflect.TypeOf(ls.ToUserData(1)) != t {
+		ls.ArgError(1, fmt.Sprintf("%s expected, got %s", t, typeArgName(ls, 1)))
+	}
+}
+
+func checkGoValue(ls LuaState, idx int, t reflect.Type, what string) reflect.Value {
+	v, ok := toGoValue(ls, idx, t)
+	if !ok {
+		ls.Error2("%s expected for %s, got %s", t, what, typeArgName(ls, idx))
+	}
+	return v
+}
+
+func typeArgName(ls LuaState, idx int) string {
+	return ls.(*luaState).typeArgName(idx)
+}
+
+/*
+	@description
+		Convert the lua value at idx to the go type:
+			booleans	from booleans
+			integers	from numbers and strings with an integer value which fits in the type
+			floats	from numbers and strings
+			strings	from strings and numbers
+			interfaces	from nil, booleans, numbers (int64 or float64) and strings
+				which implement the interface
+		Values of any type are also taken from userdata which hold a value assignable to it, or
+		a pointer to such a value, and nil is the zero value of pointers, maps, slices, funcs,
+		channels and interfaces.
+	@return
+		v	reflect.Value	"the go value"
+		ok	bool	"false if the lua value can not be converted"
+*/
+func toGoValue(ls LuaState, idx int, t reflect.Type) (reflect.Value, bool) {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		if ls.Type(idx) == LUA_TBOOLEAN {
+			v.SetBool(ls.ToBoolean(idx))
+			return v, true
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n, ok := ls.ToIntegerX(idx); ok && !v.OverflowInt(n) {
+			v.SetInt(n)
+			return v, true
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n, ok := ls.ToIntegerX(idx); ok && n >= 0 && !v.OverflowUint(uint64(n)) {
+			v.SetUint(uint64(n))
+			return v, true
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, ok := ls.ToNumberX(idx); ok {
+			v.SetFloat(n)
+			return v, true
+		}
+	case reflect.String:
+		if ls.IsString(idx) {
+			v.SetString(ls.ToString(idx))
+			return v, true
+		}
+	}
+
+	var x interface{}
+	switch ls.Type(idx) {
+	case LUA_TNONE, LUA_TNIL:
+		return v, isNillable(t) /* the zero value */
+	case LUA_TBOOLEAN:
+		x = ls.ToBoolean(idx)
+	case LUA_TNUMBER:
+		if ls.IsInteger(idx) {
+			x = ls.ToInteger(idx)
+		} else {
+			x = ls.ToNumber(idx)
+		}
+	case LUA_TSTRING:
+		x = ls.ToString(idx)
+	case LUA_TUSERDATA, LUA_TLIGHTUSERDATA:
+		x = ls.ToUserData(idx)
+	default:
+		return v, false
+	}
+
+	if xv := reflect.ValueOf(x); !xv.IsValid() { /* a userdata of nil */
+		return v, isNillable(t)
+	} else if xv.Type().AssignableTo(t) {
+		v.Set(xv)
+		return v, true
+	} else if xv.Kind() == reflect.Ptr && !xv.IsNil() && xv.Elem().Type().AssignableTo(t) {
+		v.Set(xv.Elem())
+		return v, true
+	}
+	return v, false
+}
+
+func isNillable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan, reflect.Interface, reflect.UnsafePointer:
+		return true
+	}
+	return false
+}
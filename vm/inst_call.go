@@ -60,8 +60,9 @@ func tailCall(i Instruction, vm LuaVM) {
 	// todo: optimize tail call!
 	c := 0
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	vm.Call(nArgs, c-1)
-	_popResults(a, c, vm)
+	if vm.PreCall(nArgs, c-1) {
+		_popResults(a, c, vm)
+	}
 }
 
 /*
@@ -76,10 +77,11 @@ func call(i Instruction, vm LuaVM) {
 	a += 1
 	//Push function and arguments to the top of the current stack.
 	nArgs := _pushFuncAndArgs(a, b, vm)
-	//Call function.
-	vm.Call(nArgs, c-1)
-	//Pop the results and assign to the registers in the current stack.
-	_popResults(a, c, vm)
+	//Call function. A lua function is run by the interpreter loop, which pops its results when it returns.
+	if vm.PreCall(nArgs, c-1) {
+		//Pop the results and assign to the registers in the current stack.
+		_popResults(a, c, vm)
+	}
 }
 
 /*
@@ -147,6 +149,7 @@ func _popResults(a, c int, vm LuaVM) {
 func _return(i Instruction, vm LuaVM) {
 	a, b, _ := i.ABC()
 	a += 1
+	vm.CloseUpvalues(1) // the closures outlive the registers of the function
 
 	if b == 1 {
 		// no return values
@@ -46,6 +46,13 @@ import (
 func forPrep(i Instruction, vm LuaVM) {
 	a, sBx := i.AsBx()
 	a += 1 // register index should add 1 to transfer to stack index， stack index from 1, register index from 0.
+	if !vm.IsNumber(a) {
+		vm.RunError("'for' initial value must be a number")
+	} else if !vm.IsNumber(a + 1) {
+		vm.RunError("'for' limit must be a number")
+	} else if !vm.IsNumber(a + 2) {
+		vm.RunError("'for' step must be a number")
+	}
 	// Transfer type from string to number
 	if vm.Type(a) == LUA_TSTRING {
 		vm.PushNumber(vm.ToNumber(a))
@@ -107,20 +114,34 @@ func forLoop(i Instruction, vm LuaVM) {
 /*
 	@description
 	TFORLOOP
-	if R(A+1) ~= nil then {
-		R(A)=R(A+1); pc += sBx
-	}
-	TFORCALL
-	R(A+3),R(A+2+C) := R(A)R(R(A+1),R(A+2));
+	R(A+3), ... ,R(A+2+C) := R(A)(R(A+1), R(A+2));
+	if R(A+3) ~= nil then R(A+2)=R(A+3) else pc++
 
-	If key (R(a+1)) is not nil, copy R(A+1) to R(A). This means get the next key and put in control variable for next loop.
+	Call the generator with the state and the control variable. If the first result (the key) is not nil,
+	copy it to the control variable for the next loop, and the following JMP goes back to the loop.
+	Otherwise skip the JMP to exit the loop.
 */
 func tForLoop(i Instruction, vm LuaVM) {
-	a, sBx := i.AsBx()
+	a, _, c := i.ABC()
 	a += 1
 
-	if !vm.IsNil(a + 1) {
-		vm.Copy(a+1, a)
-		vm.AddPC(sBx)
+	vm.CheckStack(3)
+	vm.PushValue(a)     // generator
+	vm.PushValue(a + 1) // state
+	vm.PushValue(a + 2) // control variable
+	if vm.PreCall(2, c) {
+		_finishTForLoop(a, c, vm)
+	}
+}
+
+// Assign the results of the generator to R(A+3), ... ,R(A+2+C) and decide whether the loop goes on.
+func _finishTForLoop(a, c int, vm LuaVM) {
+	for j := a + 2 + c; j >= a+3; j-- {
+		vm.Replace(j)
+	}
+	if !vm.IsNil(a + 3) {
+		vm.Copy(a+3, a+2)
+	} else {
+		vm.AddPC(1)
 	}
 }
@@ -5,15 +5,16 @@ import . "goluar/api"
 /*
 	@description
 		1. push nil into the top of stack.
-		2. copy nil at the top of stack into a, a+1, ... ,a+b registers
+		2. copy nil at the top of stack into a, a+1, ... ,b registers
 		3. pop nil from the top of stack.
-		R(A), R(A+1), ..., R(A+B) := nil
+		R(A) := ... := R(B) := nil
 */
 func loadNil(i Instruction, vm LuaVM) {
 	a, b, _ := i.ABC()
 	a += 1 // register index should add 1 to transfer to stack index， stack index from 1, register index from 0.
+	b += 1
 	vm.PushNil()
-	for i := a; i <= a+b; i++ {
+	for i := a; i <= b; i++ {
 		vm.Copy(-1, i)
 	}
 	vm.Pop(1)
@@ -17,12 +17,20 @@ func move(i Instruction, vm LuaVM) {
 /*
 	@description
 		Add pc count by sBx, so that the command jump 'sBx' steps.
-		pc+=sBx; if (A) close all upvalues >= R(A - 1)
+		pc+=sBx
 */
 func jmp(i Instruction, vm LuaVM) {
-	a, sBx := i.AsBx()
+	_, sBx := i.AsBx()
 	vm.AddPC(sBx)
-	if a != 0 {
-		vm.CloseUpvalues(a)
-	}
+}
+
+/*
+	@description
+		Close the upvalues of the local variables which go out of scope, the closures
+		created in the scope keep their own copies.
+		close all variables in the stack up to (>=) R(A)
+*/
+func _close(i Instruction, vm LuaVM) {
+	a, _, _ := i.ABC()
+	vm.CloseUpvalues(a + 1)
 }
@@ -52,6 +52,33 @@ func setTable(i Instruction, vm LuaVM) {
 	vm.SetTable(a) //a as t,pop b from tack ,pop c from stack. t[b]=c
 }
 
+/*
+	@description
+		Get the global variable named by the constant Bx from the environment of the running
+		function, the same as indexing the environment table, so __index works.
+		R(A) := Gbl[Kst(Bx)]
+*/
+func getGlobal(i Instruction, vm LuaVM) {
+	a, bx := i.ABx()
+	a += 1
+	vm.GetConst(bx)
+	vm.GetTable(LUA_ENVIRONINDEX)
+	vm.Replace(a)
+}
+
+/*
+	@description
+		Assign the value in register A to the global variable named by the constant Bx.
+		Gbl[Kst(Bx)] := R(A)
+*/
+func setGlobal(i Instruction, vm LuaVM) {
+	a, bx := i.ABx()
+	a += 1
+	vm.GetConst(bx)
+	vm.PushValue(a)
+	vm.SetTable(LUA_ENVIRONINDEX)
+}
+
 /*
 	@description
 		Set list. The list is the array in the table.
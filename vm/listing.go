@@ -0,0 +1,265 @@
+package vm
+
+import (
+	"fmt"
+	. "goluar/common"
+	"io"
+	"strings"
+)
+
+/*
+	@description
+		Print the function proto and its sub functions in the format of 'luac -l'.
+		Every instruction is decoded by the opcodes table, with the line number, the operands
+		and the annotations of the constants, upvalue names and jump targets.
+		If full is true, constants, local variables and upvalue names are printed after the code,
+		the same as 'luac -l -l'.
+	@param
+		w		io.Writer	"where the listing is written to"
+		f		*FuncProto	"the function proto"
+		full	bool		"whether to print constants, locals and upvalues"
+*/
+func PrintFunction(w io.Writer, f *FuncProto, full bool) {
+	printHeader(w, f)
+	printCode(w, f)
+	if full {
+		printDebug(w, f)
+	}
+	for _, p := range f.Protos {
+		PrintFunction(w, p, full)
+	}
+}
+
+/*
+	main <hello.lua:0,0> (4 instructions, 16 bytes at 0xc000010000)
+	0+ params, 2 slots, 0 upvalues, 0 locals, 2 constants, 0 functions
+*/
+func printHeader(w io.Writer, f *FuncProto) {
+	funcType := "function"
+	if f.StartLine == 0 {
+		funcType = "main"
+	}
+	varargFlag := ""
+	if f.IsVararg != 0 {
+		varargFlag = "+"
+	}
+
+	fmt.Fprintf(w, "\n%s <%s:%d,%d> (%s, %d bytes at %p)\n",
+		funcType, sourceName(f.Source), f.StartLine, f.EndLine,
+		plural(len(f.Instructions), "instruction"), len(f.Instructions)*INSTRUCTION_SIZE, f)
+	fmt.Fprintf(w, "%d%s param%s, %s, %s, ",
+		f.NumParams, varargFlag, pluralSuffix(int(f.NumParams)),
+		plural(int(f.MaxStackSize), "slot"), plural(int(f.UpvalueCount), "upvalue"))
+	fmt.Fprintf(w, "%s, %s, %s\n",
+		plural(len(f.LocVars), "local"), plural(len(f.Constants), "constant"), plural(len(f.Protos), "function"))
+}
+
+/*
+	One line for each instruction:
+		pc	[line]	name	operands	; annotation
+	Constant operands are printed as negative numbers, -1 is the first constant.
+*/
+func printCode(w io.Writer, f *FuncProto) {
+	code := f.Instructions
+	for pc := 0; pc < len(code); pc++ {
+		i := Instruction(code[pc])
+		line := "-"
+		if pc < len(f.LineInfo) && f.LineInfo[pc] > 0 {
+			line = fmt.Sprintf("%d", f.LineInfo[pc])
+		}
+		fmt.Fprintf(w, "\t%d\t[%s]\t%-9s\t", pc+1, line, strings.TrimSpace(i.OpName()))
+
+		op := i.Opcode()
+		a, b, c := i.ABC()
+		_, bx := i.ABx()
+		_, sBx := i.AsBx()
+		switch i.OpMode() {
+		case IABC:
+			fmt.Fprintf(w, "%d", a)
+			if i.BMode() != OpArgN {
+				fmt.Fprintf(w, " %d", rkOperand(b))
+			}
+			if i.CMode() != OpArgN {
+				fmt.Fprintf(w, " %d", rkOperand(c))
+			}
+		case IABx:
+			if i.BMode() == OpArgK {
+				fmt.Fprintf(w, "%d %d", a, -1-bx)
+			} else {
+				fmt.Fprintf(w, "%d %d", a, bx)
+			}
+		case IAsBx:
+			if op == OP_JMP {
+				fmt.Fprintf(w, "%d", sBx)
+			} else {
+				fmt.Fprintf(w, "%d %d", a, sBx)
+			}
+		}
+
+		switch op {
+		case OP_LOADK:
+			fmt.Fprintf(w, "\t; %s", constantToString(f, bx))
+		case OP_GETUPVAL, OP_SETUPVAL:
+			name := "-"
+			if b < len(f.UpvalueNames) {
+				name = f.UpvalueNames[b]
+			}
+			fmt.Fprintf(w, "\t; %s", name)
+		case OP_GETGLOBAL, OP_SETGLOBAL:
+			if bx < len(f.Constants) {
+				fmt.Fprintf(w, "\t; %v", f.Constants[bx])
+			}
+		case OP_GETTABLE, OP_SELF:
+			if isK(c) {
+				fmt.Fprintf(w, "\t; %s", constantToString(f, indexK(c)))
+			}
+		case OP_SETTABLE, OP_ADD, OP_SUB, OP_MUL, OP_DIV, OP_MOD, OP_POW, OP_EQ, OP_LT, OP_LE:
+			if isK(b) || isK(c) {
+				fmt.Fprintf(w, "\t; %s %s", rkToString(f, b), rkToString(f, c))
+			}
+		case OP_JMP, OP_FORLOOP, OP_FORPREP:
+			fmt.Fprintf(w, "\t; to %d", sBx+pc+2)
+		case OP_CLOSURE:
+			if bx < len(f.Protos) {
+				fmt.Fprintf(w, "\t; %p", f.Protos[bx])
+			}
+		case OP_SETLIST:
+			if c == 0 && pc+1 < len(code) { // the batch number is stored in the next instruction
+				pc++
+				fmt.Fprintf(w, "\t; %d", code[pc])
+			} else {
+				fmt.Fprintf(w, "\t; %d", c)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+/*
+	constants (n) for 0x...:
+		index	value
+	locals (n) for 0x...:
+		index	name	startpc	endpc
+	upvalues (n) for 0x...:
+		index	name
+*/
+func printDebug(w io.Writer, f *FuncProto) {
+	fmt.Fprintf(w, "constants (%d) for %p:\n", len(f.Constants), f)
+	for i := range f.Constants {
+		fmt.Fprintf(w, "\t%d\t%s\n", i+1, constantToString(f, i))
+	}
+	fmt.Fprintf(w, "locals (%d) for %p:\n", len(f.LocVars), f)
+	for i, locVar := range f.LocVars {
+		fmt.Fprintf(w, "\t%d\t%s\t%d\t%d\n", i, locVar.VarName, locVar.StartPC+1, locVar.EndPC+1)
+	}
+	fmt.Fprintf(w, "upvalues (%d) for %p:\n", len(f.UpvalueNames), f)
+	for i, name := range f.UpvalueNames {
+		fmt.Fprintf(w, "\t%d\t%s\n", i, name)
+	}
+}
+
+// The name of the chunk without the leading '@' or '='.
+func sourceName(source string) string {
+	switch {
+	case source == "":
+		return "?" // stripped
+	case source[0] == '@' || source[0] == '=':
+		return source[1:]
+	case strings.HasPrefix(source, SIGNATURE[:1]):
+		return "(bstring)"
+	default:
+		return "(string)"
+	}
+}
+
+func plural(n int, word string) string {
+	return fmt.Sprintf("%d %s%s", n, word, pluralSuffix(n))
+}
+
+func pluralSuffix(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// Whether the RK operand is an index of the constants table, otherwise it is a register.
+func isK(rk int) bool {
+	return rk > 0xFF
+}
+
+func indexK(rk int) int {
+	return rk & 0xFF
+}
+
+// Constants are shown as negative numbers: -1 is the first constant.
+func rkOperand(rk int) int {
+	if isK(rk) {
+		return -1 - indexK(rk)
+	}
+	return rk
+}
+
+// The constant of the RK operand, or '-' if it is a register.
+func rkToString(f *FuncProto, rk int) string {
+	if isK(rk) {
+		return constantToString(f, indexK(rk))
+	}
+	return "-"
+}
+
+func constantToString(f *FuncProto, idx int) string {
+	if idx >= len(f.Constants) {
+		return "?"
+	}
+	switch k := f.Constants[idx].(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return fmt.Sprintf("%t", k)
+	case int64:
+		return FormatNumber(float64(k))
+	case float64:
+		return FormatNumber(k)
+	case string:
+		return quoteString(k)
+	default:
+		return "?"
+	}
+}
+
+// Quote the string in the way of 'luac -l', non-printable characters are written as \ddd.
+func quoteString(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			sb.WriteString(`\"`)
+		case '\\':
+			sb.WriteString(`\\`)
+		case '\a':
+			sb.WriteString(`\a`)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\r':
+			sb.WriteString(`\r`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\v':
+			sb.WriteString(`\v`)
+		default:
+			if c >= ' ' && c < 0x7F {
+				sb.WriteByte(c)
+			} else {
+				fmt.Fprintf(&sb, `\%03d`, c)
+			}
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
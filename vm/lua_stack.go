@@ -1,6 +1,9 @@
 package vm
 
-import . "goluar/common"
+import (
+	. "goluar/api"
+	. "goluar/common"
+)
 
 type luaStack struct {
 	/* virtual stack */
@@ -14,6 +17,18 @@ type luaStack struct {
 	varargs []luaValue
 	openuvs map[int]*upvalue
 	pc      int
+	//the number of results wanted by the caller, they are moved to the caller by postCall.
+	nResults int
+	//a lua function entered by a new run of the interpreter loop, which returns with the function.
+	fresh bool
+	/* continuation of the go function, see CallK */
+	k    KFunction
+	ctx  int
+	kept []luaValue // the values under the values yielded by YieldK, given back to k
+	/* protected call of PCallK, it catches the errors raised after a yield */
+	pcall   bool
+	errFunc luaValue // the message handler
+	oldTop  int      // where the called function was
 	/* linked list */
 	prev *luaStack
 }
@@ -28,9 +43,16 @@ func newLuaStack(size int, state *luaState) *luaStack {
 
 func (self *luaStack) check(n int) {
 	free := len(self.slots) - self.top
+	if free >= n {
+		return
+	}
 	for i := free; i < n; i++ {
 		self.slots = append(self.slots, nil)
 	}
+	// the slots may be moved, open upvalues must follow them
+	for idx, openuv := range self.openuvs {
+		openuv.val = &self.slots[idx]
+	}
 }
 
 func (self *luaStack) push(val luaValue) {
@@ -75,7 +97,7 @@ func (self *luaStack) popN(n int) []luaValue {
 }
 
 func (self *luaStack) absIndex(idx int) int {
-	if idx >= 0 || idx <= LUA_REGISTRYINDEX {
+	if idx >= 0 || idx <= LUA_ENVIRONINDEX {
 		return idx
 	}
 	return idx + self.top + 1
@@ -87,7 +109,7 @@ func (self *luaStack) isValid(idx int) bool {
 		c := self.closure
 		return c != nil && uvIdx < len(c.upvals)
 	}
-	if idx == LUA_REGISTRYINDEX {
+	if idx == LUA_REGISTRYINDEX || idx == LUA_ENVIRONINDEX {
 		return true
 	}
 	absIdx := self.absIndex(idx)
@@ -112,6 +134,10 @@ func (self *luaStack) get(idx int) luaValue {
 		return self.state.registry
 	}
 
+	if idx == LUA_ENVIRONINDEX {
+		return self.env()
+	}
+
 	absIdx := self.absIndex(idx)
 	if absIdx > 0 && absIdx <= self.top {
 		return self.slots[absIdx-1]
@@ -138,6 +164,14 @@ func (self *luaStack) set(idx int, val luaValue) {
 		return
 	}
 
+	if idx == LUA_ENVIRONINDEX {
+		if self.closure == nil {
+			panic("no calling environment")
+		}
+		self.closure.env = val.(*luaTable)
+		return
+	}
+
 	absIdx := self.absIndex(idx)
 	if absIdx > 0 && absIdx <= self.top {
 		self.slots[absIdx-1] = val
@@ -154,3 +188,14 @@ func (self *luaStack) reverse(from, to int) {
 		to--
 	}
 }
+
+/*
+	The environment table of the running function, where its global variables live.
+	It is the table of globals if no function is running.
+*/
+func (self *luaStack) env() *luaTable {
+	if c := self.closure; c != nil && c.env != nil {
+		return c.env
+	}
+	return self.state.env
+}
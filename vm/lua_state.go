@@ -3,28 +3,38 @@ package vm
 import (
 	. "goluar/api"
 	. "goluar/common"
+	"math/rand"
+	"reflect"
 )
 
 type luaState struct {
-	registry *luaTable //registry table
+	registry *luaTable                  //registry table
+	env      *luaTable                  //the table of globals of the thread, see setfenv(0, t)
+	rand     *rand.Rand                 //the random generator of math.random, shared by the threads
+	goTypes  map[reflect.Type]*luaTable //the metatables of the go values, shared by the threads
 	stack    *luaStack
+	nCalls   int  //number of stacks of the thread, the depth of nested calls
+	overflow bool //whether the error of a stack overflow is handled, the calls may go beyond LUAI_MAXCALLS
 	/* coroutine */
 	coStatus int
-	coCaller *luaState
-	coChan   chan int
+	nny      int //number of non-yieldable calls on the go stack, the thread can yield if it is 0
 }
 
 /*
 	@description
 		Initialize a luaState.
 		Initialize a registry of the luaState. Create a new luaStack and push it to the stack of the luaState
+		The random generator is seeded with 1, like srand of C, so the sequence is the same
+		on every machine until SeedRandom is called.
 */
 func New() LuaState {
-	ls := &luaState{}
+	ls := &luaState{rand: rand.New(rand.NewSource(1)), goTypes: map[reflect.Type]*luaTable{}, nny: 1}
 	registry := newLuaTable(8, 0)
 	registry.put(LUA_RIDX_MAINTHREAD, ls)
-	registry.put(LUA_RIDX_GLOBALS, newLuaTable(0, 20))
+	globals := newLuaTable(0, 20)
+	registry.put(LUA_RIDX_GLOBALS, globals)
 	ls.registry = registry
+	ls.env = globals
 	ls.pushLuaStack(newLuaStack(LUA_MINSTACK, ls))
 	return ls
 }
@@ -33,7 +43,24 @@ func (self *luaState) isMainThread() bool {
 	return self.registry.get(LUA_RIDX_MAINTHREAD) == self
 }
 
+/*
+	@description
+		Push the stack of the called function. The calls do not grow the go stack, so the depth is
+		limited by LUAI_MAXCALLS. "stack overflow" is raised when the depth first crosses it, then
+		the error handling has 200 more calls before the overflow while handling the overflow.
+		lua-5.1.5/src/ldo.c#luaD_growCI()
+*/
 func (self *luaState) pushLuaStack(stack *luaStack) {
+	if self.nCalls >= LUAI_MAXCALLS {
+		if !self.overflow {
+			self.overflow = true
+			self.runError("stack overflow")
+		}
+		if self.nCalls >= LUAI_MAXCALLS+200 { /* overflow while handling overflow */
+			panic("error in error handling")
+		}
+	}
+	self.nCalls++
 	stack.prev = self.stack
 	self.stack = stack
 }
@@ -42,4 +69,16 @@ func (self *luaState) popLuaStack() {
 	stack := self.stack
 	self.stack = stack.prev
 	stack.prev = nil
+	self.nCalls--
+}
+
+/*
+	@description
+		Give back the calls of the error handling once the stacks of a stack overflow are unwound.
+		lua-5.1.5/src/ldo.c#restore_stack_limit()
+*/
+func (self *luaState) restoreStackLimit() {
+	if self.overflow && self.nCalls < LUAI_MAXCALLS {
+		self.overflow = false
+	}
 }
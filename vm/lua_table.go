@@ -3,25 +3,40 @@ package vm
 import (
 	"goluar/common"
 	"math"
+	"math/bits"
+	"reflect"
 )
 
+const MAXBITS = 26 // the array part has at most 2^MAXBITS elements
+
+/*
+	A lua table, the port of the Table of lua 5.1. The keys from 1 to len(arr) are in the array
+	part, the other keys are in the hash part: node is a chained scatter table with Brent's
+	variation, its size is 0 or a power of 2. The sizes of both parts are computed again only
+	when a new key finds the hash part full, see rehash.
+	A key whose value is set to nil stays in its node until the next rehash, so a traversal
+	goes on from it while the fields are cleared by the loop.
+	lua-5.1.5/src/ltable.c
+*/
 type luaTable struct {
 	metatable *luaTable
 	arr       []luaValue
-	_map      map[luaValue]luaValue
-	keys      map[luaValue]luaValue // used by next(),mapping from key to next key.
-	lastKey   luaValue              // used by next()
-	changed   bool                  // used by next()
+	node      []luaNode
+	lastFree  int // all the nodes from lastFree are used
+}
+
+type luaNode struct {
+	key  luaValue
+	val  luaValue
+	next int // index of the next node of the chain, -1 at the end
 }
 
 func newLuaTable(nArr, nRec int) *luaTable {
 	t := &luaTable{}
 	if nArr > 0 {
-		t.arr = make([]luaValue, 0, nArr)
-	}
-	if nRec > 0 {
-		t._map = make(map[luaValue]luaValue, nRec)
+		t.arr = make([]luaValue, nArr)
 	}
+	t.setNodeVector(nRec)
 	return t
 }
 
@@ -30,18 +45,71 @@ func (self *luaTable) hasMetafield(fieldName string) bool {
 		self.metatable.get(fieldName) != nil
 }
 
+/*
+	@description
+		A border of the table, a n such that t[n] is not nil and t[n+1] is nil (0 if t[1] is nil).
+		It is searched in the array part if its last element is nil, or else from the end of the
+		array part in the hash part. Both # and RawLen take it.
+		lua-5.1.5/src/ltable.c#luaH_getn()
+*/
 func (self *luaTable) len() int {
-	return len(self.arr)
+	j := len(self.arr)
+	if j > 0 && self.arr[j-1] == nil {
+		/* there is a boundary in the array part: (binary) search for it */
+		i := 0
+		for j-i > 1 {
+			m := (i + j) / 2
+			if self.arr[m-1] == nil {
+				j = m
+			} else {
+				i = m
+			}
+		}
+		return i
+	} else if len(self.node) == 0 { /* hash part is empty? */
+		return j /* that is easy... */
+	}
+	return int(self.unboundSearch(int64(j)))
+}
+
+// lua-5.1.5/src/ltable.c#unbound_search()
+func (self *luaTable) unboundSearch(j int64) int64 {
+	i := j /* i is zero or a present index */
+	j++
+	/* find `i' and `j' such that i is present and j is not */
+	for self.get(j) != nil {
+		i = j
+		if j > math.MaxInt64/2 { /* overflow? */
+			/* table was built with bad purposes: resort to linear search */
+			i = 1
+			for self.get(i) != nil {
+				i++
+			}
+			return i - 1
+		}
+		j *= 2
+	}
+	/* now do a binary search between them */
+	for j-i > 1 {
+		m := (i + j) / 2
+		if self.get(m) == nil {
+			j = m
+		} else {
+			i = m
+		}
+	}
+	return i
 }
 
 func (self *luaTable) get(key luaValue) luaValue {
 	key = _floatToInteger(key)
-	if idx, ok := key.(int64); ok {
-		if idx >= 1 && idx <= int64(len(self.arr)) {
-			return self.arr[idx-1]
-		}
+	if idx, ok := key.(int64); ok && uint64(idx-1) < uint64(len(self.arr)) {
+		return self.arr[idx-1]
 	}
-	return self._map[key]
+	if n := self.getNode(key); n >= 0 {
+		return self.node[n].val
+	}
+	return nil
 }
 
 func _floatToInteger(key luaValue) luaValue {
@@ -53,93 +121,318 @@ func _floatToInteger(key luaValue) luaValue {
 	return key
 }
 
+// The key is not nil or NaN, it is checked by luaState.setTable.
 func (self *luaTable) put(key, val luaValue) {
-	if key == nil {
-		panic("table index is nil!")
+	key = _floatToInteger(key)
+	if idx, ok := key.(int64); ok && uint64(idx-1) < uint64(len(self.arr)) {
+		self.arr[idx-1] = val
+		return
 	}
-	if f, ok := key.(float64); ok && math.IsNaN(f) {
-		panic("table index is NaN!")
+	if n := self.getNode(key); n >= 0 {
+		self.node[n].val = val /* a nil value keeps the key in its node */
+		return
+	}
+	if val != nil {
+		self.newKey(key, val)
 	}
+}
 
-	self.changed = true
-	key = _floatToInteger(key)
-	if idx, ok := key.(int64); ok && idx >= 1 {
-		arrLen := int64(len(self.arr))
-		if idx <= arrLen {
-			self.arr[idx-1] = val
-			if idx == arrLen && val == nil {
-				self._shrinkArray()
-			}
+// The index of the node of the key, or -1.
+func (self *luaTable) getNode(key luaValue) int {
+	if len(self.node) == 0 || key == nil { /* nil is never a key */
+		return -1
+	}
+	for n := self.mainPosition(key); n >= 0; n = self.node[n].next {
+		if self.node[n].key == key {
+			return n
+		}
+	}
+	return -1
+}
+
+// lua-5.1.5/src/ltable.c#mainposition()
+func (self *luaTable) mainPosition(key luaValue) int {
+	switch x := key.(type) {
+	case string:
+		return int(hashString(x) & uint32(len(self.node)-1))
+	case bool:
+		if x {
+			return 1 & (len(self.node) - 1)
+		}
+		return 0
+	case int64:
+		return self.hashMod(uint64(x))
+	case float64:
+		return self.hashMod(math.Float64bits(x))
+	case lightUserdata:
+		return self.hashMod(hashGoValue(x.value))
+	default: /* tables, functions, threads and userdata */
+		return self.hashMod(uint64(reflect.ValueOf(key).Pointer()))
+	}
+}
+
+// The numbers and the pointers are hashed by a modulo of an odd number, their low bits are not spread enough.
+func (self *luaTable) hashMod(h uint64) int {
+	return int(h % uint64((len(self.node)-1)|1))
+}
+
+// lua-5.1.5/src/lstring.c#luaS_newlstr()
+func hashString(s string) uint32 {
+	l := len(s)
+	h := uint32(l)       /* seed */
+	step := (l >> 5) + 1 /* if string is too long, don't hash all its chars */
+	for l1 := l; l1 >= step; l1 -= step {
+		h = h ^ ((h << 5) + (h >> 2) + uint32(s[l1-1]))
+	}
+	return h
+}
+
+// The hash of the value of a light userdata. The values which are neither pointers nor
+// scalars share the same hash.
+func hashGoValue(value interface{}) uint64 {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.UnsafePointer, reflect.Chan:
+		return uint64(v.Pointer())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint()
+	case reflect.Float32, reflect.Float64:
+		return math.Float64bits(v.Float())
+	case reflect.String:
+		return uint64(hashString(v.String()))
+	}
+	return 0
+}
+
+/*
+	@description
+		Insert a new key into the hash part. If its main position is taken by a key which is not
+		in its own main position, that key is moved to a free node; otherwise the new key goes
+		to the free node, chained after the main position.
+		lua-5.1.5/src/ltable.c#newkey()
+*/
+func (self *luaTable) newKey(key, val luaValue) {
+	if len(self.node) == 0 {
+		self.rehash(key)
+		self.put(key, val)
+		return
+	}
+	mp := self.mainPosition(key)
+	if self.node[mp].val != nil { /* main position is taken? */
+		f := self.getFreePos()
+		if f < 0 { /* cannot find a free place? */
+			self.rehash(key) /* grow table */
+			self.put(key, val)
 			return
 		}
-		if idx == arrLen+1 {
-			delete(self._map, key)
-			if val != nil {
-				self.arr = append(self.arr, val)
-				self._expandArray()
+		othern := self.mainPosition(self.node[mp].key)
+		if othern != mp {
+			/* yes; move colliding node into free position */
+			for self.node[othern].next != mp { /* find previous */
+				othern = self.node[othern].next
 			}
-			return
+			self.node[othern].next = f   /* redo the chain with `f' in place of `mp' */
+			self.node[f] = self.node[mp] /* copy colliding node into free pos. (mp.next also goes) */
+			self.node[mp].next = -1      /* now `mp' is free */
+			self.node[mp].val = nil
+		} else {
+			/* colliding node is in its own main position */
+			/* new node will go into free position */
+			self.node[f].next = self.node[mp].next /* chain new position */
+			self.node[mp].next = f
+			mp = f
 		}
 	}
-	if val != nil {
-		if self._map == nil {
-			self._map = make(map[luaValue]luaValue, 8)
+	self.node[mp].key = key
+	self.node[mp].val = val
+}
+
+// lua-5.1.5/src/ltable.c#getfreepos()
+func (self *luaTable) getFreePos() int {
+	for self.lastFree > 0 {
+		self.lastFree--
+		if self.node[self.lastFree].key == nil {
+			return self.lastFree
 		}
-		self._map[key] = val
-	} else {
-		delete(self._map, key)
 	}
+	return -1 /* could not find a free place */
 }
 
-func (self *luaTable) _shrinkArray() {
-	for i := len(self.arr) - 1; i >= 0; i-- {
-		if self.arr[i] == nil {
-			self.arr = self.arr[0:i]
-		} else {
-			break
+// lua-5.1.5/src/ltable.c#setnodevector()
+func (self *luaTable) setNodeVector(size int) {
+	if size == 0 { /* no elements to hash part? */
+		self.node = nil
+		self.lastFree = 0
+		return
+	}
+	lsize := ceilLog2(size)
+	if lsize > MAXBITS {
+		panic("table overflow")
+	}
+	size = 1 << uint(lsize)
+	self.node = make([]luaNode, size)
+	for i := range self.node {
+		self.node[i].next = -1
+	}
+	self.lastFree = size /* all positions are free */
+}
+
+// lua-5.1.5/src/ltable.c#resize()
+func (self *luaTable) resize(nasize, nhsize int) {
+	oldArr, oldNode := self.arr, self.node
+	if nasize != len(oldArr) {
+		self.arr = make([]luaValue, nasize)
+		copy(self.arr, oldArr)
+	}
+	self.setNodeVector(nhsize)
+	/* re-insert vanishing slice */
+	for i := nasize; i < len(oldArr); i++ {
+		if oldArr[i] != nil {
+			self.put(int64(i+1), oldArr[i])
+		}
+	}
+	/* re-insert elements from hash part */
+	for i := len(oldNode) - 1; i >= 0; i-- {
+		if old := &oldNode[i]; old.val != nil {
+			self.put(old.key, old.val)
 		}
 	}
 }
 
-func (self *luaTable) _expandArray() {
-	for idx := int64(len(self.arr)) + 1; true; idx++ {
-		if val, found := self._map[idx]; found {
-			delete(self._map, idx)
-			self.arr = append(self.arr, val)
-		} else {
-			break
+/*
+	@description
+		Resize both parts of the table for its keys and the new key ek. The array part is the
+		largest n such that more than half of the slots from 1 to n are used.
+		lua-5.1.5/src/ltable.c#rehash()
+*/
+func (self *luaTable) rehash(ek luaValue) {
+	var nums [MAXBITS + 1]int            /* nums[i] = number of keys between 2^(i-1) and 2^i */
+	nasize := self.numUseArray(&nums)    /* count keys in array part */
+	totaluse := nasize                   /* all those keys are integer keys */
+	ause, huse := self.numUseHash(&nums) /* count keys in hash part */
+	nasize += ause
+	totaluse += huse
+	/* count extra key */
+	nasize += countInt(ek, &nums)
+	totaluse++
+	/* compute new size for array part */
+	nasize, na := computeSizes(&nums, nasize)
+	/* resize the table to new computed sizes */
+	self.resize(nasize, totaluse-na)
+}
+
+// lua-5.1.5/src/ltable.c#computesizes()
+func computeSizes(nums *[MAXBITS + 1]int, narray int) (size, na int) {
+	a := 0 /* number of elements smaller than 2^i */
+	for i, twotoi := 0, 1; twotoi/2 < narray; i, twotoi = i+1, twotoi*2 {
+		if nums[i] > 0 {
+			a += nums[i]
+			if a > twotoi/2 { /* more than half elements present? */
+				size = twotoi /* optimal size (till now) */
+				na = a        /* all elements smaller than size will go to array part */
+			}
+		}
+		if a == narray {
+			break /* all elements already counted */
 		}
 	}
+	return
 }
 
-func (self *luaTable) nextKey(key luaValue) luaValue {
-	if self.keys == nil || (key == nil && self.changed) {
-		self.initKeys()
-		self.changed = false
+// lua-5.1.5/src/ltable.c#countint()
+func countInt(key luaValue, nums *[MAXBITS + 1]int) int {
+	if k, ok := _floatToInteger(key).(int64); ok && k > 0 && k <= 1<<MAXBITS { /* is `key' an appropriate array index? */
+		nums[ceilLog2(int(k))]++ /* count as such */
+		return 1
 	}
+	return 0
+}
+
+// lua-5.1.5/src/ltable.c#numusearray()
+func (self *luaTable) numUseArray(nums *[MAXBITS + 1]int) int {
+	ause := 0 /* summation of `nums' */
+	i := 1    /* count to traverse all array keys */
+
+	for lg, ttlg := 0, 1; lg <= MAXBITS; lg, ttlg = lg+1, ttlg*2 { /* for each slice */
+		lc := 0 /* counter */
+		lim := ttlg
+		if lim > len(self.arr) {
+			lim = len(self.arr) /* adjust upper limit */
+			if i > lim {
+				break /* no more elements to count */
+			}
+		}
+		/* count elements in range (2^(lg-1), 2^lg] */
+		for ; i <= lim; i++ {
+			if self.arr[i-1] != nil {
+				lc++
+			}
+		}
+		nums[lg] += lc
+		ause += lc
+	}
+	return ause
+}
 
-	nextKey := self.keys[key]
-	if nextKey == nil && key != nil && key != self.lastKey {
-		panic("invalid key to 'next'")
+// lua-5.1.5/src/ltable.c#numusehash()
+func (self *luaTable) numUseHash(nums *[MAXBITS + 1]int) (ause, totaluse int) {
+	for i := len(self.node) - 1; i >= 0; i-- {
+		if n := &self.node[i]; n.val != nil {
+			ause += countInt(n.key, nums)
+			totaluse++
+		}
 	}
+	return
+}
 
-	return nextKey
+// ceil(log2(x)), x > 0
+func ceilLog2(x int) int {
+	return bits.Len(uint(x - 1))
 }
 
-func (self *luaTable) initKeys() {
-	self.keys = make(map[luaValue]luaValue)
-	var key luaValue = nil
-	for i, v := range self.arr {
-		if v != nil {
-			self.keys[key] = int64(i + 1)
-			key = int64(i + 1)
+/*
+	@description
+		The key and the value of the field after the key, in the array part then in the hash part.
+		The key is found in O(1), even if its value was set to nil during the traversal.
+		lua-5.1.5/src/ltable.c#luaH_next()
+	@return
+		nextKey	luaValue	"nil if there is no more field"
+		val		luaValue	"the value of the field"
+		ok		bool		"false if the key is not in the table"
+*/
+func (self *luaTable) next(key luaValue) (nextKey, val luaValue, ok bool) {
+	i := self.findIndex(key) /* find original element */
+	if i == -2 {
+		return nil, nil, false /* invalid key */
+	}
+
+	for i++; i < len(self.arr); i++ { /* try first array part */
+		if self.arr[i] != nil {
+			return int64(i + 1), self.arr[i], true
 		}
 	}
-	for k, v := range self._map {
-		if v != nil {
-			self.keys[key] = k
-			key = k
+	for i -= len(self.arr); i < len(self.node); i++ { /* then hash part */
+		if n := &self.node[i]; n.val != nil {
+			return n.key, n.val, true
 		}
 	}
-	self.lastKey = key
+	return nil, nil, true /* no more elements */
+}
+
+// lua-5.1.5/src/ltable.c#findindex()
+func (self *luaTable) findIndex(key luaValue) int {
+	if key == nil {
+		return -1 /* first iteration */
+	}
+	key = _floatToInteger(key)
+	if idx, ok := key.(int64); ok && uint64(idx-1) < uint64(len(self.arr)) { /* is `key' inside array part? */
+		return int(idx - 1) /* yes; that's the index */
+	}
+	if n := self.getNode(key); n >= 0 {
+		/* hash elements are numbered after array ones */
+		return len(self.arr) + n
+	}
+	return -2 /* key not found */
 }
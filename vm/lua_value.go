@@ -24,8 +24,12 @@ func typeOf(val luaValue) LuaType {
 		return common.LUA_TFUNCTION
 	case *luaState:
 		return common.LUA_TTHREAD
+	case *userdata:
+		return common.LUA_TUSERDATA
+	case lightUserdata:
+		return common.LUA_TLIGHTUSERDATA
 	default:
-		panic("todo!")
+		return common.LUA_TNONE
 	}
 }
 
@@ -81,8 +85,11 @@ func _stringToInteger(s string) (int64, bool) {
 /* metatable */
 
 func getMetatable(val luaValue, ls *luaState) *luaTable {
-	if t, ok := val.(*luaTable); ok {
-		return t.metatable
+	switch x := val.(type) {
+	case *luaTable:
+		return x.metatable
+	case *userdata:
+		return x.metatable
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
 	if mt := ls.registry.get(key); mt != nil {
@@ -92,8 +99,12 @@ func getMetatable(val luaValue, ls *luaState) *luaTable {
 }
 
 func setMetatable(val luaValue, mt *luaTable, ls *luaState) {
-	if t, ok := val.(*luaTable); ok {
-		t.metatable = mt
+	switch x := val.(type) {
+	case *luaTable:
+		x.metatable = mt
+		return
+	case *userdata:
+		x.metatable = mt
 		return
 	}
 	key := fmt.Sprintf("_MT%d", typeOf(val))
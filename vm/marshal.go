@@ -0,0 +1,354 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	. "goluar/api"
+	. "goluar/common"
+	"reflect"
+	"strings"
+)
+
+/*
+	The field of a struct seen by lua, named by its lua tag:
+		Host	string	`lua:"host"`	the field host of the table
+		Port	int	`lua:",omitempty"`	the field Port, not set by Marshal if it is 0
+		Cache	*Cache	`lua:"-"`	not seen by lua
+*/
+type luaField struct {
+	name      string
+	index     []int
+	omitEmpty bool
+}
+
+// The exported fields of the struct. The fields of an embedded struct without a name in its
+// tag are seen as fields of the struct itself.
+func luaFields(t reflect.Type) []luaField {
+	var fields []luaField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("lua")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.IndexByte(tag, ','); j >= 0 {
+			name, opts = tag[:j], tag[j+1:]
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			for _, e := range luaFields(f.Type) {
+				e.index = append([]int{i}, e.index...)
+				fields = append(fields, e)
+			}
+			continue
+		}
+		if f.PkgPath != "" { /* unexported */
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, luaField{name, []int{i}, opts == "omitempty"})
+	}
+	return fields
+}
+
+// [-0, +1, m]
+// Push the go value, with its structs, maps, slices and arrays as nested tables: a struct is a
+// table of its fields (see luaField), a slice or an array a sequence, and a []byte a string.
+// nil pointers, maps and slices are nil, the other values are pushed by PushGoValue. Nothing is
+// pushed if the value has a cycle.
+func (self *luaState) Marshal(value interface{}) error {
+	top := self.GetTop()
+	if err := self.marshal(reflect.ValueOf(value), "", map[uintptr]bool{}); err != nil {
+		self.SetTop(top)
+		return err
+	}
+	return nil
+}
+
+func (self *luaState) marshal(v reflect.Value, path string, visiting map[uintptr]bool) error {
+	self.stack.check(3)
+	switch v.Kind() {
+	case reflect.Invalid:
+		self.PushNil()
+	case reflect.Interface:
+		return self.marshal(v.Elem(), path, visiting)
+	case reflect.Ptr:
+		if v.IsNil() {
+			self.PushNil()
+			return nil
+		}
+		if visiting[v.Pointer()] {
+			return fmt.Errorf("%scycle of %s", pathPrefix(path), v.Type())
+		}
+		visiting[v.Pointer()] = true
+		defer delete(visiting, v.Pointer())
+		return self.marshal(v.Elem(), path, visiting)
+	case reflect.Struct:
+		fields := luaFields(v.Type())
+		self.CreateTable(0, len(fields))
+		for _, f := range fields {
+			fv := v.FieldByIndex(f.index)
+			if f.omitEmpty && isEmptyValue(fv) {
+				continue
+			}
+			if err := self.marshal(fv, pathField(path, f.name), visiting); err != nil {
+				return err
+			}
+			self.SetField(-2, f.name)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			self.PushNil()
+			return nil
+		}
+		if visiting[v.Pointer()] {
+			return fmt.Errorf("%scycle of %s", pathPrefix(path), v.Type())
+		}
+		visiting[v.Pointer()] = true
+		defer delete(visiting, v.Pointer())
+		self.CreateTable(0, v.Len())
+		for _, k := range v.MapKeys() {
+			kpath := fmt.Sprintf("%s[%v]", path, k)
+			if err := self.marshal(k, kpath, visiting); err != nil {
+				return err
+			}
+			if self.IsNil(-1) { /* a nil key can not be set */
+				self.Pop(1)
+				continue
+			}
+			if err := self.marshal(v.MapIndex(k), kpath, visiting); err != nil {
+				return err
+			}
+			self.SetTable(-3)
+		}
+	case reflect.Slice:
+		if v.IsNil() {
+			self.PushNil()
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			self.PushString(string(v.Bytes()))
+			return nil
+		}
+		if visiting[v.Pointer()] {
+			return fmt.Errorf("%scycle of %s", pathPrefix(path), v.Type())
+		}
+		visiting[v.Pointer()] = true
+		defer delete(visiting, v.Pointer())
+		fallthrough
+	case reflect.Array:
+		self.CreateTable(v.Len(), 0)
+		for i := 0; i < v.Len(); i++ {
+			if err := self.marshal(v.Index(i), fmt.Sprintf("%s[%d]", path, i+1), visiting); err != nil {
+				return err
+			}
+			self.SetI(-2, int64(i+1))
+		}
+	default:
+		self.PushGoValue(v.Interface())
+	}
+	return nil
+}
+
+// encoding/json/encode.go#isEmptyValue()
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+/*
+	@description
+		Decode the lua value at idx into the go value pointed by v. The scalars are converted
+		like the arguments of the go funcs bound by PushGoValue, and the tables are decoded into:
+			structs	the fields of the table named by the lua tags (see luaField),
+				the fields which are nil in the table are kept
+			maps	all the pairs of the table
+			slices and arrays	the sequence of the table
+			pointers	the values they point to, allocated if they are nil
+			interface{}	a []interface{} if the table is a non empty sequence, a
+				map[string]interface{} if its keys are strings, or else a
+				map[interface{}]interface{}
+		A string is also decoded into a []byte. The error tells the path of the value which can
+		not be decoded, such as "servers[2].port: int expected, got string". The lua errors
+		raised by the metamethods of the tables are returned as the error too.
+*/
+func (self *luaState) Unmarshal(idx int, v interface{}) (err error) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("unmarshal into non-pointer %T", v)
+	}
+	caller, top := self.stack, self.GetTop()
+	defer func() {
+		if e := recover(); e != nil {
+			if _, ok := e.(*ExitError); ok {
+				panic(e)
+			}
+			for self.stack != caller {
+				self.popLuaStack()
+			}
+			self.restoreStackLimit()
+			switch x := e.(type) {
+			case error: // go runtime error
+				err = x
+			case string:
+				err = errors.New(x)
+			default:
+				err = fmt.Errorf("(error object is a %s value)", self.TypeName(typeOf(x)))
+			}
+		}
+		self.SetTop(top)
+	}()
+	return self.unmarshal(self.AbsIndex(idx), rv.Elem(), "", map[*luaTable]bool{})
+}
+
+func (self *luaState) unmarshal(idx int, v reflect.Value, path string, visiting map[*luaTable]bool) error {
+	self.stack.check(3)
+	t := v.Type()
+	tbl, isTable := self.stack.get(idx).(*luaTable)
+	if !isTable {
+		if x, ok := toGoValue(self, idx, t); ok {
+			v.Set(x)
+			return nil
+		}
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 && self.Type(idx) == LUA_TSTRING {
+			v.SetBytes([]byte(self.ToString(idx)))
+			return nil
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return self.unmarshal(idx, v.Elem(), path, visiting)
+	case reflect.Interface:
+		if isTable && t.NumMethod() == 0 {
+			x := reflect.New(self.tableType(idx)).Elem()
+			if err := self.unmarshal(idx, x, path, visiting); err != nil {
+				return err
+			}
+			v.Set(x)
+			return nil
+		}
+	case reflect.Struct, reflect.Map, reflect.Slice, reflect.Array:
+		if isTable {
+			if visiting[tbl] {
+				return fmt.Errorf("%scycle of the table", pathPrefix(path))
+			}
+			visiting[tbl] = true
+			defer delete(visiting, tbl)
+			return self.unmarshalTable(idx, v, path, visiting)
+		}
+	}
+	return fmt.Errorf("%s%s expected, got %s", pathPrefix(path), t, self.typeArgName(idx))
+}
+
+func (self *luaState) unmarshalTable(idx int, v reflect.Value, path string, visiting map[*luaTable]bool) error {
+	t := v.Type()
+	switch t.Kind() {
+	case reflect.Struct:
+		for _, f := range luaFields(t) {
+			if self.GetField(idx, f.name) != LUA_TNIL {
+				if err := self.unmarshal(self.GetTop(), v.FieldByIndex(f.index), pathField(path, f.name), visiting); err != nil {
+					return err
+				}
+			}
+			self.Pop(1)
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(t))
+		}
+		self.PushNil()
+		for self.Next(idx) {
+			top := self.GetTop()
+			var kpath string
+			if self.Type(top-1) == LUA_TSTRING {
+				kpath = pathField(path, self.ToString(top-1))
+			} else {
+				kpath = path + "[" + self.ToString2(top-1) + "]"
+				self.Pop(1) /* the string of the key */
+			}
+			/* decode a copy of the key, the key of next must not be converted to a string */
+			self.PushValue(top - 1)
+			k := reflect.New(t.Key()).Elem()
+			if err := self.unmarshal(top+1, k, kpath, visiting); err != nil {
+				return err
+			}
+			self.Pop(1)
+			if kt := reflect.TypeOf(k.Interface()); kt != nil && !kt.Comparable() {
+				return fmt.Errorf("%s%s can not be a key", pathPrefix(kpath), kt)
+			}
+			e := reflect.New(t.Elem()).Elem()
+			if err := self.unmarshal(top, e, kpath, visiting); err != nil {
+				return err
+			}
+			v.SetMapIndex(k, e)
+			self.Pop(1)
+		}
+	case reflect.Slice, reflect.Array:
+		n := int(self.RawLen(idx))
+		if t.Kind() == reflect.Slice {
+			v.Set(reflect.MakeSlice(t, n, n))
+		} else if n > v.Len() {
+			return fmt.Errorf("%s%d elements for %s", pathPrefix(path), n, t)
+		}
+		for i := 0; i < n; i++ {
+			self.RawGetI(idx, int64(i+1))
+			if err := self.unmarshal(self.GetTop(), v.Index(i), fmt.Sprintf("%s[%d]", path, i+1), visiting); err != nil {
+				return err
+			}
+			self.Pop(1)
+		}
+	}
+	return nil
+}
+
+// The go type of the table at idx decoded into an interface{}.
+func (self *luaState) tableType(idx int) reflect.Type {
+	n, count, strKeys := int64(self.RawLen(idx)), int64(0), true
+	self.PushNil()
+	for self.Next(idx) {
+		count++
+		strKeys = strKeys && self.Type(-2) == LUA_TSTRING
+		self.Pop(1)
+	}
+	switch {
+	case n > 0 && count == n:
+		return reflect.TypeOf([]interface{}(nil))
+	case strKeys:
+		return reflect.TypeOf(map[string]interface{}(nil))
+	default:
+		return reflect.TypeOf(map[interface{}]interface{}(nil))
+	}
+}
+
+func pathField(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func pathPrefix(path string) string {
+	if path == "" {
+		return ""
+	}
+	return path + ": "
+}
@@ -0,0 +1,20 @@
+package vm
+
+/*
+	A full userdata, a go value with its own metatable and environment. Without
+	an __eq metamethod, two userdata are equal only if they are the same userdata,
+	whatever the values they hold.
+*/
+type userdata struct {
+	metatable *luaTable
+	env       *luaTable
+	value     interface{}
+}
+
+/*
+	A light userdata, a bare go value. It has no metatable or environment of its
+	own, and two light userdata are equal if their values are equal.
+*/
+type lightUserdata struct {
+	value interface{}
+}
@@ -0,0 +1,328 @@
+package vm
+
+import (
+	"fmt"
+	. "goluar/common"
+	"strings"
+)
+
+const maxStack = 250 // the maximum of MaxStackSize
+
+/*
+	@description
+		Check the function proto and its sub functions before execution, like the
+		bytecode checker of lua 5.1. A precompiled chunk is trusted by the VM, a malformed
+		one could make the VM access registers, constants or protos out of range.
+		It checks that:
+			every opcode exists in the opcodes table
+			register operands are below MaxStackSize
+			constant indices in RK and Bx operands are in range
+			jump targets stay inside the function
+			CLOSURE indexes valid Protos and is followed by the pseudo instructions of its upvalues
+			upvalue indices are below UpvalueCount
+	@param
+		proto	*FuncProto	"the proto of the main function"
+	@return
+		err		error		"nil if the proto is valid"
+*/
+func Verify(proto *FuncProto) error {
+	v := &verifier{proto: proto}
+	if err := v.verify(); err != nil {
+		return err
+	}
+	for _, p := range proto.Protos {
+		if err := Verify(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type verifier struct {
+	proto *FuncProto
+	pc    int // the instruction being checked
+}
+
+func (self *verifier) errorf(format string, a ...interface{}) error {
+	f := self.proto
+	msg := fmt.Sprintf(format, a...)
+	if self.pc < 0 {
+		return fmt.Errorf("bad code in precompiled chunk: function at line %d: %s", f.StartLine, msg)
+	}
+	name := "?"
+	if self.pc < len(f.Instructions) {
+		if i := Instruction(f.Instructions[self.pc]); i.Opcode() < len(opcodes) {
+			name = strings.TrimSpace(i.OpName())
+		}
+	}
+	return fmt.Errorf("bad code in precompiled chunk: function at line %d, pc %d (%s): %s",
+		f.StartLine, self.pc+1, name, msg)
+}
+
+func (self *verifier) verify() error {
+	f := self.proto
+	self.pc = -1
+	if f.MaxStackSize > maxStack {
+		return self.errorf("stack size %d is too large", f.MaxStackSize)
+	} else if int(f.NumParams) > int(f.MaxStackSize) {
+		return self.errorf("%d parameters exceed the stack size %d", f.NumParams, f.MaxStackSize)
+	} else if len(f.LineInfo) != 0 && len(f.LineInfo) != len(f.Instructions) {
+		return self.errorf("line info does not match the code")
+	} else if len(f.UpvalueNames) != 0 && len(f.UpvalueNames) != int(f.UpvalueCount) {
+		return self.errorf("upvalue names do not match the upvalues")
+	} else if len(f.Instructions) == 0 || Instruction(f.Instructions[len(f.Instructions)-1]).Opcode() != OP_RETURN {
+		return self.errorf("function does not end with RETURN")
+	}
+
+	code := f.Instructions
+	for self.pc = 0; self.pc < len(code); self.pc++ {
+		pc := self.pc
+		i := Instruction(code[pc])
+		op := i.Opcode()
+		if op >= len(opcodes) {
+			return self.errorf("unknown opcode %d", op)
+		}
+		a, b, c := i.ABC()
+		if op != OP_JMP { // A of JMP is not a register
+			if err := self.checkReg(a); err != nil {
+				return err
+			}
+		}
+
+		switch i.OpMode() {
+		case IABC:
+			if err := self.checkArg(b, i.BMode()); err != nil {
+				return err
+			}
+			if err := self.checkArg(c, i.CMode()); err != nil {
+				return err
+			}
+		case IABx:
+			_, b = i.ABx()
+			if i.BMode() == OpArgK && b >= len(f.Constants) {
+				return self.errorf("constant index %d out of range", b)
+			}
+		case IAsBx:
+			_, b = i.AsBx()
+			if err := self.checkJump(pc + 1 + b); err != nil {
+				return err
+			}
+		}
+
+		if opcodes[op].testFlag != 0 { // the next instruction is a jump
+			if pc+2 >= len(code) || Instruction(code[pc+1]).Opcode() != OP_JMP {
+				return self.errorf("test is not followed by a jump")
+			}
+		}
+
+		if err := self.checkOp(i, a, b, c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+	Check the operands for the specific opcodes.
+	b is Bx or sBx for the instructions of IABx and IAsBx mode.
+*/
+func (self *verifier) checkOp(i Instruction, a, b, c int) error {
+	f := self.proto
+	code := f.Instructions
+	pc := self.pc
+	switch i.Opcode() {
+	case OP_LOADBOOL:
+		if c != 0 && (pc+2 >= len(code) || isSetListCount(code, pc+1)) {
+			return self.errorf("bad skip")
+		}
+	case OP_NEWTABLE:
+		if tableSizeTooBig(b) || tableSizeTooBig(c) {
+			return self.errorf("table size too big")
+		}
+	case OP_GETUPVAL, OP_SETUPVAL:
+		if b >= int(f.UpvalueCount) {
+			return self.errorf("upvalue index %d out of range", b)
+		}
+	case OP_GETGLOBAL, OP_SETGLOBAL:
+		if _, ok := f.Constants[b].(string); !ok {
+			return self.errorf("global name is not a string")
+		}
+	case OP_SELF:
+		return self.checkReg(a + 1)
+	case OP_CONCAT:
+		if b >= c {
+			return self.errorf("less than two operands to concatenate")
+		}
+	case OP_TFORLOOP:
+		if c < 1 {
+			return self.errorf("no control variable")
+		}
+		return self.checkReg(a + 2 + c)
+	case OP_FORLOOP, OP_FORPREP:
+		return self.checkReg(a + 3)
+	case OP_CALL, OP_TAILCALL:
+		if b != 0 {
+			if err := self.checkReg(a + b - 1); err != nil {
+				return err
+			}
+		}
+		if c == 0 { // multiple results
+			return self.checkOpenOp(pc)
+		} else if c > 1 {
+			return self.checkReg(a + c - 2)
+		}
+	case OP_RETURN:
+		if b > 1 {
+			return self.checkReg(a + b - 2)
+		}
+	case OP_SETLIST:
+		if b > 0 {
+			if err := self.checkReg(a + b); err != nil {
+				return err
+			}
+		}
+		if c == 0 { // the batch number is stored in the next instruction
+			self.pc++
+			if self.pc >= len(code)-1 {
+				return self.errorf("missing batch number")
+			}
+		}
+	case OP_CLOSURE:
+		_, bx := i.ABx()
+		if bx >= len(f.Protos) {
+			return self.errorf("function index %d out of range", bx)
+		}
+		return self.checkUpvalues(f.Protos[bx])
+	case OP_VARARG:
+		if f.IsVararg&VARARG_ISVARARG == 0 || f.IsVararg&VARARG_NEEDSARG != 0 {
+			return self.errorf("VARARG in a function without '...'")
+		}
+		if b == 0 { // all the varargs
+			return self.checkOpenOp(pc)
+		} else if b > 1 {
+			return self.checkReg(a + b - 2)
+		}
+	}
+	return nil
+}
+
+/* The size hint x of NEWTABLE, a "floating point byte", is over the limit of a table. */
+func tableSizeTooBig(x int) bool {
+	return x>>3 > MAXBITS || Fb2int(x) > 1<<MAXBITS /* the exponent first, Fb2int may overflow */
+}
+
+/*
+	CLOSURE is followed by a MOVE or GETUPVAL for each upvalue of the new closure, which must
+	agree with the Upvalues of the sub function. They are skipped as the VM does.
+*/
+func (self *verifier) checkUpvalues(sub *FuncProto) error {
+	code := self.proto.Instructions
+	if len(sub.Upvalues) != int(sub.UpvalueCount) {
+		return self.errorf("upvalues do not match the upvalue count")
+	}
+	for _, uv := range sub.Upvalues {
+		self.pc++
+		if self.pc >= len(code)-1 {
+			return self.errorf("missing upvalue")
+		}
+		i := Instruction(code[self.pc])
+		_, b, _ := i.ABC()
+		switch i.Opcode() {
+		case OP_MOVE:
+			if err := self.checkReg(b); err != nil {
+				return err
+			}
+			if uv.Instack != 1 || int(uv.Idx) != b {
+				return self.errorf("upvalue does not match the local variable")
+			}
+		case OP_GETUPVAL:
+			if b >= int(self.proto.UpvalueCount) {
+				return self.errorf("upvalue index %d out of range", b)
+			}
+			if uv.Instack != 0 || int(uv.Idx) != b {
+				return self.errorf("upvalue does not match the enclosing upvalue")
+			}
+		default:
+			return self.errorf("bad upvalue instruction")
+		}
+	}
+	return nil
+}
+
+// A register index must be below MaxStackSize.
+func (self *verifier) checkReg(r int) error {
+	if r < 0 || r >= int(self.proto.MaxStackSize) {
+		return self.errorf("register %d out of range", r)
+	}
+	return nil
+}
+
+func (self *verifier) checkArg(r int, mode byte) error {
+	switch mode {
+	case OpArgN:
+		if r != 0 {
+			return self.errorf("unused argument is %d", r)
+		}
+	case OpArgR:
+		return self.checkReg(r)
+	case OpArgK:
+		if isK(r) {
+			if indexK(r) >= len(self.proto.Constants) {
+				return self.errorf("constant index %d out of range", indexK(r))
+			}
+		} else {
+			return self.checkReg(r)
+		}
+	}
+	return nil
+}
+
+/*
+	The target of a jump must be inside the function, and must not be the batch number of SETLIST.
+*/
+func (self *verifier) checkJump(dest int) error {
+	code := self.proto.Instructions
+	if dest < 0 || dest >= len(code) {
+		return self.errorf("jump to %d out of range", dest+1)
+	}
+	if isSetListCount(code, dest) {
+		return self.errorf("jump to the batch number of SETLIST")
+	}
+	return nil
+}
+
+/*
+	An instruction which leaves an open number of values (C or B is 0) must be followed by
+	one which consumes them: CALL, TAILCALL, RETURN or SETLIST with B 0.
+*/
+func (self *verifier) checkOpenOp(pc int) error {
+	code := self.proto.Instructions
+	if pc+1 < len(code) {
+		next := Instruction(code[pc+1])
+		_, b, _ := next.ABC()
+		switch next.Opcode() {
+		case OP_CALL, OP_TAILCALL, OP_RETURN, OP_SETLIST:
+			if b == 0 {
+				return nil
+			}
+		}
+	}
+	return self.errorf("open results are not consumed")
+}
+
+/*
+	Whether code[pc] is the batch number after a 'SETLIST A B 0'. A batch number could look
+	like a 'SETLIST A B 0' too, so count the successive ones back: it is a batch number
+	only if the count is odd.
+*/
+func isSetListCount(code []uint32, pc int) bool {
+	n := 0
+	for j := pc - 1; j >= 0; j-- {
+		i := Instruction(code[j])
+		if _, _, c := i.ABC(); i.Opcode() != OP_SETLIST || c != 0 {
+			break
+		}
+		n++
+	}
+	return n%2 == 1
+}